@@ -0,0 +1,178 @@
+package cat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/spf13/cobra"
+	"io"
+	"math"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapdecode"
+	"os"
+)
+
+var (
+	topics    []string
+	startTime string
+	endTime   string
+)
+
+var CatCmd = &cobra.Command{
+	Use:   "cat <file>...",
+	Short: fmt.Sprintf("Print the messages of (%s) files as JSON lines", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Print the messages of (%s) files as JSON lines, in log time order within each file.
+Each line holds the topic, sequence, log time, publish time and the payload decoded with the schema stored in the
+file. The json, ros1 (ros1msg), cdr (ros2msg) and protobuf message encodings are decoded.`,
+		constants.MCAPFIleExtension,
+	),
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		run(args)
+	},
+}
+
+// messageLine is a line of the output.
+type messageLine struct {
+	Topic       string `json:"topic"`
+	Sequence    uint32 `json:"sequence"`
+	LogTime     uint64 `json:"log_time"`
+	PublishTime uint64 `json:"publish_time"`
+	Data        any    `json:"data"`
+}
+
+func init() {
+	CatCmd.
+		Flags().
+		StringSliceVarP(
+			&topics,
+			"topics",
+			"t",
+			nil,
+			"List of topics to print, if unspecified, messages of all topics are printed",
+		)
+
+	CatCmd.
+		Flags().
+		StringVarP(
+			&startTime,
+			"start",
+			"s",
+			"",
+			"Print messages logged at or after this time (prefer RFC3339 or unixnano format, e.g. 2006-01-02T15:04:05+07:00)",
+		)
+
+	CatCmd.
+		Flags().
+		StringVarP(
+			&endTime,
+			"end",
+			"e",
+			"",
+			"Print messages logged at or before this time (prefer RFC3339 or unixnano format, e.g. 2006-01-02T15:04:05+07:00)",
+		)
+}
+
+func run(files []string) {
+	readOpts, err := readOptions()
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	for _, filePath := range files {
+		err := catFile(filePath, readOpts, encoder)
+		if err == nil {
+			continue
+		}
+		out.Flush()
+		logging.GetLogger().Error(fmt.Sprintf("failed to print %s: %s", filePath, err))
+		os.Exit(1)
+	}
+
+	if err := out.Flush(); err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func readOptions() ([]mcap.ReadOpt, error) {
+	readOpts := []mcap.ReadOpt{}
+	if len(topics) > 0 {
+		readOpts = append(readOpts, mcap.WithTopics(utils.RemoveEmptyStrings(topics)))
+	}
+
+	if startTime != "" {
+		start, err := utils.TryParseTimestamp(startTime)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid start time: %s", startTime)
+		}
+		readOpts = append(readOpts, mcap.AfterNanos(uint64(start)))
+	}
+
+	if endTime != "" {
+		end, err := utils.TryParseTimestamp(endTime)
+		if err != nil || end < 0 {
+			return nil, fmt.Errorf("invalid end time: %s", endTime)
+		}
+		if end < math.MaxInt64 {
+			readOpts = append(readOpts, mcap.BeforeNanos(uint64(end)+1))
+		}
+	}
+	return readOpts, nil
+}
+
+func catFile(filePath string, readOpts []mcap.ReadOpt, encoder *json.Encoder) error {
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer mcapFile.Close()
+
+	reader, msgs, ordered, err := utils.ReadMessages(mcapFile, readOpts...)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if !ordered {
+		logging.GetLogger().Warn(fmt.Sprintf("%s has no summary section, printing messages in file order", filePath))
+	}
+
+	decoder := mcapdecode.NewDecoder()
+	msg := &mcap.Message{}
+	for {
+		schema, channel, _, err := msgs.NextInto(msg)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		data, err := decoder.Decode(schema, channel, msg.Data)
+		if err != nil {
+			return fmt.Errorf("failed to decode message on %s at log time %d: %s", channel.Topic, msg.LogTime, err)
+		}
+
+		err = encoder.Encode(messageLine{
+			Topic:       channel.Topic,
+			Sequence:    msg.Sequence,
+			LogTime:     msg.LogTime,
+			PublishTime: msg.PublishTime,
+			Data:        data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
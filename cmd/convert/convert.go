@@ -0,0 +1,403 @@
+package convert
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/spf13/cobra"
+	"io"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapconvert"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var writerOpt *mcap.WriterOptions
+
+var (
+	input            string
+	output           string
+	compression      string
+	compressionLevel int
+	msgPaths         []string
+	to               string
+	chunkSize        string
+)
+
+var bagOpts mcapconvert.BagOptions
+
+// Output formats of --to.
+const (
+	toMCAP = "mcap"
+	toBag  = "bag"
+)
+
+// Input formats, told apart by their extension, or by the metadata.yaml file
+// of rosbag2 directories.
+const (
+	formatBag     = "bag"
+	formatRosbag2 = "rosbag2"
+	formatMCAP    = "mcap"
+)
+
+// db3Extension is the extension of rosbag2 sqlite3 storage files.
+const db3Extension = ".db3"
+
+type inputFile struct {
+	path   string
+	format string
+}
+
+var ConvertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: fmt.Sprintf("Convert ROS 1 and ROS 2 bags to (%s) files, and back to ROS 1 bags", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Convert ROS 1 bag v2.0 (%s) files to (%s) files with the ros1 profile, and ROS 2 rosbag2 sqlite3
+recordings, directories holding a metadata.yaml file or single (%s) files, to (%s) files with the ros2 profile.
+Chunks of ROS 1 bags compressed with bz2 or lz4, and rosbag2 files or messages compressed with zstd, are supported.
+ROS 2 schemas are taken from the message_definitions table of the recording, or rebuilt from the .msg files found
+in --msg-path.
+With --to bag, (%s) files with the ros1 profile are converted to ROS 1 bag v2.0 (%s) files instead.`,
+		constants.BagFileExtension,
+		constants.MCAPFIleExtension,
+		db3Extension,
+		constants.MCAPFIleExtension,
+		constants.MCAPFIleExtension,
+		constants.BagFileExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch to {
+		case toMCAP:
+			var err error
+			writerOpt, err = utils.NewWriterOptions(compression, compressionLevel)
+			return err
+		case toBag:
+			if compression != "" && !slices.Contains(mcapconvert.BagCompressions, compression) {
+				return fmt.Errorf("invalid bag compression: %s (%s)", compression, strings.Join(mcapconvert.BagCompressions, ", "))
+			}
+			bagOpts.Compression = compression
+			if chunkSize != "" {
+				size, err := utils.ParseByteSize(chunkSize)
+				if err != nil {
+					return err
+				}
+				bagOpts.ChunkSize = int(size)
+			}
+			return nil
+		default:
+			return fmt.Errorf("invalid output format: %s (%s or %s)", to, toMCAP, toBag)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+func init() {
+	ConvertCmd.
+		Flags().
+		StringVarP(
+			&input,
+			"input",
+			"i",
+			"",
+			fmt.Sprintf(
+				"Input (%s) or (%s) file, rosbag2 directory, or directory containing them, (%s) files with --to bag",
+				constants.BagFileExtension,
+				db3Extension,
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	ConvertCmd.
+		Flags().
+		StringVarP(
+			&output,
+			"output",
+			"o",
+			"",
+			fmt.Sprintf(
+				"Output directory to save the converted (%s) or (%s) files",
+				constants.MCAPFIleExtension,
+				constants.BagFileExtension,
+			),
+		)
+
+	ConvertCmd.
+		Flags().
+		StringVarP(
+			&compression,
+			"compression",
+			"c",
+			"",
+			fmt.Sprintf(
+				"Compression algorithm used to write (%s) files (zstd or lz4), or bag chunks with --to bag (%s)",
+				constants.MCAPFIleExtension,
+				strings.Join(mcapconvert.BagCompressions, ", "),
+			),
+		)
+
+	ConvertCmd.
+		Flags().
+		IntVarP(
+			&compressionLevel,
+			"compression-level",
+			"n",
+			0,
+			fmt.Sprintf(
+				"Compression level write (%s) files (0:default 1:fastest 2:better 3:best)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	ConvertCmd.
+		Flags().
+		StringSliceVar(
+			&msgPaths,
+			"msg-path",
+			nil,
+			"Share directories holding the ROS 2 message definitions as <package>/msg/<Type>.msg, defaults to the share directories of AMENT_PREFIX_PATH",
+		)
+
+	ConvertCmd.
+		Flags().
+		StringVar(
+			&to,
+			"to",
+			toMCAP,
+			fmt.Sprintf("Output format (%s or %s)", toMCAP, toBag),
+		)
+
+	ConvertCmd.
+		Flags().
+		StringVar(
+			&chunkSize,
+			"chunk-size",
+			"",
+			"Uncompressed size of the chunks of bags written with --to bag, such as 4MB (default 768KiB)",
+		)
+
+	_ = ConvertCmd.MarkFlagRequired("input")
+	_ = ConvertCmd.MarkFlagRequired("output")
+}
+
+func run() {
+	var fileToProcess []inputFile
+	var err error
+	if to == toBag {
+		fileToProcess, err = listMCAPInputs(input)
+	} else {
+		fileToProcess, err = listInputs(input)
+	}
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	logging.GetLogger().Info(fmt.Sprintf("Retrieved %d files to convert", len(fileToProcess)))
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info("No files to convert")
+		os.Exit(0)
+	}
+
+	isSameDir, err := utils.IsSameDirectory(input, output)
+	if err != nil {
+		logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+		os.Exit(1)
+	}
+
+	if isSameDir {
+		logging.GetLogger().Info("Cannot use input directory as output directory")
+		os.Exit(1)
+	}
+
+	// Every input is converted to a file named after its stem, in the
+	// directory mirroring its own, so a bag and a rosbag2 directory with the
+	// same stem collide, which is checked before any file is written.
+	root := input
+	paths := make([]string, 0, len(fileToProcess))
+	for _, file := range fileToProcess {
+		if file.path == input {
+			root = filepath.Dir(input)
+		}
+		paths = append(paths, file.path)
+	}
+	extension := constants.MCAPFIleExtension
+	if to == toBag {
+		extension = constants.BagFileExtension
+	}
+	outputPaths, err := utils.OutputPaths(root, paths, output, "{stem}"+extension)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	if len(msgPaths) == 0 {
+		for _, prefix := range filepath.SplitList(os.Getenv("AMENT_PREFIX_PATH")) {
+			msgPaths = append(msgPaths, filepath.Join(prefix, "share"))
+		}
+	}
+
+	if !utils.IsDirExists(output) {
+		err := utils.CreateDir(output)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		logging.GetLogger().Info("Output directory created")
+	}
+
+	for _, file := range fileToProcess {
+		logging.GetLogger().Info(fmt.Sprintf("Converting %s", file.path))
+		if err := convertFile(file, outputPaths[file.path]); err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+// listInputs returns the bags at path: a ROS 1 bag, a rosbag2 directory or
+// storage file, or a directory searched recursively for them.
+func listInputs(path string) ([]inputFile, error) {
+	isDir, err := utils.IsPathDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		switch {
+		case strings.HasSuffix(path, constants.BagFileExtension):
+			return []inputFile{{path: path, format: formatBag}}, nil
+		case strings.HasSuffix(path, db3Extension):
+			return []inputFile{{path: path, format: formatRosbag2}}, nil
+		default:
+			return nil, fmt.Errorf("input %s does not end with %s or %s extension", path, constants.BagFileExtension, db3Extension)
+		}
+	}
+
+	var files []inputFile
+	err = filepath.WalkDir(path, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// The storage files of a rosbag2 directory are converted together.
+			if utils.IsFileExists(filepath.Join(path, mcapconvert.Rosbag2MetadataFile)) {
+				files = append(files, inputFile{path: path, format: formatRosbag2})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(d.Name(), constants.BagFileExtension):
+			files = append(files, inputFile{path: path, format: formatBag})
+		case strings.HasSuffix(d.Name(), db3Extension):
+			files = append(files, inputFile{path: path, format: formatRosbag2})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// listMCAPInputs returns the MCAP files at path, a file or a directory
+// searched recursively.
+func listMCAPInputs(path string) ([]inputFile, error) {
+	isDir, err := utils.IsPathDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		if !strings.HasSuffix(path, constants.MCAPFIleExtension) {
+			return nil, fmt.Errorf("input %s does not end with %s extension", path, constants.MCAPFIleExtension)
+		}
+		return []inputFile{{path: path, format: formatMCAP}}, nil
+	}
+
+	mcapFiles, err := utils.ListMCAPFilesInDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]inputFile, 0, len(mcapFiles))
+	for _, mcapFile := range mcapFiles {
+		files = append(files, inputFile{path: mcapFile, format: formatMCAP})
+	}
+	return files, nil
+}
+
+func convertFile(file inputFile, outputPath string) error {
+	if err := utils.CreateDir(filepath.Dir(outputPath)); err != nil {
+		return fmt.Errorf("failed to create output directory of %s: %s", outputPath, err)
+	}
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file %s: %s", outputPath, err)
+	}
+
+	var report *mcapconvert.BagReport
+	switch file.format {
+	case formatBag:
+		report, err = convertBag(file.path, outFile)
+	case formatRosbag2:
+		report, err = mcapconvert.DB3ToMCAP(context.Background(), file.path, outFile, writerOpt, msgPaths)
+	case formatMCAP:
+		report, err = convertMCAP(file.path, outFile)
+	}
+	if cErr := outFile.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return fmt.Errorf("failed to convert %s: %s", file.path, err)
+	}
+
+	printReport(file.path, outputPath, report)
+	return nil
+}
+
+func convertBag(filePath string, w io.Writer) (*mcapconvert.BagReport, error) {
+	bagFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer bagFile.Close()
+
+	return mcapconvert.BagToMCAP(context.Background(), bufio.NewReaderSize(bagFile, 1<<20), w, writerOpt)
+}
+
+func convertMCAP(filePath string, w io.WriteSeeker) (*mcapconvert.BagReport, error) {
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer mcapFile.Close()
+
+	return mcapconvert.MCAPToBag(context.Background(), mcapFile, w, bagOpts)
+}
+
+func printReport(filePath string, outputPath string, report *mcapconvert.BagReport) {
+	fmt.Printf("%s -> %s\n", filePath, outputPath)
+	if report.Chunks > 0 {
+		fmt.Printf("  Converted: %d messages, %d chunks, %d schemas, %d channels\n",
+			report.Messages, report.Chunks, report.Schemas, report.Channels)
+	} else {
+		fmt.Printf("  Converted: %d messages, %d schemas, %d channels\n",
+			report.Messages, report.Schemas, report.Channels)
+	}
+	if report.Messages > 0 {
+		fmt.Printf("  Message time range: %d - %d (%s)\n", report.MessageStartTime, report.MessageEndTime,
+			time.Duration(report.MessageEndTime-report.MessageStartTime))
+	}
+	if len(report.UnknownTypes) > 0 {
+		fmt.Printf("  Written without schema, no definition found: %s\n", strings.Join(report.UnknownTypes, ", "))
+	}
+	if len(report.SkippedTopics) > 0 {
+		fmt.Printf("  Left out, not ros1 encoded: %s\n", strings.Join(report.SkippedTopics, ", "))
+	}
+}
@@ -1,13 +1,16 @@
 package edit
 
 import (
+	"context"
 	"fmt"
-	"github.com/foxglove/mcap/go/mcap"
 	"github.com/spf13/cobra"
 	"io"
+	"maps"
 	"mcap-utility/internal/constants"
 	"mcap-utility/internal/logging"
 	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapedit"
+	"mcap-utility/pkg/mcapverify"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -17,22 +20,7 @@ import (
 	"time"
 )
 
-var writerOpt = &mcap.WriterOptions{
-	IncludeCRC:               true,
-	Chunked:                  true,
-	Compression:              mcap.CompressionZSTD,
-	CompressionLevel:         mcap.CompressionLevelDefault,
-	SkipMessageIndexing:      false,
-	SkipStatistics:           false,
-	SkipRepeatedSchemas:      false,
-	SkipRepeatedChannelInfos: false,
-	SkipAttachmentIndex:      false,
-	SkipMetadataIndex:        false,
-	SkipChunkIndex:           false,
-	SkipSummaryOffsets:       false,
-	OverrideLibrary:          false,
-	SkipMagic:                false,
-}
+var editOpt mcapedit.Options
 
 var (
 	input            string
@@ -47,41 +35,142 @@ var (
 	deletes          []string
 	usePubTime       bool
 	compressionLevel int
+	dropAttachments  bool
+	dropMetadata     bool
+	trimAttachments  bool
+	attachmentNames  []string
+	metadataNames    []string
+	order            []string
+	config           string
+	workers          int
+	nameTemplate     string
+	inPlace          bool
+	backupSuffix     string
+	dryRun           bool
+	continueOnError  bool
+	reportPath       string
+	reportFormat     string
+	progressMode     string
 )
 
+// outputPaths maps the input files to their output path.
+var outputPaths map[string]string
+
 var EditCmd = &cobra.Command{
 	Use:   "edit",
 	Short: fmt.Sprintf("Edit the contents of (%s) file or directory", constants.MCAPFIleExtension),
 	Long:  fmt.Sprintf("Edit the contents of (%s) file or directory", constants.MCAPFIleExtension),
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		compressionMapper := map[string]struct{}{
-			"lz4":  {},
-			"zstd": {},
+		if inPlace {
+			if output != "" {
+				return fmt.Errorf("--output cannot be used with --in-place")
+			}
+			if cmd.Flags().Changed("name") {
+				return fmt.Errorf("--name cannot be used with --in-place")
+			}
+		} else {
+			if output == "" {
+				return fmt.Errorf("--output is required unless --in-place is set")
+			}
+			if backupSuffix != "" {
+				return fmt.Errorf("--backup-suffix requires --in-place")
+			}
+		}
+
+		switch progressMode {
+		case progressAuto, progressBar, progressLog, progressNone:
+		default:
+			return fmt.Errorf("invalid progress mode %s, must be %s, %s, %s or %s",
+				progressMode, progressAuto, progressBar, progressLog, progressNone)
+		}
+
+		if reportFormat != reportText && reportFormat != reportJSON {
+			return fmt.Errorf("invalid report format %s, must be %s or %s", reportFormat, reportText, reportJSON)
+		}
+
+		var job *mcapedit.Job
+		if config != "" {
+			var err error
+			job, err = mcapedit.LoadJob(config)
+			if err != nil {
+				return err
+			}
+
+			// Compression flags override the compress operation of the job.
+			if compress := job.Compression(); compress != nil {
+				if !cmd.Flags().Changed("compression") {
+					compression = compress.Format
+				}
+				if !cmd.Flags().Changed("compression-level") {
+					compressionLevel = compress.Level
+				}
+			}
+		}
+
+		writerOpt, err := utils.NewWriterOptions(compression, compressionLevel)
+		if err != nil {
+			return err
+		}
+
+		editOpt = mcapedit.Options{
+			Rename:          rename,
+			Delete:          deletes,
+			Topics:          topics,
+			UsePublishTime:  usePubTime,
+			DropAttachments: dropAttachments,
+			DropMetadata:    dropMetadata,
+			AttachmentNames: attachmentNames,
+			MetadataNames:   metadataNames,
+			TrimAttachments: trimAttachments,
+			Order:           order,
+			WriterOptions:   writerOpt,
+			Recompress:      compression != "" || compressionLevel != 0,
+			Workers:         workers,
+			Logger:          logging.GetLogger(),
+		}
+
+		if trimStart != "" {
+			trimStartTime, err := utils.TryParseTimestamp(trimStart)
+			if err != nil || trimStartTime < 0 {
+				return fmt.Errorf("invalid trim start time: %s", trimStart)
+			}
+			editOpt.TrimStart = uint64(trimStartTime)
+		}
+
+		if trimEnd != "" {
+			trimEndTime, err := utils.TryParseTimestamp(trimEnd)
+			if err != nil || trimEndTime < 0 {
+				return fmt.Errorf("invalid trim end time: %s", trimEnd)
+			}
+			editOpt.TrimEnd = uint64(trimEndTime)
+		}
+
+		if shiftLog != "" {
+			editOpt.ShiftLog, err = time.ParseDuration(shiftLog)
+			if err != nil {
+				return fmt.Errorf("invalid shift log time %s: %s", shiftLog, err)
+			}
 		}
 
-		if compression != "" {
-			compression = strings.TrimSpace(strings.ToLower(compression))
-			if _, ok := compressionMapper[compression]; !ok {
-				return fmt.Errorf("invalid compression: %s", compression)
+		if shiftPublish != "" {
+			editOpt.ShiftPublish, err = time.ParseDuration(shiftPublish)
+			if err != nil {
+				return fmt.Errorf("invalid shift publish time %s: %s", shiftPublish, err)
 			}
-			writerOpt.Compression = mcap.CompressionFormat(compression)
 		}
 
-		compressionLevelMapper := map[int]mcap.CompressionLevel{
-			0: mcap.CompressionLevelDefault,
-			1: mcap.CompressionLevelFastest,
-			2: mcap.CompressionLevelBetter,
-			3: mcap.CompressionLevelBest,
+		if _, err := utils.ExpandNameTemplate(nameTemplate, "input"+constants.MCAPFIleExtension); err != nil {
+			return err
 		}
 
-		if compressionLevel != 0 {
-			if _, ok := compressionLevelMapper[compressionLevel]; !ok {
-				return fmt.Errorf("invalid compression level: %d", compressionLevel)
+		if job != nil {
+			for _, kind := range overrideJob(job, cmd.Flags().Changed) {
+				clearOperation(&editOpt, kind)
 			}
-			writerOpt.CompressionLevel = compressionLevelMapper[compressionLevel]
+			editOpt.Job = job
 		}
 
-		return nil
+		return editOpt.Validate()
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		run()
@@ -111,11 +200,20 @@ func init() {
 			"o",
 			"",
 			fmt.Sprintf(
-				"Output directory to save processed (%s) files",
+				"Output directory to save processed (%s) files, mirroring the subdirectories of the input directory",
 				constants.MCAPFIleExtension,
 			),
 		)
 
+	EditCmd.
+		Flags().
+		StringVar(
+			&nameTemplate,
+			"name",
+			utils.DefaultNameTemplate,
+			"Output file name template, where {name} is the input file name, {stem} the name without extension and {ext} the extension (e.g. {stem}_edited{ext})",
+		)
+
 	EditCmd.Flags().
 		StringToStringVarP(
 			&rename,
@@ -244,14 +342,246 @@ func init() {
 			),
 		)
 
+	EditCmd.
+		Flags().
+		BoolVar(
+			&dropAttachments,
+			"drop-attachments",
+			false,
+			fmt.Sprintf(
+				"Do not copy attachments into the output (%s) files",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	EditCmd.
+		Flags().
+		BoolVar(
+			&dropMetadata,
+			"drop-metadata",
+			false,
+			fmt.Sprintf(
+				"Do not copy metadata records into the output (%s) files",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	EditCmd.
+		Flags().
+		StringSliceVar(
+			&attachmentNames,
+			"attachments",
+			nil,
+			"List of attachment names or glob patterns to keep (e.g. calib/*.yaml), if unspecified, all attachments are kept",
+		)
+
+	EditCmd.
+		Flags().
+		StringSliceVar(
+			&metadataNames,
+			"metadata",
+			nil,
+			"List of metadata record names or glob patterns to keep, if unspecified, all metadata records are kept",
+		)
+
+	EditCmd.
+		Flags().
+		BoolVar(
+			&trimAttachments,
+			"trim-attachments",
+			false,
+			"Drop attachments whose log time is outside of the trim-start and trim-end window",
+		)
+
+	EditCmd.
+		Flags().
+		StringSliceVar(
+			&order,
+			"order",
+			nil,
+			fmt.Sprintf(
+				"Order in which operations are applied (%s), unlisted operations follow in this default order",
+				strings.Join(mcapedit.DefaultOrder, ","),
+			),
+		)
+
+	EditCmd.
+		Flags().
+		StringVar(
+			&config,
+			"config",
+			"",
+			"YAML or JSON job file listing the operations to apply in order, flags override the values of the file",
+		)
+
+	EditCmd.
+		Flags().
+		IntVar(
+			&workers,
+			"workers",
+			0,
+			"Number of goroutines processing the chunks of each file, 0 shares all CPU cores between the files edited at once",
+		)
+
+	EditCmd.
+		Flags().
+		BoolVar(
+			&inPlace,
+			"in-place",
+			false,
+			"Replace the input files with their edited version instead of writing to --output, once it is written and verified",
+		)
+
+	EditCmd.
+		Flags().
+		StringVar(
+			&backupSuffix,
+			"backup-suffix",
+			"",
+			"Keep the original of each file edited with --in-place next to it, with this suffix appended to its name (e.g. .orig)",
+		)
+
+	EditCmd.
+		Flags().
+		BoolVar(
+			&dryRun,
+			"dry-run",
+			false,
+			"Print what would be done to each file, from its summary and message indexes, without writing anything",
+		)
+
+	EditCmd.
+		Flags().
+		BoolVar(
+			&continueOnError,
+			"continue-on-error",
+			false,
+			"Keep editing the other files when one fails, instead of stopping the batch",
+		)
+
+	EditCmd.
+		Flags().
+		StringVar(
+			&reportPath,
+			"report",
+			"",
+			"File to write the report listing the result of every file to, instead of the standard output",
+		)
+
+	EditCmd.
+		Flags().
+		StringVar(
+			&reportFormat,
+			"report-format",
+			reportText,
+			fmt.Sprintf("Report format: %s or %s", reportText, reportJSON),
+		)
+
+	EditCmd.
+		Flags().
+		StringVar(
+			&progressMode,
+			"progress",
+			progressAuto,
+			fmt.Sprintf(
+				"Progress display: %s (a bar on a terminal, log lines otherwise), %s, %s or %s",
+				progressAuto, progressBar, progressLog, progressNone,
+			),
+		)
+
 	_ = EditCmd.MarkFlagRequired("input")
-	_ = EditCmd.MarkFlagRequired("output")
+}
+
+// overrideJob applies the flags set on the command line to the job operations
+// of the same kind, and returns those kinds so they are not applied a second
+// time from the flags.
+func overrideJob(job *mcapedit.Job, changed func(name string) bool) []string {
+	overridden := make([]string, 0, len(job.Operations))
+	for idx := range job.Operations {
+		op := &job.Operations[idx]
+		kind := op.Kind()
+		switch kind {
+		case mcapedit.OpRename:
+			if changed("rename") {
+				mapping := make(map[string]string, len(op.Rename)+len(rename))
+				maps.Copy(mapping, op.Rename)
+				maps.Copy(mapping, rename)
+				op.Rename = mapping
+			}
+		case mcapedit.OpDelete:
+			if changed("delete") {
+				op.Delete = deletes
+			}
+		case mcapedit.OpTrim:
+			if changed("trim-start") {
+				op.Trim.Start = trimStart
+			}
+			if changed("trim-end") {
+				op.Trim.End = trimEnd
+			}
+			if changed("trim-attachments") {
+				op.Trim.Attachments = trimAttachments
+			}
+		case mcapedit.OpShift:
+			if changed("shift-log") {
+				op.Shift.Log = shiftLog
+			}
+			if changed("shift-pub") {
+				op.Shift.Publish = shiftPublish
+			}
+			if changed("topics") {
+				op.Shift.Topics = topics
+			}
+		case mcapedit.OpPubTime:
+			if changed("pub-time") {
+				op.PubTime = &usePubTime
+			}
+		case mcapedit.OpAttachments:
+			if changed("drop-attachments") {
+				op.Attachments.Drop = dropAttachments
+			}
+			if changed("attachments") {
+				op.Attachments.Names = attachmentNames
+			}
+		case mcapedit.OpMetadata:
+			if changed("drop-metadata") {
+				op.Metadata.Drop = dropMetadata
+			}
+			if changed("metadata") {
+				op.Metadata.Names = metadataNames
+			}
+		default:
+			continue
+		}
+		if !slices.Contains(overridden, kind) {
+			overridden = append(overridden, kind)
+		}
+	}
+	return overridden
+}
+
+// clearOperation disables the built-in operation of the given kind.
+func clearOperation(opts *mcapedit.Options, kind string) {
+	switch kind {
+	case mcapedit.OpRename:
+		opts.Rename = nil
+	case mcapedit.OpDelete:
+		opts.Delete = nil
+	case mcapedit.OpTrim:
+		opts.TrimStart, opts.TrimEnd, opts.TrimAttachments = 0, 0, false
+	case mcapedit.OpShift:
+		opts.ShiftLog, opts.ShiftPublish, opts.Topics = 0, 0, nil
+	case mcapedit.OpPubTime:
+		opts.UsePublishTime = false
+	case mcapedit.OpAttachments:
+		opts.DropAttachments, opts.AttachmentNames = false, nil
+	case mcapedit.OpMetadata:
+		opts.DropMetadata, opts.MetadataNames = false, nil
+	}
 }
 
 func run() {
-	if len(rename) == 0 && len(deletes) == 0 && trimStart == "" &&
-		trimEnd == "" && len(topics) == 0 && shiftPublish == "" &&
-		shiftLog == "" && !usePubTime && compression == "" {
+	if editOpt.IsNoop() && compression == "" && compressionLevel == 0 && config == "" {
 		logging.GetLogger().Info("Nothing to do")
 		os.Exit(0)
 	}
@@ -263,6 +593,7 @@ func run() {
 	}
 
 	fileToProcess := make([]string, 0, 10)
+	root := input
 
 	if !isDir {
 		logging.GetLogger().Info("Input path is not a directory")
@@ -271,6 +602,7 @@ func run() {
 			os.Exit(1)
 		}
 		fileToProcess = append(fileToProcess, input)
+		root = filepath.Dir(input)
 	} else {
 		logging.GetLogger().Info("Input path is a directory")
 		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
@@ -287,18 +619,40 @@ func run() {
 		os.Exit(0)
 	}
 
-	isSameDir, err := utils.IsSameDirectory(input, output)
-	if err != nil {
-		logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
-		os.Exit(1)
+	if inPlace {
+		outputPaths = make(map[string]string, len(fileToProcess))
+		for _, filePath := range fileToProcess {
+			outputPaths[filePath] = filePath
+		}
+	} else {
+		isSameDir, err := utils.IsSameDirectory(input, output)
+		if err != nil {
+			logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+			os.Exit(1)
+		}
+
+		if isSameDir {
+			logging.GetLogger().Info("Cannot use input directory as output directory")
+			os.Exit(1)
+		}
+
+		// Output paths are all known, and checked for collisions, before any
+		// file is written.
+		outputPaths, err = utils.OutputPaths(root, fileToProcess, output, nameTemplate)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
 	}
 
-	if isSameDir {
-		logging.GetLogger().Info("Cannot use input directory as output directory")
-		os.Exit(1)
+	if dryRun {
+		if !planAll(fileToProcess) {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	exist := utils.IsDirExists(output)
+	exist := inPlace || utils.IsDirExists(output)
 	if !exist {
 		logging.GetLogger().Info("Output directory does not exist")
 		err := utils.CreateDir(output)
@@ -309,80 +663,131 @@ func run() {
 		logging.GetLogger().Info("Output directory created")
 	}
 
-	err = process(fileToProcess)
-	if err != nil {
-		logging.GetLogger().Error(err.Error())
+	fileWorkers, chunkWorkers := concurrency(len(fileToProcess), runtime.NumCPU(), workers)
+	editOpt.Workers = chunkWorkers
+	logging.GetLogger().Debug(fmt.Sprintf("editing %d files at once on %d chunk workers each", fileWorkers, chunkWorkers))
+
+	progress := newProgressReporter(progressMode, fileToProcess)
+	progress.run()
+	results := process(fileToProcess, fileWorkers, progress)
+	progress.stop()
+
+	report := newBatchReport(results)
+	if err := writeReport(report); err != nil {
+		logging.GetLogger().Error(fmt.Sprintf("failed to write report: %s", err))
+		os.Exit(1)
+	}
+	if report.Failed > 0 {
 		os.Exit(1)
 	}
 
 	os.Exit(0)
 }
 
-func process(toProcess []string) error {
-	dataCh := make(chan string, len(toProcess))
+// writeReport writes the batch report to --report, or to the standard
+// output.
+func writeReport(report *batchReport) (err error) {
+	if reportPath == "" {
+		return writeBatchReport(os.Stdout, report)
+	}
+
+	reportFile, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := reportFile.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+	return writeBatchReport(reportFile, report)
+}
+
+// concurrency shares the cores between the files edited at once and the chunk
+// workers of each file, so a batch does not start a chunk pool of every core
+// for every file. workers is the --workers flag, 0 to pick the chunk workers
+// from the cores left to each file.
+func concurrency(fileCount int, cores int, workers int) (fileWorkers int, chunkWorkers int) {
+	cores = max(cores, 1)
+	if workers > 0 {
+		return max(min(fileCount, cores/workers), 1), workers
+	}
+	fileWorkers = max(min(fileCount, cores), 1)
+	return fileWorkers, max(cores/fileWorkers, 1)
+}
+
+// process edits the files on fileWorkers goroutines and returns the result
+// of each, in the order of toProcess. Unless --continue-on-error is set, the files not
+// started yet are skipped after the first error. The progress of every file is
+// reported to progress, which may be nil.
+func process(toProcess []string, fileWorkers int, progress *progressReporter) []fileResult {
+	results := make([]fileResult, len(toProcess))
+	for idx, filePath := range toProcess {
+		results[idx] = fileResult{File: filePath, Output: outputPaths[filePath], Status: statusSkipped}
+	}
+
+	dataCh := make(chan int, len(toProcess))
 	stopCh := make(chan struct{})
-	errorCh := make(chan error, 1)
+	var stopOnce sync.Once
 
 	var wg sync.WaitGroup
 
-	go func(data []string, dataCh chan<- string, stopCh <-chan struct{}) {
-		defer close(dataCh)
-		for _, item := range data {
-			select {
-			case <-stopCh:
-				return
-			default:
-				dataCh <- item
-			}
-		}
-	}(toProcess, dataCh, stopCh)
+	for idx := range toProcess {
+		dataCh <- idx
+	}
+	close(dataCh)
 
-	numConsumers := runtime.NumCPU()*2 + 1
-	for i := 1; i <= numConsumers; i++ {
+	for i := 1; i <= fileWorkers; i++ {
 		wg.Add(1)
 
-		go func(idx int, dataCh <-chan string, wg *sync.WaitGroup, stopCh <-chan struct{}, errorCh chan<- error) {
+		go func(dataCh <-chan int, wg *sync.WaitGroup) {
 			defer wg.Done()
 			for {
 				select {
 				case <-stopCh:
 					return
-				case fPath, ok := <-dataCh:
+				case idx, ok := <-dataCh:
 					if !ok {
 						return
 					}
-					logging.GetLogger().Info(fmt.Sprintf("Processing %s", fPath))
-					err := conversion(fPath)
+					// The stop may have been signaled while waiting.
+					select {
+					case <-stopCh:
+						return
+					default:
+					}
+
+					result := &results[idx]
+					logging.GetLogger().Info(fmt.Sprintf("Processing %s", result.File))
+					start := time.Now()
+					err := conversion(result.File, progress.begin(result.File))
+					progress.end(result.File)
+					result.DurationNanos = int64(time.Since(start))
 					if err != nil {
-						errorCh <- err
+						result.Status, result.Error = statusFailed, err.Error()
+						logging.GetLogger().Error(err.Error())
+						if !continueOnError {
+							stopOnce.Do(func() { close(stopCh) })
+						}
+						continue
 					}
+					result.Status = statusSucceeded
 				}
 			}
-		}(i, dataCh, &wg, stopCh, errorCh)
-	}
-
-	go func() {
-		wg.Wait()
-		close(errorCh)
-	}()
-
-	select {
-	case err := <-errorCh:
-		if err != nil {
-			close(stopCh)
-			return err
-		}
-	case <-stopCh:
-		logging.GetLogger().Info("Stop signal received. Stopping.")
+		}(dataCh, &wg)
 	}
 
 	// Wait for consumers to clean up
 	wg.Wait()
 	logging.GetLogger().Info("All consumers have completed, exiting...")
-	return nil
+	return results
 }
 
-func conversion(filePath string) error {
+func conversion(filePath string, progress *mcapedit.Progress) (err error) {
+	if inPlace {
+		return editInPlace(filePath, progress)
+	}
+
 	inFile, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -394,207 +799,161 @@ func conversion(filePath string) error {
 		}
 	}(inFile)
 
-	reader, err := mcap.NewReader(inFile)
-	if err != nil {
-		return fmt.Errorf("failed to create new reader for %s: %s", filePath, err)
+	outputPath := outputPaths[filePath]
+	if err := utils.CreateDir(filepath.Dir(outputPath)); err != nil {
+		return fmt.Errorf("failed to create output directory of %s: %s", outputPath, err)
 	}
 
-	baseFileName := filepath.Base(filePath)
-	outputPath := filepath.Join(output, baseFileName)
-
 	outFile, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file %s: %s", outputPath, err)
 	}
 	defer func(outFile *os.File) {
-		cErr := inFile.Close()
+		cErr := outFile.Close()
 		if cErr != nil && err == nil {
 			err = cErr
 		}
+		// A partial output is not left behind to be mistaken for a
+		// complete one.
+		if err != nil {
+			_ = os.Remove(outputPath)
+		}
 	}(outFile)
 
-	writer, err := mcap.NewWriter(outFile, writerOpt)
+	opts := editOpt
+	opts.Progress = progress
+	err = mcapedit.Transform(context.Background(), inFile, outFile, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create new writer: %s", err)
+		return fmt.Errorf("failed to edit %s: %s", filePath, err)
 	}
 
-	msgs, err := reader.Messages()
+	return nil
+}
+
+// editInPlace edits filePath into a temporary file next to it, which replaces
+// it once written, verified and synced. The temporary file is removed on
+// error, leaving filePath untouched.
+func editInPlace(filePath string, progress *mcapedit.Progress) (err error) {
+	inFile, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read messages: %s", err)
+		return err
 	}
+	defer inFile.Close()
 
-	err = writer.WriteHeader(reader.Header())
+	// The temporary file does not end with the MCAP extension, so it is not
+	// picked up as an input if left behind by a crash.
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to write header: %s", err)
+		return fmt.Errorf("failed to create temporary file for %s: %s", filePath, err)
 	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
 
-	schemaWritten := map[uint16]bool{}
-	channelWritten := map[uint16]bool{}
-	channelMap := map[uint16]uint16{}
-
-	mcapInfo, err := reader.Info()
+	opts := editOpt
+	opts.Progress = progress
+	err = mcapedit.Transform(context.Background(), inFile, tmpFile, opts)
 	if err != nil {
-		return fmt.Errorf("failed to read mcap info: %s", err)
+		return fmt.Errorf("failed to edit %s: %s", filePath, err)
 	}
-
-	msgLogStart := mcapInfo.Statistics.MessageStartTime
-	msgLogEnd := mcapInfo.Statistics.MessageEndTime
-
-	channelIDs := make([]uint16, 0, len(mcapInfo.Channels))
-	for _, channel := range mcapInfo.Channels {
-		channelIDs = append(channelIDs, channel.ID)
+	if err = tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %s", tmpFile.Name(), err)
 	}
 
-	id, err := utils.RandomUint16NotIn(channelIDs)
-	if err != nil {
+	if _, err = tmpFile.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
-
-	// Perform trimming if specified
-	var trimStartTime, trimEndTime int64
-
-	if trimStart != "" {
-		trimStartTime, err = utils.TryParseTimestamp(trimStart)
-		if err != nil {
-			return fmt.Errorf("invalid trim start time: %s", trimStart)
-		}
-		if trimStartTime < int64(msgLogStart) {
-			return fmt.Errorf("trim start time [%d] is before message start time [%d]", trimStartTime, msgLogStart)
-		}
-
-		if trimStartTime >= int64(msgLogEnd) {
-			return fmt.Errorf("trim start time [%d] is after message end time [%d]", trimStartTime, msgLogEnd)
-		}
+	result, err := mcapverify.Verify(context.Background(), tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to verify edited %s: %s", filePath, err)
+	}
+	if !result.OK() {
+		return fmt.Errorf("edited %s is corrupted, left unchanged: %s", filePath, result.Problems[0])
 	}
 
-	if trimEnd != "" {
-		trimEndTime, err = utils.TryParseTimestamp(trimEnd)
-		if err != nil {
-			return fmt.Errorf("invalid trim end time: %s", trimEnd)
-		}
-		if trimEndTime > int64(msgLogEnd) {
-			logging.GetLogger().Warn(fmt.Sprintf("trim end time [%d] is after message end time [%d]", trimStartTime, msgLogEnd))
-		}
-	} else {
-		trimEndTime = int64(msgLogEnd)
+	if err = tmpFile.Close(); err != nil {
+		return err
 	}
+	// Closed before the rename, which fails on open files on Windows.
+	_ = inFile.Close()
+	if err = utils.ReplaceFile(tmpFile.Name(), filePath, backupSuffix); err != nil {
+		return fmt.Errorf("failed to replace %s: %s", filePath, err)
+	}
+	return nil
+}
 
-	for {
-		schema, channel, msg, err := msgs.NextInto(&mcap.Message{})
+// planAll prints the plan of every file, and reports whether all of them
+// would be edited without error.
+func planAll(files []string) bool {
+	ok := true
+	for _, filePath := range files {
+		plan, err := planFile(filePath)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("failed to iterate messages: %s", err)
+			logging.GetLogger().Error(fmt.Sprintf("failed to plan %s: %s", filePath, err))
+			ok = false
+			continue
 		}
+		printPlan(filePath, outputPaths[filePath], plan)
+		ok = ok && plan.Err == nil
+	}
+	return ok
+}
 
-		if trimStartTime != 0 {
-			logTime := int64(msg.LogTime)
-
-			if logTime < trimStartTime {
-				continue
-			}
+func planFile(filePath string) (*mcapedit.Plan, error) {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
 
-			if logTime > trimEndTime {
-				break
-			}
-		}
+	return mcapedit.PlanTransform(context.Background(), inFile, editOpt)
+}
 
-		// Perform remove if specified
-		if len(deletes) > 0 {
-			if slices.Contains(deletes, channel.Topic) {
-				continue
-			}
-		}
+func printPlan(filePath string, outputPath string, plan *mcapedit.Plan) {
+	if filePath == outputPath {
+		fmt.Printf("%s (in place)\n", filePath)
+	} else {
+		fmt.Printf("%s -> %s\n", filePath, outputPath)
+	}
+	if plan.Err != nil {
+		fmt.Printf("  Would fail: %s\n", plan.Err)
+		return
+	}
 
-		if !schemaWritten[channel.SchemaID] {
-			if err := writer.WriteSchema(schema); err != nil {
-				return err
-			}
-			schemaWritten[channel.SchemaID] = true
+	for _, topic := range plan.Topics {
+		switch {
+		case topic.Removed():
+			fmt.Printf("  Remove %s (%d messages)\n", topic.Topic, topic.Messages)
+		case topic.Renamed():
+			fmt.Printf("  Rename %s -> %s (%d messages)\n", topic.Topic, topic.NewTopic, topic.Messages)
 		}
-		newChannelID := channel.ID
-
-		// Perform rename if specified
-		if len(rename) != 0 {
-			for oldTopic, newTopic := range rename {
-				if !strings.HasPrefix(newTopic, "/") {
-					return fmt.Errorf("invalid new topic name: %s", newTopic)
-				}
+	}
 
-				if channel.Topic == oldTopic {
-					newChannelID = id
-					newChannel := &mcap.Channel{
-						ID:              newChannelID,
-						SchemaID:        channel.SchemaID,
-						Topic:           newTopic,
-						MessageEncoding: channel.MessageEncoding,
-						Metadata:        channel.Metadata,
-					}
-					if err := writer.WriteChannel(newChannel); err != nil {
-						return err
-					}
-					channelMap[channel.ID] = newChannelID
-				} else if _, exists := channelMap[channel.ID]; !exists {
-					if err := writer.WriteChannel(channel); err != nil {
-						return err
-					}
-					channelMap[channel.ID] = channel.ID
-				}
-			}
-			msg.ChannelID = channelMap[channel.ID]
+	fmt.Printf("  Messages: %d -> %d", plan.Messages, plan.KeptMessages)
+	if trimmed := plan.TrimmedMessages(); trimmed > 0 || editOpt.TrimStart != 0 || editOpt.TrimEnd != 0 {
+		fmt.Printf(" (%d inside, %d outside of the trim window)", plan.KeptMessages, trimmed)
+	}
+	fmt.Println()
+	if plan.Messages > 0 {
+		fmt.Printf("  Time range: %d - %d (%s)", plan.MessageStartTime, plan.MessageEndTime,
+			time.Duration(plan.MessageEndTime-plan.MessageStartTime))
+		if plan.KeptMessages > 0 {
+			fmt.Printf(" -> %d - %d (%s)", plan.OutputStartTime, plan.OutputEndTime,
+				time.Duration(plan.OutputEndTime-plan.OutputStartTime))
 		} else {
-			if !schemaWritten[channel.SchemaID] {
-				if err := writer.WriteSchema(schema); err != nil {
-					return fmt.Errorf("write schema: %w", err)
-				}
-				schemaWritten[channel.SchemaID] = true
-			}
-
-			if !channelWritten[channel.ID] {
-				if err := writer.WriteChannel(channel); err != nil {
-					return fmt.Errorf("write channel: %w", err)
-				}
-				channelWritten[channel.ID] = true
-			}
-		}
-
-		// Shift log time if applicable
-		if shiftLog != "" {
-			duration, err := time.ParseDuration(shiftLog)
-			if err != nil {
-				return fmt.Errorf("invalid shift log time %s: %s", shiftLog, err)
-			}
-
-			if len(topics) > 0 {
-				if slices.Contains(topics, channel.Topic) {
-					msg.LogTime = uint64(int64(msg.LogTime) + int64(duration))
-				}
-			} else {
-				msg.LogTime = uint64(int64(msg.LogTime) + int64(duration))
-			}
-		}
-
-		// shift publish time if application
-		if shiftPublish != "" {
-			duration, err := time.ParseDuration(shiftPublish)
-			if err != nil {
-				return fmt.Errorf("invalid shift publish time %s: %s", shiftPublish, err)
-			}
-
-			if len(topics) > 0 {
-				if slices.Contains(topics, channel.Topic) {
-					msg.PublishTime = uint64(int64(msg.PublishTime) + int64(duration))
-				}
-			} else {
-				msg.PublishTime = uint64(int64(msg.PublishTime) + int64(duration))
-			}
-		}
-
-		if err := writer.WriteMessage(msg); err != nil {
-			return err
+			fmt.Print(" -> no messages")
 		}
+		fmt.Println()
+	}
+	if plan.Attachments > 0 || plan.Metadata > 0 {
+		fmt.Printf("  Attachments: %d -> %d, Metadata: %d -> %d\n",
+			plan.Attachments, plan.KeptAttachments, plan.Metadata, plan.KeptMetadata)
+	}
+	fmt.Printf("  Size: %d -> ~%d bytes (estimated)\n", plan.InputSize, plan.EstimatedOutputSize)
+	if plan.Scanned {
+		fmt.Println("  No message indexes, the file was read to plan the edits")
 	}
-
-	return writer.Close()
 }
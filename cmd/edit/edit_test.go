@@ -0,0 +1,68 @@
+package edit
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"mcap-utility/pkg/mcapedit"
+	"testing"
+)
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		name         string
+		fileCount    int
+		cores        int
+		workers      int
+		fileWorkers  int
+		chunkWorkers int
+	}{
+		{"single file uses every core", 1, 8, 0, 1, 8},
+		{"batch shares the cores", 3, 8, 0, 3, 2},
+		{"batch larger than the cores", 100, 8, 0, 8, 1},
+		{"single core", 100, 1, 0, 1, 1},
+		{"explicit workers limit the files", 100, 8, 4, 2, 4},
+		{"explicit workers above the cores", 100, 8, 16, 1, 16},
+		{"single pass", 100, 8, 1, 8, 1},
+		{"single pass of fewer files", 2, 8, 1, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileWorkers, chunkWorkers := concurrency(tt.fileCount, tt.cores, tt.workers)
+			assert.Equal(t, tt.fileWorkers, fileWorkers)
+			assert.Equal(t, tt.chunkWorkers, chunkWorkers)
+			// The default never starts more chunk workers than cores.
+			if tt.workers == 0 {
+				assert.LessOrEqual(t, fileWorkers*chunkWorkers, tt.cores)
+			}
+		})
+	}
+}
+
+func TestAttachmentAndMetadataFlags(t *testing.T) {
+	t.Cleanup(func() {
+		output, trimStart, trimEnd = "", "", ""
+		attachmentNames, metadataNames = nil, nil
+		trimAttachments, dropMetadata = false, false
+		editOpt = mcapedit.Options{}
+		EditCmd.Flags().VisitAll(func(flag *pflag.Flag) { flag.Changed = false })
+	})
+
+	assert.NoError(t, EditCmd.ParseFlags([]string{
+		"--output", t.TempDir(),
+		"--attachments", "calib/*.yaml,notes.txt",
+		"--drop-metadata",
+		"--trim-start", "120",
+		"--trim-end", "150",
+		"--trim-attachments",
+	}))
+	assert.NoError(t, EditCmd.PreRunE(EditCmd, nil))
+
+	transformers, err := editOpt.Pipeline()
+	assert.NoError(t, err)
+	assert.Equal(t, []mcapedit.Transformer{
+		&mcapedit.TrimTime{Start: 120, End: 150, Attachments: true},
+		&mcapedit.FilterAttachments{Names: []string{"calib/*.yaml", "notes.txt"}},
+		&mcapedit.FilterMetadata{Drop: true},
+	}, transformers)
+}
@@ -0,0 +1,392 @@
+package edit
+
+import (
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"mcap-utility/internal/logging"
+	"mcap-utility/pkg/mcapedit"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress modes of --progress. Auto draws a bar when the standard error is a
+// terminal, and logs the progress otherwise.
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressLog  = "log"
+	progressNone = "none"
+)
+
+const (
+	barInterval = 200 * time.Millisecond
+	logInterval = 10 * time.Second
+	barWidth    = 30
+	// maxBarFiles is the number of files in progress listed under the bar.
+	maxBarFiles = 8
+)
+
+// fileProgress tracks a file of the batch. Its totals are the size of the
+// input and the message count of its summary, zero without summary.
+type fileProgress struct {
+	file          string
+	size          int64
+	totalMessages uint64
+	progress      *mcapedit.Progress
+	start         time.Time
+	end           time.Time
+}
+
+// bytes returns the input bytes read. The summary is read on top of the
+// data, so they are capped to the size of the file.
+func (f *fileProgress) bytes() int64 {
+	switch {
+	case !f.end.IsZero():
+		return f.size
+	case f.progress == nil:
+		return 0
+	}
+	return min(f.progress.Bytes(), f.size)
+}
+
+func (f *fileProgress) messages() uint64 {
+	switch {
+	case !f.end.IsZero() && f.totalMessages > 0:
+		return f.totalMessages
+	case f.progress == nil:
+		return 0
+	}
+	return f.progress.Messages()
+}
+
+// fraction returns the part of the file done, from its messages when the
+// summary counts them and else from its bytes.
+func (f *fileProgress) fraction() float64 {
+	switch {
+	case !f.end.IsZero():
+		return 1
+	case f.totalMessages > 0:
+		return min(float64(f.messages())/float64(f.totalMessages), 1)
+	case f.size > 0:
+		return float64(f.bytes()) / float64(f.size)
+	}
+	return 0
+}
+
+// progressStatus is the progress of a file or of the whole batch.
+type progressStatus struct {
+	name          string
+	bytes         int64
+	size          int64
+	messages      uint64
+	totalMessages uint64
+	fraction      float64
+	mbPerSecond   float64
+}
+
+func (s progressStatus) percent() string {
+	return fmt.Sprintf("%.1f%%", s.fraction*100)
+}
+
+func (s progressStatus) megabytes() string {
+	return fmt.Sprintf("%.1f/%.1f MB", float64(s.bytes)/1e6, float64(s.size)/1e6)
+}
+
+func (s progressStatus) messageCount() string {
+	if s.totalMessages == 0 {
+		return fmt.Sprintf("%d msgs", s.messages)
+	}
+	return fmt.Sprintf("%d/%d msgs", s.messages, s.totalMessages)
+}
+
+func (s progressStatus) throughput() string {
+	return fmt.Sprintf("%.1f MB/s", s.mbPerSecond)
+}
+
+// progressReporter reports the progress of a batch, as a bar redrawn on the
+// standard error or as periodic log lines. A nil reporter reports nothing.
+type progressReporter struct {
+	mode  string
+	out   io.Writer
+	files map[string]*fileProgress
+	order []*fileProgress
+
+	mu    sync.Mutex
+	start time.Time
+	// lines is the number of lines of the bar on screen.
+	lines int
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// newProgressReporter reads the totals of files for the given --progress
+// mode, and returns nil if the progress is not reported.
+func newProgressReporter(mode string, files []string) *progressReporter {
+	if mode == progressAuto {
+		mode = progressLog
+		if isTerminal(os.Stderr) {
+			mode = progressBar
+		}
+	}
+	if mode == progressNone {
+		return nil
+	}
+
+	r := &progressReporter{
+		mode:   mode,
+		out:    os.Stderr,
+		files:  make(map[string]*fileProgress, len(files)),
+		order:  make([]*fileProgress, 0, len(files)),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	for _, filePath := range files {
+		f := &fileProgress{file: filePath}
+		f.size, f.totalMessages = inputTotals(filePath)
+		r.files[filePath] = f
+		r.order = append(r.order, f)
+	}
+	return r
+}
+
+// inputTotals returns the size of filePath and the message count of its
+// summary. Errors are left to the edit of the file to report.
+func inputTotals(filePath string) (int64, uint64) {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return 0, 0
+	}
+	defer inFile.Close()
+
+	info, err := inFile.Stat()
+	if err != nil {
+		return 0, 0
+	}
+	reader, err := mcap.NewReader(inFile)
+	if err != nil {
+		return info.Size(), 0
+	}
+	mcapInfo, err := reader.Info()
+	if err != nil || mcapInfo.Statistics == nil {
+		return info.Size(), 0
+	}
+	return info.Size(), mcapInfo.Statistics.MessageCount
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run starts reporting until stop is called. While the bar is drawn, the
+// logs go through the reporter, which writes them above the bar.
+func (r *progressReporter) run() {
+	if r == nil {
+		return
+	}
+	r.start = time.Now()
+
+	interval := logInterval
+	if r.mode == progressBar {
+		interval = barInterval
+		logging.SetOutput(r)
+	}
+
+	go func() {
+		defer close(r.doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+				r.report()
+			}
+		}
+	}()
+}
+
+// stop draws the bar or logs the progress a last time and stops reporting.
+func (r *progressReporter) stop() {
+	if r == nil {
+		return
+	}
+	close(r.stopCh)
+	<-r.doneCh
+	r.report()
+	if r.mode == progressBar {
+		logging.SetOutput(r.out)
+	}
+}
+
+// begin returns the progress to pass to the edit of filePath.
+func (r *progressReporter) begin(filePath string) *mcapedit.Progress {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.files[filePath]
+	f.progress = &mcapedit.Progress{}
+	f.start = time.Now()
+	return f.progress
+}
+
+// end marks filePath as done, whether its edit succeeded or not.
+func (r *progressReporter) end(filePath string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[filePath].end = time.Now()
+}
+
+// Write writes the logs above the bar.
+func (r *progressReporter) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := io.WriteString(r.out, r.clearBar()); err != nil {
+		return 0, err
+	}
+	n, err := r.out.Write(b)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(r.out, r.drawBar())
+	return n, err
+}
+
+func (r *progressReporter) report() {
+	if r.mode == progressLog {
+		r.log()
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, _ = io.WriteString(r.out, r.clearBar()+r.drawBar())
+}
+
+// status returns the progress of the batch, the number of files done, the
+// estimated time left and the progress of the files being edited. The ETA is
+// the elapsed time scaled by the part left, where each file weighs its size.
+// It is negative until some progress is made. r.mu must be held.
+func (r *progressReporter) status() (progressStatus, int, time.Duration, []progressStatus) {
+	now := time.Now()
+	total := progressStatus{name: "total"}
+	var done int
+	var weighted float64
+	var active []progressStatus
+	for _, f := range r.order {
+		total.bytes += f.bytes()
+		total.size += f.size
+		total.messages += f.messages()
+		total.totalMessages += f.totalMessages
+		weighted += f.fraction() * float64(f.size)
+		switch {
+		case !f.end.IsZero():
+			done++
+		case f.progress != nil:
+			active = append(active, progressStatus{
+				name:          f.file,
+				bytes:         f.bytes(),
+				size:          f.size,
+				messages:      f.messages(),
+				totalMessages: f.totalMessages,
+				fraction:      f.fraction(),
+				mbPerSecond:   float64(f.bytes()) / 1e6 / now.Sub(f.start).Seconds(),
+			})
+		}
+	}
+
+	switch {
+	case total.size > 0:
+		total.fraction = weighted / float64(total.size)
+	case len(r.order) > 0:
+		total.fraction = float64(done) / float64(len(r.order))
+	}
+	elapsed := now.Sub(r.start)
+	total.mbPerSecond = float64(total.bytes) / 1e6 / elapsed.Seconds()
+
+	eta := time.Duration(-1)
+	if total.fraction > 0 {
+		eta = time.Duration(float64(elapsed) * (1 - total.fraction) / total.fraction)
+	}
+	return total, done, eta, active
+}
+
+// clearBar returns the escape sequences erasing the bar on screen. r.mu must
+// be held.
+func (r *progressReporter) clearBar() string {
+	erase := strings.Repeat("\x1b[1A\x1b[2K", r.lines)
+	r.lines = 0
+	return erase
+}
+
+// drawBar returns the bar and the files being edited below it. r.mu must be
+// held.
+func (r *progressReporter) drawBar() string {
+	total, done, eta, active := r.status()
+
+	filled := int(total.fraction * barWidth)
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[%s%s] %6s  %d/%d files  %s  %s  %s  ETA %s\n",
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), total.percent(),
+		done, len(r.order), total.megabytes(), total.messageCount(), total.throughput(), formatETA(eta))
+	r.lines = 1
+
+	for idx, status := range active {
+		if idx == maxBarFiles {
+			fmt.Fprintf(b, "  ... and %d more\n", len(active)-maxBarFiles)
+			r.lines++
+			break
+		}
+		fmt.Fprintf(b, "  %s %6s  %s  %s  %s\n", filepath.Base(status.name), status.percent(),
+			status.megabytes(), status.messageCount(), status.throughput())
+		r.lines++
+	}
+	return b.String()
+}
+
+// log logs the progress of the batch and of every file being edited.
+func (r *progressReporter) log() {
+	r.mu.Lock()
+	total, done, eta, active := r.status()
+	r.mu.Unlock()
+
+	logger := logging.GetLogger()
+	logger.Info("Progress",
+		"files", fmt.Sprintf("%d/%d", done, len(r.order)),
+		"percent", total.percent(),
+		"bytes", total.bytes,
+		"total_bytes", total.size,
+		"messages", total.messages,
+		"total_messages", total.totalMessages,
+		"mb_per_s", fmt.Sprintf("%.1f", total.mbPerSecond),
+		"eta", formatETA(eta),
+	)
+	for _, status := range active {
+		logger.Info("File progress",
+			"file", status.name,
+			"percent", status.percent(),
+			"bytes", status.bytes,
+			"total_bytes", status.size,
+			"messages", status.messages,
+			"total_messages", status.totalMessages,
+			"mb_per_s", fmt.Sprintf("%.1f", status.mbPerSecond),
+		)
+	}
+}
+
+func formatETA(eta time.Duration) string {
+	if eta < 0 {
+		return "unknown"
+	}
+	return eta.Round(time.Second).String()
+}
@@ -0,0 +1,276 @@
+package edit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mcap-utility/internal/logging"
+	"mcap-utility/pkg/mcapedit"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editedProgress edits a file of messageCount messages and returns the
+// progress of the edit.
+func editedProgress(t *testing.T, messageCount int) *mcapedit.Progress {
+	t.Helper()
+
+	in := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(in, &mcap.WriterOptions{Chunked: true, ChunkSize: 64})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	for idx := range messageCount {
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{ChannelID: 1, LogTime: uint64(idx), Data: []byte("data")}))
+	}
+	assert.NoError(t, writer.Close())
+
+	progress := &mcapedit.Progress{}
+	assert.NoError(t, mcapedit.Transform(context.Background(), bytes.NewReader(in.Bytes()), io.Discard,
+		mcapedit.Options{Workers: 1, Progress: progress}))
+	assert.Equal(t, uint64(messageCount), progress.Messages())
+	assert.Positive(t, progress.Bytes())
+	return progress
+}
+
+func TestFileProgress(t *testing.T) {
+	progress := editedProgress(t, 4)
+	read := progress.Bytes()
+
+	tests := []struct {
+		name     string
+		file     fileProgress
+		bytes    int64
+		messages uint64
+		fraction float64
+	}{
+		{
+			name: "not started",
+			file: fileProgress{size: 100, totalMessages: 8},
+		},
+		{
+			name: "no totals",
+			file: fileProgress{progress: progress},
+			// The bytes read are capped to the unknown size.
+			messages: 4,
+		},
+		{
+			name:     "messages total",
+			file:     fileProgress{size: 4 * read, totalMessages: 8, progress: progress},
+			bytes:    read,
+			messages: 4,
+			fraction: 0.5,
+		},
+		{
+			name:     "messages total exceeded",
+			file:     fileProgress{size: 4 * read, totalMessages: 2, progress: progress},
+			bytes:    read,
+			messages: 4,
+			fraction: 1,
+		},
+		{
+			name:     "bytes total without messages total",
+			file:     fileProgress{size: 2 * read, progress: progress},
+			bytes:    read,
+			messages: 4,
+			fraction: 0.5,
+		},
+		{
+			name:     "bytes capped to the size",
+			file:     fileProgress{size: read / 2, progress: progress},
+			bytes:    read / 2,
+			messages: 4,
+			fraction: 1,
+		},
+		{
+			name:     "ended with messages total",
+			file:     fileProgress{size: 4 * read, totalMessages: 8, progress: progress, end: time.Now()},
+			bytes:    4 * read,
+			messages: 8,
+			fraction: 1,
+		},
+		{
+			name:     "ended without messages total",
+			file:     fileProgress{size: 4 * read, progress: progress, end: time.Now()},
+			bytes:    4 * read,
+			messages: 4,
+			fraction: 1,
+		},
+		{
+			name:     "failed before starting",
+			file:     fileProgress{size: 100, end: time.Now()},
+			bytes:    100,
+			fraction: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.bytes, tt.file.bytes())
+			assert.Equal(t, tt.messages, tt.file.messages())
+			assert.InDelta(t, tt.fraction, tt.file.fraction(), 1e-9)
+		})
+	}
+}
+
+// testReporter returns a reporter of files, started 10 seconds ago, drawing
+// to a buffer.
+func testReporter(mode string, files ...*fileProgress) (*progressReporter, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	r := &progressReporter{
+		mode:   mode,
+		out:    out,
+		files:  make(map[string]*fileProgress, len(files)),
+		start:  time.Now().Add(-10 * time.Second),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	for _, f := range files {
+		r.files[f.file] = f
+		r.order = append(r.order, f)
+	}
+	return r, out
+}
+
+func TestProgressStatus(t *testing.T) {
+	progress := editedProgress(t, 4)
+	read := progress.Bytes()
+
+	r, _ := testReporter(progressBar,
+		&fileProgress{file: "done.mcap", size: 2 * read, totalMessages: 6, end: time.Now()},
+		&fileProgress{file: "bytes.mcap", size: 2 * read, progress: progress, start: time.Now().Add(-time.Second)},
+		&fileProgress{file: "messages.mcap", size: 4 * read, totalMessages: 16, progress: progress,
+			start: time.Now().Add(-time.Second)},
+		&fileProgress{file: "pending.mcap", size: 8 * read, totalMessages: 10},
+	)
+
+	total, done, eta, active := r.status()
+	assert.Equal(t, 1, done)
+	assert.Equal(t, 2*read+read+read, total.bytes)
+	assert.Equal(t, 16*read, total.size)
+	assert.Equal(t, uint64(6+4+4), total.messages)
+	assert.Equal(t, uint64(6+16+10), total.totalMessages)
+	// Each file weighs its size: 2 done, half of 2 and a quarter of 4 over 16.
+	assert.InDelta(t, 4.0/16, total.fraction, 1e-9)
+	// The elapsed 10s made a quarter of the progress.
+	assert.InDelta(t, (30 * time.Second).Seconds(), eta.Seconds(), 1)
+	assert.InDelta(t, float64(total.bytes)/1e6/10, total.mbPerSecond, 1e-3)
+
+	assert.Len(t, active, 2)
+	assert.Equal(t, "bytes.mcap", active[0].name)
+	assert.InDelta(t, 0.5, active[0].fraction, 1e-9)
+	assert.Equal(t, "messages.mcap", active[1].name)
+	assert.InDelta(t, 0.25, active[1].fraction, 1e-9)
+	assert.Equal(t, uint64(4), active[1].messages)
+}
+
+func TestProgressStatusWithoutSizes(t *testing.T) {
+	// Without sizes, the progress counts the files done.
+	r, _ := testReporter(progressBar,
+		&fileProgress{file: "a.mcap", end: time.Now()},
+		&fileProgress{file: "b.mcap", progress: &mcapedit.Progress{}},
+		&fileProgress{file: "c.mcap"},
+		&fileProgress{file: "d.mcap"},
+	)
+	total, done, eta, active := r.status()
+	assert.Equal(t, 1, done)
+	assert.InDelta(t, 0.25, total.fraction, 1e-9)
+	assert.InDelta(t, (30 * time.Second).Seconds(), eta.Seconds(), 1)
+	assert.Len(t, active, 1)
+
+	// Until some progress is made, the ETA is unknown.
+	r, _ = testReporter(progressBar, &fileProgress{file: "a.mcap", size: 100})
+	_, _, eta, _ = r.status()
+	assert.Equal(t, time.Duration(-1), eta)
+	assert.Equal(t, "unknown", formatETA(eta))
+}
+
+func TestProgressBarWrite(t *testing.T) {
+	r, out := testReporter(progressBar,
+		&fileProgress{file: "in/a.mcap", size: 1e6, end: time.Now()},
+		&fileProgress{file: "in/b.mcap", size: 1e6},
+	)
+	bar := "[===============               ]  50.0%  1/2 files  1.0/2.0 MB  0 msgs  0.1 MB/s  ETA 10s\n"
+
+	// The logs are written above the bar, which is erased and redrawn.
+	n, err := r.Write([]byte("first\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, len("first\n"), n)
+	assert.Equal(t, "first\n"+bar, out.String())
+
+	out.Reset()
+	_, err = r.Write([]byte("second\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[1A\x1b[2K"+"second\n"+bar, out.String())
+}
+
+func TestProgressBarActiveFiles(t *testing.T) {
+	files := []*fileProgress{{file: "in/done.mcap", size: 1e6, end: time.Now()}}
+	for idx := range maxBarFiles + 2 {
+		files = append(files, &fileProgress{
+			file:     fmt.Sprintf("in/%d.mcap", idx),
+			size:     1e6,
+			progress: &mcapedit.Progress{},
+			start:    time.Now().Add(-time.Second),
+		})
+	}
+	r, out := testReporter(progressBar, files...)
+
+	r.report()
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	// The bar, the first files in progress and the count of the others.
+	assert.Len(t, lines, 1+maxBarFiles+1)
+	assert.Contains(t, lines[0], "1/11 files")
+	assert.Equal(t, "  0.mcap   0.0%  0.0/1.0 MB  0 msgs  0.0 MB/s", lines[1])
+	assert.Equal(t, "  ... and 2 more", lines[len(lines)-1])
+	assert.Equal(t, len(lines), r.lines)
+
+	out.Reset()
+	r.report()
+	assert.True(t, strings.HasPrefix(out.String(), strings.Repeat("\x1b[1A\x1b[2K", len(lines))+"["))
+}
+
+func TestProgressBarRedirectsLogs(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previous := logging.SetOutput(logs)
+	defer logging.SetOutput(previous)
+
+	r, out := testReporter(progressBar, &fileProgress{file: "in/a.mcap", size: 1e6})
+	r.run()
+	logging.GetLogger().Info("while drawing")
+	r.stop()
+	logging.GetLogger().Info("after stopping")
+
+	// While the bar is drawn the logs go through the reporter, and stopping
+	// restores the output of the reporter.
+	assert.Contains(t, out.String(), "msg=\"while drawing\"\n[")
+	assert.Contains(t, out.String(), "msg=\"after stopping\"")
+	assert.Empty(t, logs.String())
+	assert.Same(t, out, logging.SetOutput(logs))
+}
+
+func TestProgressLog(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previous := logging.SetOutput(logs)
+	defer logging.SetOutput(previous)
+
+	r, out := testReporter(progressLog,
+		&fileProgress{file: "in/a.mcap", size: 1e6, end: time.Now()},
+		&fileProgress{file: "in/b.mcap", size: 1e6, progress: &mcapedit.Progress{}, start: time.Now()},
+	)
+	r.report()
+	assert.Contains(t, logs.String(),
+		"msg=Progress files=1/2 percent=50.0% bytes=1000000 total_bytes=2000000 messages=0 total_messages=0 mb_per_s=0.1 eta=10s\n")
+	assert.Contains(t, logs.String(), "msg=\"File progress\" file=in/b.mcap percent=0.0% bytes=0")
+
+	// The log mode neither draws nor redirects the logs.
+	r.run()
+	r.stop()
+	assert.Empty(t, out.String())
+	assert.Same(t, logs, logging.SetOutput(logs))
+}
@@ -0,0 +1,76 @@
+package edit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Report formats of --report-format.
+const (
+	reportText = "text"
+	reportJSON = "json"
+)
+
+// Statuses of the files of a batch. Files are skipped when the batch stops at
+// the first error, before they are started.
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+)
+
+// fileResult is the outcome of editing a file.
+type fileResult struct {
+	File          string `json:"file"`
+	Output        string `json:"output"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	DurationNanos int64  `json:"duration_ns"`
+}
+
+// batchReport is written once every file of a batch is done.
+type batchReport struct {
+	Files     []fileResult `json:"files"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+}
+
+func newBatchReport(results []fileResult) *batchReport {
+	report := &batchReport{Files: results}
+	for _, result := range results {
+		switch result.Status {
+		case statusSucceeded:
+			report.Succeeded++
+		case statusFailed:
+			report.Failed++
+		case statusSkipped:
+			report.Skipped++
+		}
+	}
+	return report
+}
+
+// writeBatchReport writes the report in the selected format. The text format
+// only lists the files that did not succeed.
+func writeBatchReport(w io.Writer, report *batchReport) error {
+	if reportFormat == reportJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Fprintf(w, "Edited %d of %d files, %d failed, %d skipped\n",
+		report.Succeeded, len(report.Files), report.Failed, report.Skipped)
+	for _, result := range report.Files {
+		switch result.Status {
+		case statusFailed:
+			fmt.Fprintf(w, "  FAILED  %s (%s): %s\n", result.File, time.Duration(result.DurationNanos), result.Error)
+		case statusSkipped:
+			fmt.Fprintf(w, "  SKIPPED %s\n", result.File)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package edit
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func mixedResults() []fileResult {
+	return []fileResult{
+		{File: "in/a.mcap", Output: "out/a.mcap", Status: statusSucceeded, DurationNanos: int64(2 * time.Second)},
+		{File: "in/b.mcap", Output: "out/b.mcap", Status: statusFailed, Error: "failed to create new reader: bad magic",
+			DurationNanos: int64(150 * time.Millisecond)},
+		{File: "in/c.mcap", Output: "out/c.mcap", Status: statusSucceeded, DurationNanos: int64(time.Second)},
+		{File: "in/d.mcap", Output: "out/d.mcap", Status: statusSkipped},
+	}
+}
+
+func TestNewBatchReport(t *testing.T) {
+	report := newBatchReport(mixedResults())
+	assert.Equal(t, 2, report.Succeeded)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, mixedResults(), report.Files)
+
+	report = newBatchReport(nil)
+	assert.Equal(t, &batchReport{}, report)
+}
+
+func TestWriteBatchReportText(t *testing.T) {
+	defer func(previous string) { reportFormat = previous }(reportFormat)
+	reportFormat = reportText
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, writeBatchReport(out, newBatchReport(mixedResults())))
+	// Only the files that did not succeed are listed.
+	assert.Equal(t, `Edited 2 of 4 files, 1 failed, 1 skipped
+  FAILED  in/b.mcap (150ms): failed to create new reader: bad magic
+  SKIPPED in/d.mcap
+`, out.String())
+}
+
+func TestWriteBatchReportJSON(t *testing.T) {
+	defer func(previous string) { reportFormat = previous }(reportFormat)
+	reportFormat = reportJSON
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, writeBatchReport(out, newBatchReport(mixedResults())))
+
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, float64(2), decoded["succeeded"])
+	assert.Equal(t, float64(1), decoded["failed"])
+	assert.Equal(t, float64(1), decoded["skipped"])
+
+	files := decoded["files"].([]any)
+	assert.Len(t, files, 4)
+	assert.Equal(t, map[string]any{
+		"file":        "in/a.mcap",
+		"output":      "out/a.mcap",
+		"status":      "succeeded",
+		"duration_ns": float64(2 * time.Second),
+	}, files[0])
+	assert.Equal(t, map[string]any{
+		"file":        "in/b.mcap",
+		"output":      "out/b.mcap",
+		"status":      "failed",
+		"error":       "failed to create new reader: bad magic",
+		"duration_ns": float64(150 * time.Millisecond),
+	}, files[1])
+	assert.Equal(t, map[string]any{
+		"file":        "in/d.mcap",
+		"output":      "out/d.mcap",
+		"status":      "skipped",
+		"duration_ns": float64(0),
+	}, files[3])
+}
@@ -0,0 +1,279 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"math"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapexport"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var exportOpt mcapexport.Options
+
+var (
+	input     string
+	output    string
+	format    string
+	arrays    string
+	topics    []string
+	startTime string
+	endTime   string
+)
+
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: fmt.Sprintf("Export the messages of (%s) files to CSV or Parquet tables", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Export the messages of (%s) files to one CSV or Parquet table per topic.
+Messages are decoded with the schema stored in the file and flattened to a column per field path, e.g.
+pose.position.x, after the log_time, publish_time and sequence columns. The first message of a topic sets its columns.
+The tables of each file are written to a directory named after it in the output directory, in the same
+subdirectories as under an input directory.`,
+		constants.MCAPFIleExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		exportOpt = mcapexport.Options{
+			Topics: utils.RemoveEmptyStrings(topics),
+			Format: mcapexport.Format(format),
+			Arrays: mcapexport.ArrayMode(arrays),
+			Logger: logging.GetLogger(),
+		}
+
+		if startTime != "" {
+			start, err := utils.TryParseTimestamp(startTime)
+			if err != nil || start < 0 {
+				return fmt.Errorf("invalid start time: %s", startTime)
+			}
+			exportOpt.Start = uint64(start)
+		}
+
+		if endTime != "" {
+			end, err := utils.TryParseTimestamp(endTime)
+			if err != nil || end < 0 {
+				return fmt.Errorf("invalid end time: %s", endTime)
+			}
+			if end < math.MaxInt64 {
+				exportOpt.End = uint64(end)
+			}
+		}
+		return exportOpt.Validate()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+func init() {
+	ExportCmd.
+		Flags().
+		StringVarP(
+			&input,
+			"input",
+			"i",
+			"",
+			fmt.Sprintf(
+				"Input (%s) files or directory contains (%s) file(s)",
+				constants.MCAPFIleExtension,
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	ExportCmd.
+		Flags().
+		StringVarP(
+			&output,
+			"output",
+			"o",
+			"",
+			"Output directory to save the exported tables",
+		)
+
+	ExportCmd.
+		Flags().
+		StringVarP(
+			&format,
+			"format",
+			"f",
+			string(mcapexport.FormatCSV),
+			"File format of the exported tables (csv or parquet)",
+		)
+
+	ExportCmd.
+		Flags().
+		StringVar(
+			&arrays,
+			"arrays",
+			string(mcapexport.ArraysJSON),
+			"How arrays are exported (json: one column holding the array as JSON, index: one column per element, drop: left out)",
+		)
+
+	ExportCmd.
+		Flags().
+		StringSliceVarP(
+			&topics,
+			"topics",
+			"t",
+			nil,
+			"List of topics to export, if unspecified, messages of all topics are exported",
+		)
+
+	ExportCmd.
+		Flags().
+		StringVarP(
+			&startTime,
+			"start",
+			"s",
+			"",
+			"Export messages logged at or after this time (prefer RFC3339 or unixnano format, e.g. 2006-01-02T15:04:05+07:00)",
+		)
+
+	ExportCmd.
+		Flags().
+		StringVarP(
+			&endTime,
+			"end",
+			"e",
+			"",
+			"Export messages logged at or before this time (prefer RFC3339 or unixnano format, e.g. 2006-01-02T15:04:05+07:00)",
+		)
+
+	_ = ExportCmd.MarkFlagRequired("input")
+	_ = ExportCmd.MarkFlagRequired("output")
+}
+
+func run() {
+	isDir, err := utils.IsPathDirectory(input)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	fileToProcess := make([]string, 0, 10)
+
+	if !isDir {
+		if !strings.HasSuffix(input, constants.MCAPFIleExtension) {
+			logging.GetLogger().Info(fmt.Sprintf("Input %s does not end with %s extension", input, constants.MCAPFIleExtension))
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, input)
+	} else {
+		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, mcapFiles...)
+	}
+	logging.GetLogger().Info(fmt.Sprintf("Retrieved %d mcap files to export", len(fileToProcess)))
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files to export", constants.MCAPFIleExtension))
+		os.Exit(0)
+	}
+
+	isSameDir, err := utils.IsSameDirectory(input, output)
+	if err != nil {
+		logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+		os.Exit(1)
+	}
+
+	if isSameDir {
+		logging.GetLogger().Info("Cannot use input directory as output directory")
+		os.Exit(1)
+	}
+
+	root := input
+	if !isDir {
+		root = filepath.Dir(input)
+	}
+	// The tables of every file go to a directory named after its stem, in
+	// the directory mirroring its own, so files with the same name in
+	// different directories do not share their tables.
+	outputDirs, err := utils.OutputPaths(root, fileToProcess, output, "{stem}")
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	for _, fPath := range fileToProcess {
+		logging.GetLogger().Info(fmt.Sprintf("Exporting %s", fPath))
+		if err := exportFile(fPath, outputDirs[fPath]); err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+func exportFile(filePath string, outputDir string) error {
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer mcapFile.Close()
+
+	if !utils.IsDirExists(outputDir) {
+		if err := utils.CreateDir(outputDir); err != nil {
+			return err
+		}
+	}
+
+	// Topics, and channels sharing a topic, get distinct file names.
+	names := map[string]bool{}
+	created := make([]string, 0)
+	create := func(topic string) (io.WriteCloser, error) {
+		name := topicFileName(topic)
+		for i := 1; names[name]; i++ {
+			name = fmt.Sprintf("%s_%d", topicFileName(topic), i)
+		}
+		names[name] = true
+		outputPath := filepath.Join(outputDir, name+exportOpt.Format.Extension())
+
+		outFile, err := os.Create(outputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file %s: %s", outputPath, err)
+		}
+		created = append(created, outputPath)
+		return outFile, nil
+	}
+
+	report, err := mcapexport.Export(context.Background(), mcapFile, create, exportOpt)
+	if err != nil {
+		for _, outputPath := range created {
+			_ = os.Remove(outputPath)
+		}
+		return fmt.Errorf("failed to export %s: %s", filePath, err)
+	}
+	if !report.Ordered {
+		logging.GetLogger().Warn(fmt.Sprintf("%s has no summary section, exported messages in file order", filePath))
+	}
+
+	fmt.Printf("%s -> %s\n", filePath, outputDir)
+	for i, topic := range report.Topics {
+		fmt.Printf("  %s: %d rows, %d columns -> %s\n", topic.Topic, topic.Rows, topic.Columns, filepath.Base(created[i]))
+		if topic.DroppedValues > 0 {
+			fmt.Printf("    Dropped: %d values of fields without a column or of another type\n", topic.DroppedValues)
+		}
+	}
+	if len(report.Topics) == 0 {
+		fmt.Println("  No messages to export")
+	}
+	return nil
+}
+
+// topicFileName turns a topic into a file name, e.g. /camera/info to
+// camera_info.
+func topicFileName(topic string) string {
+	name := strings.ReplaceAll(strings.Trim(topic, "/"), "/", "_")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
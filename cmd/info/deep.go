@@ -0,0 +1,214 @@
+package info
+
+import (
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	// rateWindow is the time window over which the min and max rates of a
+	// channel are counted.
+	rateWindow = uint64(time.Second)
+	// largestGapCount is the number of gaps listed per channel.
+	largestGapCount = 5
+)
+
+// channelStats are the statistics collected by a --deep scan over all
+// messages of a channel. Times and durations are in nanoseconds.
+type channelStats struct {
+	MessageCount uint64 `json:"message_count" yaml:"message_count"`
+	PayloadBytes uint64 `json:"payload_bytes" yaml:"payload_bytes"`
+	// AverageHz is the message count over the time between the first and
+	// the last message. MinHz and MaxHz are the lowest and highest message
+	// counts in the complete one second windows starting at the first
+	// message, and equal AverageHz when the channel spans less than one
+	// second.
+	AverageHz float64 `json:"average_hz" yaml:"average_hz"`
+	MinHz     float64 `json:"min_hz" yaml:"min_hz"`
+	MaxHz     float64 `json:"max_hz" yaml:"max_hz"`
+	// IntervalNanos is the mean time between consecutive messages and
+	// JitterNanos its standard deviation.
+	IntervalNanos float64      `json:"interval_ns" yaml:"interval_ns"`
+	JitterNanos   float64      `json:"jitter_ns" yaml:"jitter_ns"`
+	LargestGaps   []channelGap `json:"largest_gaps" yaml:"largest_gaps"`
+	// Latency is the log time minus the publish time of the messages with
+	// a publish time. It is nil when no message has one.
+	Latency *latencyStats `json:"latency,omitempty" yaml:"latency,omitempty"`
+}
+
+// channelGap is the time between two consecutive messages of a channel.
+type channelGap struct {
+	StartTime     uint64 `json:"start_time" yaml:"start_time"`
+	EndTime       uint64 `json:"end_time" yaml:"end_time"`
+	DurationNanos uint64 `json:"duration_ns" yaml:"duration_ns"`
+}
+
+type latencyStats struct {
+	MinNanos int64 `json:"min_ns" yaml:"min_ns"`
+	P50Nanos int64 `json:"p50_ns" yaml:"p50_ns"`
+	P90Nanos int64 `json:"p90_ns" yaml:"p90_ns"`
+	P99Nanos int64 `json:"p99_ns" yaml:"p99_ns"`
+	MaxNanos int64 `json:"max_ns" yaml:"max_ns"`
+}
+
+// channelScan accumulates the statistics of one channel while the messages
+// are read in log time order.
+type channelScan struct {
+	stats channelStats
+
+	first, last uint64
+	// Welford's running mean and sum of squared deviations of the
+	// intervals.
+	mean, m2 float64
+
+	windowEnd   uint64
+	windowCount uint64
+	windows     uint64
+
+	latencies []int64
+}
+
+func (s *channelScan) add(msg *mcap.Message) {
+	s.stats.PayloadBytes += uint64(len(msg.Data))
+	if msg.PublishTime != 0 {
+		s.latencies = append(s.latencies, int64(msg.LogTime-msg.PublishTime))
+	}
+
+	s.stats.MessageCount++
+	if s.stats.MessageCount == 1 {
+		s.first, s.last = msg.LogTime, msg.LogTime
+		s.windowEnd = msg.LogTime + rateWindow
+		s.windowCount = 1
+		return
+	}
+
+	interval := msg.LogTime - s.last
+	n := float64(s.stats.MessageCount - 1)
+	delta := float64(interval) - s.mean
+	s.mean += delta / n
+	s.m2 += delta * (float64(interval) - s.mean)
+	s.addGap(channelGap{StartTime: s.last, EndTime: msg.LogTime, DurationNanos: interval})
+	s.last = msg.LogTime
+
+	if msg.LogTime >= s.windowEnd {
+		s.closeWindows(s.windowCount, 1)
+		// The windows skipped up to msg are empty, they are counted at once
+		// so a gap of any length takes a single step.
+		if msg.LogTime >= s.windowEnd {
+			s.closeWindows(0, (msg.LogTime-s.windowEnd)/rateWindow+1)
+		}
+		s.windowCount = 0
+	}
+	s.windowCount++
+}
+
+// closeWindows counts n rate windows of count messages each, starting with
+// the current one.
+func (s *channelScan) closeWindows(count uint64, n uint64) {
+	hz := float64(count) * float64(time.Second) / float64(rateWindow)
+	if s.windows == 0 || hz < s.stats.MinHz {
+		s.stats.MinHz = hz
+	}
+	if s.windows == 0 || hz > s.stats.MaxHz {
+		s.stats.MaxHz = hz
+	}
+	s.windows += n
+	s.windowEnd += n * rateWindow
+}
+
+// addGap keeps the largestGapCount longest gaps, longest first.
+func (s *channelScan) addGap(gap channelGap) {
+	gaps := s.stats.LargestGaps
+	if len(gaps) == largestGapCount && gap.DurationNanos <= gaps[len(gaps)-1].DurationNanos {
+		return
+	}
+	// Equal gaps keep their time order, so the search never reports a match.
+	i, _ := slices.BinarySearchFunc(gaps, gap, func(a, b channelGap) int {
+		if a.DurationNanos >= b.DurationNanos {
+			return -1
+		}
+		return 1
+	})
+	gaps = slices.Insert(gaps, i, gap)
+	if len(gaps) > largestGapCount {
+		gaps = gaps[:largestGapCount]
+	}
+	s.stats.LargestGaps = gaps
+}
+
+func (s *channelScan) result() *channelStats {
+	stats := s.stats
+	if stats.LargestGaps == nil {
+		stats.LargestGaps = []channelGap{}
+	}
+	if stats.MessageCount > 1 {
+		stats.IntervalNanos = s.mean
+		stats.JitterNanos = math.Sqrt(s.m2 / float64(stats.MessageCount-1))
+		if span := s.last - s.first; span > 0 {
+			stats.AverageHz = float64(stats.MessageCount-1) * float64(time.Second) / float64(span)
+		}
+	}
+	if s.windows == 0 {
+		stats.MinHz, stats.MaxHz = stats.AverageHz, stats.AverageHz
+	}
+
+	if len(s.latencies) > 0 {
+		slices.Sort(s.latencies)
+		stats.Latency = &latencyStats{
+			MinNanos: s.latencies[0],
+			P50Nanos: percentile(s.latencies, 50),
+			P90Nanos: percentile(s.latencies, 90),
+			P99Nanos: percentile(s.latencies, 99),
+			MaxNanos: s.latencies[len(s.latencies)-1],
+		}
+	}
+	return &stats
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// scanChannels reads every message of reader in log time order and returns
+// the statistics of each channel by ID.
+func scanChannels(reader *mcap.Reader) (map[uint16]*channelStats, error) {
+	msgs, err := reader.Messages(mcap.InOrder(mcap.LogTimeOrder))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read messages: %s", err)
+	}
+
+	scans := map[uint16]*channelScan{}
+	msg := &mcap.Message{}
+	for {
+		var channel *mcap.Channel
+		_, channel, msg, err = msgs.NextInto(msg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %s", err)
+		}
+		scan, ok := scans[channel.ID]
+		if !ok {
+			scan = &channelScan{}
+			scans[channel.ID] = scan
+		}
+		scan.add(msg)
+	}
+
+	stats := make(map[uint16]*channelStats, len(scans))
+	for id, scan := range scans {
+		stats[id] = scan.result()
+	}
+	return stats, nil
+}
@@ -0,0 +1,170 @@
+package info
+
+import (
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+// scan returns the statistics of messages logged at the given times, each
+// published latency nanoseconds earlier.
+func scan(logTimes []uint64, latency uint64) *channelStats {
+	s := &channelScan{}
+	for _, logTime := range logTimes {
+		msg := &mcap.Message{LogTime: logTime, Data: []byte("data")}
+		if latency > 0 {
+			msg.PublishTime = logTime - latency
+		}
+		s.add(msg)
+	}
+	return s.result()
+}
+
+// every returns count times starting at start, interval apart.
+func every(start time.Duration, interval time.Duration, count int) []uint64 {
+	times := make([]uint64, 0, count)
+	for i := range count {
+		times = append(times, uint64(start+time.Duration(i)*interval))
+	}
+	return times
+}
+
+func TestChannelScanRates(t *testing.T) {
+	tests := []struct {
+		name      string
+		logTimes  []uint64
+		averageHz float64
+		minHz     float64
+		maxHz     float64
+	}{
+		{
+			name:      "steady",
+			logTimes:  every(time.Second, 100*time.Millisecond, 30),
+			averageHz: 10,
+			minHz:     10,
+			maxHz:     10,
+		},
+		{
+			name: "varying",
+			logTimes: append(append(
+				every(time.Second, 200*time.Millisecond, 5),
+				every(2*time.Second, 100*time.Millisecond, 10)...),
+				uint64(3*time.Second)),
+			averageHz: 15 / 2.0,
+			minHz:     5,
+			maxHz:     10,
+		},
+		{
+			name:      "shorter than a window",
+			logTimes:  every(time.Second, 250*time.Millisecond, 3),
+			averageHz: 4,
+			minHz:     4,
+			maxHz:     4,
+		},
+		{
+			name:      "empty windows in a gap",
+			logTimes:  []uint64{0, uint64(500 * time.Millisecond), uint64(3200 * time.Millisecond)},
+			averageHz: 2 / 3.2,
+			minHz:     0,
+			maxHz:     2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := scan(tt.logTimes, 0)
+			assert.Equal(t, uint64(len(tt.logTimes)), stats.MessageCount)
+			assert.Equal(t, uint64(4*len(tt.logTimes)), stats.PayloadBytes)
+			assert.InDelta(t, tt.averageHz, stats.AverageHz, 1e-9)
+			assert.InDelta(t, tt.minHz, stats.MinHz, 1e-9)
+			assert.InDelta(t, tt.maxHz, stats.MaxHz, 1e-9)
+		})
+	}
+}
+
+func TestChannelScanLongGap(t *testing.T) {
+	s := &channelScan{}
+	s.add(&mcap.Message{LogTime: 0})
+	s.add(&mcap.Message{LogTime: 1})
+	// A corrupt timestamp far after the others is a single gap, its empty
+	// windows are counted at once.
+	s.add(&mcap.Message{LogTime: 1 << 62})
+	s.add(&mcap.Message{LogTime: 1<<62 + 1})
+	assert.Equal(t, (1<<62)/rateWindow, s.windows)
+
+	stats := s.result()
+	assert.Equal(t, 0.0, stats.MinHz)
+	assert.Equal(t, 2.0, stats.MaxHz)
+	assert.Equal(t, uint64(1<<62-1), stats.LargestGaps[0].DurationNanos)
+}
+
+func TestChannelScanIntervals(t *testing.T) {
+	stats := scan(every(0, 100*time.Millisecond, 11), 0)
+	assert.InDelta(t, float64(100*time.Millisecond), stats.IntervalNanos, 1e-3)
+	assert.InDelta(t, 0, stats.JitterNanos, 1e-3)
+	assert.Nil(t, stats.Latency)
+
+	// Intervals of 1, 3, 1, 3 and 1, with a mean of 1.8 and a variance of
+	// (3*0.8² + 2*1.2²) / 5.
+	stats = scan([]uint64{0, 1, 4, 5, 8, 9}, 0)
+	assert.InDelta(t, 1.8, stats.IntervalNanos, 1e-9)
+	assert.InDelta(t, math.Sqrt(0.96), stats.JitterNanos, 1e-9)
+}
+
+func TestChannelScanLargestGaps(t *testing.T) {
+	// Intervals of 1, 5, 3, 5, 2, 7 and 4.
+	stats := scan([]uint64{0, 1, 6, 9, 14, 16, 23, 27}, 0)
+	assert.Equal(t, []channelGap{
+		{StartTime: 16, EndTime: 23, DurationNanos: 7},
+		// Equal gaps keep their time order.
+		{StartTime: 1, EndTime: 6, DurationNanos: 5},
+		{StartTime: 9, EndTime: 14, DurationNanos: 5},
+		{StartTime: 23, EndTime: 27, DurationNanos: 4},
+		{StartTime: 6, EndTime: 9, DurationNanos: 3},
+	}, stats.LargestGaps)
+
+	stats = scan([]uint64{10}, 0)
+	assert.Equal(t, []channelGap{}, stats.LargestGaps)
+}
+
+func TestChannelScanLatency(t *testing.T) {
+	stats := scan(every(time.Second, time.Millisecond, 10), uint64(2*time.Millisecond))
+	assert.Equal(t, &latencyStats{
+		MinNanos: int64(2 * time.Millisecond),
+		P50Nanos: int64(2 * time.Millisecond),
+		P90Nanos: int64(2 * time.Millisecond),
+		P99Nanos: int64(2 * time.Millisecond),
+		MaxNanos: int64(2 * time.Millisecond),
+	}, stats.Latency)
+}
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]int64, 0, 100)
+	for i := int64(1); i <= 100; i++ {
+		hundred = append(hundred, i)
+	}
+
+	tests := []struct {
+		name   string
+		sorted []int64
+		p      int
+		want   int64
+	}{
+		{"median of 100", hundred, 50, 50},
+		{"p90 of 100", hundred, 90, 90},
+		{"p99 of 100", hundred, 99, 99},
+		{"p0 is the minimum", hundred, 0, 1},
+		{"p100 is the maximum", hundred, 100, 100},
+		{"median of 3", []int64{10, 20, 30}, 50, 20},
+		{"p90 of 3", []int64{10, 20, 30}, 90, 30},
+		{"single value", []int64{-5}, 99, -5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, percentile(tt.sorted, tt.p))
+		})
+	}
+}
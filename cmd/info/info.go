@@ -1,36 +1,50 @@
 package info
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/foxglove/mcap/go/mcap"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
 	"mcap-utility/internal/constants"
 	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
 	"os"
+	"strings"
+	"time"
 )
 
-type topicInfo struct {
-	topicID        uint16
-	name           string
-	messageCount   uint64
-	topicEncoding  string
-	schemaID       uint16
-	schemaName     string
-	schemaEncoding string
-}
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
 
 var InfoCmd = &cobra.Command{
 	Use:   "info",
-	Short: fmt.Sprintf("List the detail of a (%s) file", constants.MCAPFIleExtension),
-	Long:  fmt.Sprintf("List the detail of a (%s) file", constants.MCAPFIleExtension),
+	Short: fmt.Sprintf("List the detail of a (%s) file or directory", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		"List the detail of a (%s) file, or of every file in a directory followed by a summary across them",
+		constants.MCAPFIleExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch format {
+		case formatTable, formatJSON, formatYAML:
+		default:
+			return fmt.Errorf("invalid format %s, must be one of %s, %s or %s", format, formatTable, formatJSON, formatYAML)
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		info()
 	},
 }
 
 var (
-	topicInfoMapper = map[string]*topicInfo{}
-	file            string
+	file   string
+	input  string
+	format string
+	deep   bool
 )
 
 func init() {
@@ -47,73 +61,207 @@ func init() {
 			),
 		)
 
-	_ = InfoCmd.MarkFlagRequired("file")
+	InfoCmd.
+		Flags().
+		StringVarP(
+			&input,
+			"input",
+			"i",
+			"",
+			fmt.Sprintf(
+				"Input (%s) file or directory to retrieve information.",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	InfoCmd.
+		Flags().
+		StringVar(
+			&format,
+			"format",
+			formatTable,
+			fmt.Sprintf("Output format: %s, %s or %s", formatTable, formatJSON, formatYAML),
+		)
+
+	InfoCmd.
+		Flags().
+		BoolVar(
+			&deep,
+			"deep",
+			false,
+			"Read every message to report the rate, jitter, largest gaps, publish to log time latency and payload size of each topic",
+		)
+
+	InfoCmd.MarkFlagsOneRequired("file", "input")
+	InfoCmd.MarkFlagsMutuallyExclusive("file", "input")
 }
 
 func info() {
-	mcapFile, err := os.Open(file)
-	if err != nil {
-		logging.GetLogger().Error(err.Error())
-		os.Exit(1)
+	isDir := false
+	if input != "" {
+		var err error
+		isDir, err = utils.IsPathDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		input = file
 	}
-	defer func(mcapFile *os.File) {
-		cErr := mcapFile.Close()
-		if cErr != nil && err == nil {
-			err = cErr
+
+	if !isDir {
+		report, err := readReport(input, deep)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
 		}
-	}(mcapFile)
 
-	reader, err := mcap.NewReader(mcapFile)
+		if err = printReport(os.Stdout, report); err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
 	if err != nil {
 		logging.GetLogger().Error(err.Error())
 		os.Exit(1)
 	}
-	defer reader.Close()
+	if len(mcapFiles) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files in %s", constants.MCAPFIleExtension, input))
+		os.Exit(0)
+	}
 
-	info, err := reader.Info()
-	if err != nil {
+	reports, failed := readReports(mcapFiles, deep)
+	report := &directoryReport{
+		Files:   reports,
+		Errors:  failed,
+		Summary: summarize(reports),
+	}
+
+	if err = printReport(os.Stdout, report); err != nil {
 		logging.GetLogger().Error(err.Error())
 		os.Exit(1)
 	}
+	for _, f := range failed {
+		logging.GetLogger().Error(fmt.Sprintf("Failed to read %s: %s", f.File, f.Error))
+	}
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
 
-	for _, channel := range info.Channels {
-		topicInfoMapper[channel.Topic] = &topicInfo{
-			topicID:        channel.ID,
-			name:           channel.Topic,
-			messageCount:   info.ChannelCounts()[channel.Topic],
-			topicEncoding:  channel.MessageEncoding,
-			schemaID:       channel.SchemaID,
-			schemaName:     info.Schemas[channel.SchemaID].Name,
-			schemaEncoding: info.Schemas[channel.SchemaID].Encoding,
+	os.Exit(0)
+}
+
+// printReport prints a *fileReport or a *directoryReport in the selected
+// format.
+func printReport(w io.Writer, report any) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case formatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		switch report := report.(type) {
+		case *fileReport:
+			printTable(w, report)
+		case *directoryReport:
+			for _, fileReport := range report.Files {
+				fmt.Fprintf(w, "==> %s <==\n", fileReport.File)
+				printTable(w, fileReport)
+				fmt.Fprintln(w)
+			}
+			printSummaryTable(w, &report.Summary)
 		}
+		return nil
+	}
+}
+
+func printTable(w io.Writer, report *fileReport) {
+	fmt.Fprintf(w, "File Size:              %d\n", report.Size)
+	fmt.Fprintf(w, "Header Library:         %s\n", report.Header.Library)
+	fmt.Fprintf(w, "Header Profile:         %s\n", report.Header.Profile)
+	if footer := report.Footer; footer != nil {
+		fmt.Fprintf(w, "Summary Start:          %d\n", footer.SummaryStart)
+		fmt.Fprintf(w, "Summary Offset Start:   %d\n", footer.SummaryOffsetStart)
+		fmt.Fprintf(w, "Summary CRC:            %d\n", footer.SummaryCRC)
 	}
+	if stats := report.Statistics; stats != nil {
+		fmt.Fprintf(w, "Schema Count:           %d\n", stats.SchemaCount)
+		fmt.Fprintf(w, "Chunk Count:            %d\n", stats.ChunkCount)
+		fmt.Fprintf(w, "Metadata Count:         %d\n", stats.MetadataCount)
+		fmt.Fprintf(w, "Attachment Count:       %d\n", stats.AttachmentCount)
+		fmt.Fprintf(w, "Message Start Time:     %d\n", stats.MessageStartTime)
+		fmt.Fprintf(w, "Message End Time:       %d\n", stats.MessageEndTime)
+		fmt.Fprintf(w, "Message Count:          %d\n", stats.MessageCount)
+	}
+	fmt.Fprintf(w, "Metadata Index Count:   %d\n", len(report.Metadata))
+	fmt.Fprintf(w, "Attachment Index Count: %d\n", len(report.Attachments))
+	fmt.Fprintf(w, "Chunk Index Count:      %d\n", len(report.Chunks))
+	fmt.Fprintf(w, "Topic Count:            %d\n", len(report.Channels))
 
-	fmt.Println(fmt.Sprintf("Header Library:         %s", reader.Header().Library))
-	fmt.Println(fmt.Sprintf("Header Profile:         %s", reader.Header().Profile))
-	fmt.Println(fmt.Sprintf("Summary Start:          %d", info.Footer.SummaryStart))
-	fmt.Println(fmt.Sprintf("Summary Offset Start:   %d", info.Footer.SummaryOffsetStart))
-	fmt.Println(fmt.Sprintf("Summary CRC:            %d", info.Footer.SummaryCRC))
-	fmt.Println(fmt.Sprintf("Schema Count:           %d", info.Statistics.SchemaCount))
-	fmt.Println(fmt.Sprintf("Chunk Count:            %d", info.Statistics.ChunkCount))
-	fmt.Println(fmt.Sprintf("Metadata Count:         %d", info.Statistics.MetadataCount))
-	fmt.Println(fmt.Sprintf("Attachment Count:       %d", info.Statistics.AttachmentCount))
-	fmt.Println(fmt.Sprintf("Message Start Time:     %d", info.Statistics.MessageStartTime))
-	fmt.Println(fmt.Sprintf("Message End Time:       %d", info.Statistics.MessageEndTime))
-	fmt.Println(fmt.Sprintf("Message Count:          %d", info.Statistics.MessageCount))
-	fmt.Println(fmt.Sprintf("Metadata Index Count:   %d", len(info.MetadataIndexes)))
-	fmt.Println(fmt.Sprintf("Attachment Index Count: %d", len(info.AttachmentIndexes)))
-	fmt.Println(fmt.Sprintf("Chunk Index Count:      %d", len(info.ChunkIndexes)))
-	fmt.Println(fmt.Sprintf("Topic Count:            %d", info.Statistics.ChannelCount))
+	for _, metadata := range report.Metadata {
+		fmt.Fprintf(w, "Metadata Name: %s | Offset: %d | Length: %d\n", metadata.Name, metadata.Offset, metadata.Length)
+	}
 
-	for _, metadata := range info.MetadataIndexes {
-		fmt.Println(fmt.Sprintf("Metadata Name: %s | Offset: %d | Length: %d", metadata.Name, metadata.Offset, metadata.Length))
+	for _, attachment := range report.Attachments {
+		fmt.Fprintf(w, "Attachment Name: %s | Media Type: %s | Log Time: %d | Size: %d\n",
+			attachment.Name, attachment.MediaType, attachment.LogTime, attachment.DataSize)
 	}
 
-	for _, v := range topicInfoMapper {
-		fmt.Println(fmt.Sprintf("Topic ID: %d | Topic: %s | Message Count: %d | Topic Encoding: %s | "+
-			"Schema ID: %d | Schema Name: %s | Schema Encoding: %s",
-			v.topicID, v.name, v.messageCount, v.topicEncoding, v.schemaID, v.schemaName, v.schemaEncoding))
+	for _, channel := range report.Channels {
+		fmt.Fprintf(w, "Topic ID: %d | Topic: %s | Message Count: %d | Topic Encoding: %s | "+
+			"Schema ID: %d | Schema Name: %s | Schema Encoding: %s\n",
+			channel.ID, channel.Topic, channel.MessageCount, channel.MessageEncoding,
+			channel.SchemaID, channel.SchemaName, channel.SchemaEncoding)
+		if stats := channel.Stats; stats != nil {
+			printStatsTable(w, stats)
+		}
 	}
+}
 
-	os.Exit(0)
+func printStatsTable(w io.Writer, stats *channelStats) {
+	fmt.Fprintf(w, "  Rate: %.2f Hz (min %.2f, max %.2f) | Interval: %s | Jitter: %s | Payload: %d bytes\n",
+		stats.AverageHz, stats.MinHz, stats.MaxHz,
+		time.Duration(stats.IntervalNanos), time.Duration(stats.JitterNanos), stats.PayloadBytes)
+	if latency := stats.Latency; latency != nil {
+		fmt.Fprintf(w, "  Latency: min %s | p50 %s | p90 %s | p99 %s | max %s\n",
+			time.Duration(latency.MinNanos), time.Duration(latency.P50Nanos), time.Duration(latency.P90Nanos),
+			time.Duration(latency.P99Nanos), time.Duration(latency.MaxNanos))
+	}
+	for _, gap := range stats.LargestGaps {
+		fmt.Fprintf(w, "  Gap: %s | Start: %d | End: %d\n", time.Duration(gap.DurationNanos), gap.StartTime, gap.EndTime)
+	}
+}
+
+func printSummaryTable(w io.Writer, summary *summaryReport) {
+	fmt.Fprintf(w, "File Count:             %d\n", summary.FileCount)
+	fmt.Fprintf(w, "Total Size:             %d\n", summary.TotalBytes)
+	fmt.Fprintf(w, "Message Count:          %d\n", summary.MessageCount)
+	fmt.Fprintf(w, "Message Start Time:     %d\n", summary.StartTime)
+	fmt.Fprintf(w, "Message End Time:       %d\n", summary.EndTime)
+	fmt.Fprintf(w, "Total Duration:         %s\n", time.Duration(summary.DurationNanos))
+	fmt.Fprintf(w, "Span:                   %s\n", time.Duration(summary.SpanNanos))
+	fmt.Fprintf(w, "Topic Count:            %d\n", len(summary.Topics))
+
+	for _, topic := range summary.Topics {
+		fmt.Fprintf(w, "Topic: %s | Message Count: %d | Files: %d/%d\n",
+			topic.Topic, topic.MessageCount, topic.FileCount, summary.FileCount)
+		if len(topic.MissingFrom) > 0 {
+			fmt.Fprintf(w, "  Missing From: %s\n", strings.Join(topic.MissingFrom, ", "))
+		}
+	}
+
+	for _, gap := range summary.Gaps {
+		fmt.Fprintf(w, "Gap: %s | After: %s | Before: %s | Start: %d | End: %d\n",
+			time.Duration(gap.DurationNanos), gap.After, gap.Before, gap.StartTime, gap.EndTime)
+	}
 }
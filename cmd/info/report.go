@@ -0,0 +1,256 @@
+package info
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"maps"
+	"os"
+	"slices"
+)
+
+// fileReport is the content of a single file printed by info. Its JSON and
+// YAML encodings are a stable interface: fields may be added, but existing
+// ones are neither renamed nor removed. Lists are sorted so the output of a
+// file never changes from one run to the next.
+type fileReport struct {
+	File        string             `json:"file" yaml:"file"`
+	Size        int64              `json:"size" yaml:"size"`
+	Header      headerReport       `json:"header" yaml:"header"`
+	Footer      *footerReport      `json:"footer,omitempty" yaml:"footer,omitempty"`
+	Statistics  *statisticsReport  `json:"statistics,omitempty" yaml:"statistics,omitempty"`
+	Schemas     []schemaReport     `json:"schemas" yaml:"schemas"`
+	Channels    []channelReport    `json:"channels" yaml:"channels"`
+	Chunks      []chunkReport      `json:"chunks" yaml:"chunks"`
+	Metadata    []metadataReport   `json:"metadata" yaml:"metadata"`
+	Attachments []attachmentReport `json:"attachments" yaml:"attachments"`
+}
+
+type headerReport struct {
+	Profile string `json:"profile" yaml:"profile"`
+	Library string `json:"library" yaml:"library"`
+}
+
+type footerReport struct {
+	SummaryStart       uint64 `json:"summary_start" yaml:"summary_start"`
+	SummaryOffsetStart uint64 `json:"summary_offset_start" yaml:"summary_offset_start"`
+	SummaryCRC         uint32 `json:"summary_crc" yaml:"summary_crc"`
+}
+
+type statisticsReport struct {
+	MessageCount     uint64 `json:"message_count" yaml:"message_count"`
+	SchemaCount      uint16 `json:"schema_count" yaml:"schema_count"`
+	ChannelCount     uint32 `json:"channel_count" yaml:"channel_count"`
+	AttachmentCount  uint32 `json:"attachment_count" yaml:"attachment_count"`
+	MetadataCount    uint32 `json:"metadata_count" yaml:"metadata_count"`
+	ChunkCount       uint32 `json:"chunk_count" yaml:"chunk_count"`
+	MessageStartTime uint64 `json:"message_start_time" yaml:"message_start_time"`
+	MessageEndTime   uint64 `json:"message_end_time" yaml:"message_end_time"`
+	// DurationNanos is MessageEndTime - MessageStartTime.
+	DurationNanos uint64 `json:"duration_ns" yaml:"duration_ns"`
+}
+
+// schemaReport leaves out the schema data, which can be large.
+type schemaReport struct {
+	ID       uint16 `json:"id" yaml:"id"`
+	Name     string `json:"name" yaml:"name"`
+	Encoding string `json:"encoding" yaml:"encoding"`
+	DataSize int    `json:"data_size" yaml:"data_size"`
+}
+
+type channelReport struct {
+	ID              uint16            `json:"id" yaml:"id"`
+	Topic           string            `json:"topic" yaml:"topic"`
+	MessageEncoding string            `json:"message_encoding" yaml:"message_encoding"`
+	MessageCount    uint64            `json:"message_count" yaml:"message_count"`
+	SchemaID        uint16            `json:"schema_id" yaml:"schema_id"`
+	SchemaName      string            `json:"schema_name" yaml:"schema_name"`
+	SchemaEncoding  string            `json:"schema_encoding" yaml:"schema_encoding"`
+	Metadata        map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Stats is only filled by a --deep scan.
+	Stats *channelStats `json:"stats,omitempty" yaml:"stats,omitempty"`
+}
+
+type chunkReport struct {
+	Offset           uint64 `json:"offset" yaml:"offset"`
+	Length           uint64 `json:"length" yaml:"length"`
+	MessageStartTime uint64 `json:"message_start_time" yaml:"message_start_time"`
+	MessageEndTime   uint64 `json:"message_end_time" yaml:"message_end_time"`
+	Compression      string `json:"compression" yaml:"compression"`
+	CompressedSize   uint64 `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize uint64 `json:"uncompressed_size" yaml:"uncompressed_size"`
+}
+
+type metadataReport struct {
+	Name     string            `json:"name" yaml:"name"`
+	Offset   uint64            `json:"offset" yaml:"offset"`
+	Length   uint64            `json:"length" yaml:"length"`
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+type attachmentReport struct {
+	Name       string `json:"name" yaml:"name"`
+	MediaType  string `json:"media_type" yaml:"media_type"`
+	LogTime    uint64 `json:"log_time" yaml:"log_time"`
+	CreateTime uint64 `json:"create_time" yaml:"create_time"`
+	DataSize   uint64 `json:"data_size" yaml:"data_size"`
+	Offset     uint64 `json:"offset" yaml:"offset"`
+	Length     uint64 `json:"length" yaml:"length"`
+}
+
+// readReport reads the summary section of filePath. With deep, it also reads
+// every message to fill the statistics of the channels.
+func readReport(filePath string, deep bool) (*fileReport, error) {
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer mcapFile.Close()
+
+	stat, err := mcapFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := mcap.NewReader(mcapFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new reader: %s", err)
+	}
+	defer reader.Close()
+
+	info, err := reader.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mcap info: %s", err)
+	}
+
+	report := &fileReport{
+		File: filePath,
+		Size: stat.Size(),
+		Header: headerReport{
+			Profile: reader.Header().Profile,
+			Library: reader.Header().Library,
+		},
+		Schemas:     make([]schemaReport, 0, len(info.Schemas)),
+		Channels:    make([]channelReport, 0, len(info.Channels)),
+		Chunks:      make([]chunkReport, 0, len(info.ChunkIndexes)),
+		Metadata:    make([]metadataReport, 0, len(info.MetadataIndexes)),
+		Attachments: make([]attachmentReport, 0, len(info.AttachmentIndexes)),
+	}
+
+	if info.Footer != nil {
+		report.Footer = &footerReport{
+			SummaryStart:       info.Footer.SummaryStart,
+			SummaryOffsetStart: info.Footer.SummaryOffsetStart,
+			SummaryCRC:         info.Footer.SummaryCRC,
+		}
+	}
+
+	var channelCounts map[uint16]uint64
+	if stats := info.Statistics; stats != nil {
+		report.Statistics = &statisticsReport{
+			MessageCount:     stats.MessageCount,
+			SchemaCount:      stats.SchemaCount,
+			ChannelCount:     stats.ChannelCount,
+			AttachmentCount:  stats.AttachmentCount,
+			MetadataCount:    stats.MetadataCount,
+			ChunkCount:       stats.ChunkCount,
+			MessageStartTime: stats.MessageStartTime,
+			MessageEndTime:   stats.MessageEndTime,
+		}
+		if stats.MessageEndTime > stats.MessageStartTime {
+			report.Statistics.DurationNanos = stats.MessageEndTime - stats.MessageStartTime
+		}
+		channelCounts = stats.ChannelMessageCounts
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(info.Schemas)) {
+		schema := info.Schemas[id]
+		report.Schemas = append(report.Schemas, schemaReport{
+			ID:       schema.ID,
+			Name:     schema.Name,
+			Encoding: schema.Encoding,
+			DataSize: len(schema.Data),
+		})
+	}
+
+	var deepStats map[uint16]*channelStats
+	if deep {
+		deepStats, err = scanChannels(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, channel := range info.Channels {
+		channelReport := channelReport{
+			ID:              channel.ID,
+			Topic:           channel.Topic,
+			MessageEncoding: channel.MessageEncoding,
+			MessageCount:    channelCounts[channel.ID],
+			SchemaID:        channel.SchemaID,
+			Metadata:        channel.Metadata,
+		}
+		if schema := info.Schemas[channel.SchemaID]; schema != nil {
+			channelReport.SchemaName = schema.Name
+			channelReport.SchemaEncoding = schema.Encoding
+		}
+		if deep {
+			channelReport.Stats = deepStats[channel.ID]
+			if channelReport.Stats == nil {
+				channelReport.Stats = &channelStats{LargestGaps: []channelGap{}}
+			}
+		}
+		report.Channels = append(report.Channels, channelReport)
+	}
+	slices.SortFunc(report.Channels, func(a, b channelReport) int {
+		return cmp.Or(cmp.Compare(a.Topic, b.Topic), cmp.Compare(a.ID, b.ID))
+	})
+
+	for _, idx := range info.ChunkIndexes {
+		report.Chunks = append(report.Chunks, chunkReport{
+			Offset:           idx.ChunkStartOffset,
+			Length:           idx.ChunkLength,
+			MessageStartTime: idx.MessageStartTime,
+			MessageEndTime:   idx.MessageEndTime,
+			Compression:      string(idx.Compression),
+			CompressedSize:   idx.CompressedSize,
+			UncompressedSize: idx.UncompressedSize,
+		})
+	}
+	slices.SortFunc(report.Chunks, func(a, b chunkReport) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	for _, idx := range info.MetadataIndexes {
+		metadata, err := reader.GetMetadata(idx.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata %s: %s", idx.Name, err)
+		}
+		report.Metadata = append(report.Metadata, metadataReport{
+			Name:     idx.Name,
+			Offset:   idx.Offset,
+			Length:   idx.Length,
+			Metadata: metadata.Metadata,
+		})
+	}
+	slices.SortFunc(report.Metadata, func(a, b metadataReport) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	for _, idx := range info.AttachmentIndexes {
+		report.Attachments = append(report.Attachments, attachmentReport{
+			Name:       idx.Name,
+			MediaType:  idx.MediaType,
+			LogTime:    idx.LogTime,
+			CreateTime: idx.CreateTime,
+			DataSize:   idx.DataSize,
+			Offset:     idx.Offset,
+			Length:     idx.Length,
+		})
+	}
+	slices.SortFunc(report.Attachments, func(a, b attachmentReport) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	return report, nil
+}
@@ -0,0 +1,153 @@
+package info
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeReportFile writes channels, metadata and attachments out of the order
+// they are reported in.
+func writeReportFile(t *testing.T, path string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	writer, err := mcap.NewWriter(f, &mcap.WriterOptions{Chunked: true, ChunkSize: 64, Compression: mcap.CompressionZSTD})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 2, Name: "std_msgs/Int32", Encoding: "ros1msg", Data: []byte("int32 data")}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 3, SchemaID: 2, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/z", MessageEncoding: "ros1", Metadata: map[string]string{"k": "v"}}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"id": "1"}}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "device", Metadata: map[string]string{"id": "2"}}))
+	for _, name := range []string{"z.yaml", "a.yaml"} {
+		assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{
+			Name:      name,
+			MediaType: "text/yaml",
+			DataSize:  3,
+			Data:      bytes.NewReader([]byte("abc")),
+		}))
+	}
+	for i := uint64(0); i < 10; i++ {
+		for _, channelID := range []uint16{1, 2, 3} {
+			assert.NoError(t, writer.WriteMessage(&mcap.Message{
+				ChannelID:   channelID,
+				LogTime:     1e9 + i*1e8,
+				PublishTime: 1e9 + i*1e8 - 1e6,
+				Data:        []byte("payload"),
+			}))
+		}
+	}
+	assert.NoError(t, writer.Close())
+}
+
+func sortedKeys(t *testing.T, value any) []string {
+	t.Helper()
+	object, ok := value.(map[string]any)
+	assert.True(t, ok, "%v is not an object", value)
+	return slices.Sorted(maps.Keys(object))
+}
+
+func TestReportEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.mcap")
+	writeReportFile(t, path)
+	report, err := readReport(path, true)
+	assert.NoError(t, err)
+
+	defer func(previous string) { format = previous }(format)
+	var encoded []map[string]any
+	for _, f := range []string{formatJSON, formatYAML} {
+		format = f
+		out := &bytes.Buffer{}
+		assert.NoError(t, printReport(out, report))
+		decoded := map[string]any{}
+		if f == formatJSON {
+			assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+		} else {
+			assert.NoError(t, yaml.Unmarshal(out.Bytes(), &decoded))
+		}
+		encoded = append(encoded, decoded)
+	}
+
+	for _, decoded := range encoded {
+		assert.Equal(t, []string{
+			"attachments", "channels", "chunks", "file", "footer", "header", "metadata", "schemas", "size", "statistics",
+		}, sortedKeys(t, decoded))
+		assert.Equal(t, []string{"library", "profile"}, sortedKeys(t, decoded["header"]))
+		assert.Equal(t, []string{"summary_crc", "summary_offset_start", "summary_start"}, sortedKeys(t, decoded["footer"]))
+		assert.Equal(t, []string{
+			"attachment_count", "channel_count", "chunk_count", "duration_ns", "message_count", "message_end_time",
+			"message_start_time", "metadata_count", "schema_count",
+		}, sortedKeys(t, decoded["statistics"]))
+
+		schemas := decoded["schemas"].([]any)
+		assert.Equal(t, []string{"data_size", "encoding", "id", "name"}, sortedKeys(t, schemas[0]))
+
+		channels := decoded["channels"].([]any)
+		assert.Equal(t, []string{
+			"id", "message_count", "message_encoding", "metadata", "schema_encoding", "schema_id", "schema_name",
+			"stats", "topic",
+		}, sortedKeys(t, channels[2]))
+		stats := channels[0].(map[string]any)["stats"]
+		assert.Equal(t, []string{
+			"average_hz", "interval_ns", "jitter_ns", "largest_gaps", "latency", "max_hz", "message_count", "min_hz",
+			"payload_bytes",
+		}, sortedKeys(t, stats))
+		gaps := stats.(map[string]any)["largest_gaps"].([]any)
+		assert.Equal(t, []string{"duration_ns", "end_time", "start_time"}, sortedKeys(t, gaps[0]))
+		latency := stats.(map[string]any)["latency"]
+		assert.Equal(t, []string{"max_ns", "min_ns", "p50_ns", "p90_ns", "p99_ns"}, sortedKeys(t, latency))
+
+		chunks := decoded["chunks"].([]any)
+		assert.Equal(t, []string{
+			"compressed_size", "compression", "length", "message_end_time", "message_start_time", "offset",
+			"uncompressed_size",
+		}, sortedKeys(t, chunks[0]))
+		metadata := decoded["metadata"].([]any)
+		assert.Equal(t, []string{"length", "metadata", "name", "offset"}, sortedKeys(t, metadata[0]))
+		attachments := decoded["attachments"].([]any)
+		assert.Equal(t, []string{
+			"create_time", "data_size", "length", "log_time", "media_type", "name", "offset",
+		}, sortedKeys(t, attachments[0]))
+	}
+
+	// Schemas are sorted by ID, channels by topic then ID, and chunks,
+	// metadata and attachments by offset, which is the order of the file.
+	assert.Equal(t, []uint16{1, 2}, []uint16{report.Schemas[0].ID, report.Schemas[1].ID})
+	var channels []string
+	for _, channel := range report.Channels {
+		channels = append(channels, fmt.Sprintf("%s#%d", channel.Topic, channel.ID))
+	}
+	assert.Equal(t, []string{"/a#2", "/a#3", "/z#1"}, channels)
+	assert.Equal(t, "run", report.Metadata[0].Name)
+	assert.Equal(t, "device", report.Metadata[1].Name)
+	assert.Equal(t, "z.yaml", report.Attachments[0].Name)
+	assert.Equal(t, "a.yaml", report.Attachments[1].Name)
+	assert.Greater(t, len(report.Chunks), 1)
+	assert.True(t, slices.IsSortedFunc(report.Chunks, func(a, b chunkReport) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	}))
+
+	// Reading the file again gives the same encoding.
+	again, err := readReport(path, true)
+	assert.NoError(t, err)
+	first, second := &bytes.Buffer{}, &bytes.Buffer{}
+	format = formatJSON
+	assert.NoError(t, printReport(first, report))
+	assert.NoError(t, printReport(second, again))
+	assert.Equal(t, first.String(), second.String())
+}
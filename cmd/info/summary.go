@@ -0,0 +1,164 @@
+package info
+
+import (
+	"cmp"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// directoryReport is printed when info reads a directory. It holds the
+// report of every file, sorted by path, and the summary across them.
+type directoryReport struct {
+	Files   []*fileReport `json:"files" yaml:"files"`
+	Errors  []fileError   `json:"errors,omitempty" yaml:"errors,omitempty"`
+	Summary summaryReport `json:"summary" yaml:"summary"`
+}
+
+type fileError struct {
+	File  string `json:"file" yaml:"file"`
+	Error string `json:"error" yaml:"error"`
+}
+
+type summaryReport struct {
+	FileCount    int    `json:"file_count" yaml:"file_count"`
+	TotalBytes   int64  `json:"total_bytes" yaml:"total_bytes"`
+	MessageCount uint64 `json:"message_count" yaml:"message_count"`
+	// StartTime and EndTime are the first and last message log times of
+	// all files.
+	StartTime uint64 `json:"start_time" yaml:"start_time"`
+	EndTime   uint64 `json:"end_time" yaml:"end_time"`
+	// DurationNanos adds up the duration of every file, SpanNanos is
+	// EndTime - StartTime and also counts the gaps between files.
+	DurationNanos uint64         `json:"duration_ns" yaml:"duration_ns"`
+	SpanNanos     uint64         `json:"span_ns" yaml:"span_ns"`
+	Topics        []topicSummary `json:"topics" yaml:"topics"`
+	Gaps          []gapReport    `json:"gaps" yaml:"gaps"`
+}
+
+type topicSummary struct {
+	Topic        string `json:"topic" yaml:"topic"`
+	MessageCount uint64 `json:"message_count" yaml:"message_count"`
+	FileCount    int    `json:"file_count" yaml:"file_count"`
+	// MissingFrom lists the files without a channel on this topic.
+	MissingFrom []string `json:"missing_from,omitempty" yaml:"missing_from,omitempty"`
+}
+
+// gapReport is a time range between two files, sorted by their start time,
+// without any message.
+type gapReport struct {
+	After         string `json:"after" yaml:"after"`
+	Before        string `json:"before" yaml:"before"`
+	StartTime     uint64 `json:"start_time" yaml:"start_time"`
+	EndTime       uint64 `json:"end_time" yaml:"end_time"`
+	DurationNanos uint64 `json:"duration_ns" yaml:"duration_ns"`
+}
+
+// readReports reads the reports of files on all CPU cores. The reports and
+// errors keep the order of files.
+func readReports(files []string, deep bool) ([]*fileReport, []fileError) {
+	reports := make([]*fileReport, len(files))
+	errs := make([]error, len(files))
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, filePath := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reports[i], errs[i] = readReport(filePath, deep)
+		}()
+	}
+	wg.Wait()
+
+	var ok []*fileReport
+	var failed []fileError
+	for i, report := range reports {
+		if errs[i] != nil {
+			failed = append(failed, fileError{File: files[i], Error: errs[i].Error()})
+			continue
+		}
+		ok = append(ok, report)
+	}
+	return ok, failed
+}
+
+// summarize combines the reports of several files.
+func summarize(reports []*fileReport) summaryReport {
+	summary := summaryReport{
+		FileCount: len(reports),
+		Topics:    []topicSummary{},
+		Gaps:      []gapReport{},
+	}
+
+	topics := map[string]*topicSummary{}
+	timed := make([]*fileReport, 0, len(reports))
+	for _, report := range reports {
+		summary.TotalBytes += report.Size
+
+		seen := map[string]bool{}
+		for _, channel := range report.Channels {
+			topic, ok := topics[channel.Topic]
+			if !ok {
+				topic = &topicSummary{Topic: channel.Topic}
+				topics[channel.Topic] = topic
+			}
+			topic.MessageCount += channel.MessageCount
+			if !seen[channel.Topic] {
+				seen[channel.Topic] = true
+				topic.FileCount++
+			}
+		}
+
+		stats := report.Statistics
+		if stats == nil || stats.MessageCount == 0 {
+			continue
+		}
+		summary.MessageCount += stats.MessageCount
+		summary.DurationNanos += stats.DurationNanos
+		if len(timed) == 0 || stats.MessageStartTime < summary.StartTime {
+			summary.StartTime = stats.MessageStartTime
+		}
+		if stats.MessageEndTime > summary.EndTime {
+			summary.EndTime = stats.MessageEndTime
+		}
+		timed = append(timed, report)
+	}
+	summary.SpanNanos = summary.EndTime - summary.StartTime
+
+	for _, name := range slices.Sorted(maps.Keys(topics)) {
+		topic := topics[name]
+		if topic.FileCount < len(reports) {
+			for _, report := range reports {
+				if !slices.ContainsFunc(report.Channels, func(c channelReport) bool { return c.Topic == name }) {
+					topic.MissingFrom = append(topic.MissingFrom, report.File)
+				}
+			}
+		}
+		summary.Topics = append(summary.Topics, *topic)
+	}
+
+	slices.SortStableFunc(timed, func(a, b *fileReport) int {
+		return cmp.Compare(a.Statistics.MessageStartTime, b.Statistics.MessageStartTime)
+	})
+	var last *fileReport
+	for _, report := range timed {
+		if last != nil && report.Statistics.MessageStartTime > last.Statistics.MessageEndTime {
+			summary.Gaps = append(summary.Gaps, gapReport{
+				After:         last.File,
+				Before:        report.File,
+				StartTime:     last.Statistics.MessageEndTime,
+				EndTime:       report.Statistics.MessageStartTime,
+				DurationNanos: report.Statistics.MessageStartTime - last.Statistics.MessageEndTime,
+			})
+		}
+		if last == nil || report.Statistics.MessageEndTime > last.Statistics.MessageEndTime {
+			last = report
+		}
+	}
+
+	return summary
+}
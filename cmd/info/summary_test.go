@@ -0,0 +1,163 @@
+package info
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// timedReport returns the report of a file with messages from start to end on
+// the given topics.
+func timedReport(file string, start uint64, end uint64, topics ...string) *fileReport {
+	report := &fileReport{
+		File: file,
+		Size: 100,
+		Statistics: &statisticsReport{
+			MessageCount:     10,
+			MessageStartTime: start,
+			MessageEndTime:   end,
+			DurationNanos:    end - start,
+		},
+	}
+	for _, topic := range topics {
+		report.Channels = append(report.Channels, channelReport{Topic: topic, MessageCount: 5})
+	}
+	return report
+}
+
+func TestSummarizeGaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []*fileReport
+		gaps    []gapReport
+	}{
+		{
+			name: "contiguous",
+			reports: []*fileReport{
+				timedReport("a", 100, 200),
+				timedReport("b", 200, 300),
+			},
+			gaps: []gapReport{},
+		},
+		{
+			name: "gap between files out of order",
+			reports: []*fileReport{
+				timedReport("b", 300, 400),
+				timedReport("a", 100, 200),
+			},
+			gaps: []gapReport{
+				{After: "a", Before: "b", StartTime: 200, EndTime: 300, DurationNanos: 100},
+			},
+		},
+		{
+			name: "overlapping files",
+			reports: []*fileReport{
+				timedReport("a", 100, 250),
+				timedReport("b", 200, 300),
+				timedReport("c", 350, 400),
+			},
+			gaps: []gapReport{
+				{After: "b", Before: "c", StartTime: 300, EndTime: 350, DurationNanos: 50},
+			},
+		},
+		{
+			name: "file inside a longer file",
+			reports: []*fileReport{
+				timedReport("a", 100, 500),
+				timedReport("b", 200, 300),
+				timedReport("c", 600, 700),
+			},
+			gaps: []gapReport{
+				{After: "a", Before: "c", StartTime: 500, EndTime: 600, DurationNanos: 100},
+			},
+		},
+		{
+			name: "files without messages are ignored",
+			reports: []*fileReport{
+				timedReport("a", 100, 200),
+				{File: "empty", Statistics: &statisticsReport{}},
+				{File: "no-summary"},
+				timedReport("b", 400, 500),
+			},
+			gaps: []gapReport{
+				{After: "a", Before: "b", StartTime: 200, EndTime: 400, DurationNanos: 200},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := summarize(tt.reports)
+			assert.Equal(t, tt.gaps, summary.Gaps)
+		})
+	}
+}
+
+func TestSummarizeTotals(t *testing.T) {
+	summary := summarize([]*fileReport{
+		timedReport("b", 300, 400),
+		timedReport("a", 100, 200),
+		{File: "empty", Size: 50},
+	})
+	assert.Equal(t, 3, summary.FileCount)
+	assert.Equal(t, int64(250), summary.TotalBytes)
+	assert.Equal(t, uint64(20), summary.MessageCount)
+	assert.Equal(t, uint64(100), summary.StartTime)
+	assert.Equal(t, uint64(400), summary.EndTime)
+	assert.Equal(t, uint64(200), summary.DurationNanos)
+	assert.Equal(t, uint64(300), summary.SpanNanos)
+}
+
+func TestSummarizeTopics(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []*fileReport
+		topics  []topicSummary
+	}{
+		{
+			name: "topics in every file",
+			reports: []*fileReport{
+				timedReport("a", 100, 200, "/imu", "/gps"),
+				timedReport("b", 200, 300, "/gps", "/imu"),
+			},
+			topics: []topicSummary{
+				{Topic: "/gps", MessageCount: 10, FileCount: 2},
+				{Topic: "/imu", MessageCount: 10, FileCount: 2},
+			},
+		},
+		{
+			name: "topics missing from files",
+			reports: []*fileReport{
+				timedReport("a", 100, 200, "/imu", "/gps"),
+				timedReport("b", 200, 300, "/imu"),
+				timedReport("c", 300, 400, "/camera"),
+			},
+			topics: []topicSummary{
+				{Topic: "/camera", MessageCount: 5, FileCount: 1, MissingFrom: []string{"a", "b"}},
+				{Topic: "/gps", MessageCount: 5, FileCount: 1, MissingFrom: []string{"b", "c"}},
+				{Topic: "/imu", MessageCount: 10, FileCount: 2, MissingFrom: []string{"c"}},
+			},
+		},
+		{
+			name: "several channels on a topic count the file once",
+			reports: []*fileReport{
+				timedReport("a", 100, 200, "/imu", "/imu"),
+				timedReport("b", 200, 300),
+			},
+			topics: []topicSummary{
+				{Topic: "/imu", MessageCount: 10, FileCount: 1, MissingFrom: []string{"b"}},
+			},
+		},
+		{
+			name:    "no files",
+			reports: nil,
+			topics:  []topicSummary{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := summarize(tt.reports)
+			assert.Equal(t, tt.topics, summary.Topics)
+		})
+	}
+}
@@ -0,0 +1,519 @@
+package merge
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/spf13/cobra"
+	"io"
+	"maps"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var writerOpt *mcap.WriterOptions
+
+var (
+	inputs           []string
+	output           string
+	prefixTopics     bool
+	compression      string
+	compressionLevel int
+)
+
+var MergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: fmt.Sprintf("Merge several (%s) files into a single time-ordered file", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Merge several (%s) files into a single file ordered by message log time.
+Identical schemas and channels are deduplicated, clashing IDs are remapped.`,
+		constants.MCAPFIleExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		writerOpt, err = utils.NewWriterOptions(compression, compressionLevel)
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasSuffix(output, constants.MCAPFIleExtension) {
+			return fmt.Errorf("output %s does not end with %s extension", output, constants.MCAPFIleExtension)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+func init() {
+	MergeCmd.
+		Flags().
+		StringSliceVarP(
+			&inputs,
+			"input",
+			"i",
+			nil,
+			fmt.Sprintf(
+				"Input (%s) files or directories contain (%s) file(s), repeat the flag to merge several inputs",
+				constants.MCAPFIleExtension,
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	MergeCmd.
+		Flags().
+		StringVarP(
+			&output,
+			"output",
+			"o",
+			"",
+			fmt.Sprintf(
+				"Output (%s) file to write the merged messages to",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	MergeCmd.
+		Flags().
+		BoolVarP(
+			&prefixTopics,
+			"prefix-topics",
+			"x",
+			false,
+			"Prefix every topic with the name of its source file (e.g. /run_a/imu)",
+		)
+
+	MergeCmd.
+		Flags().
+		StringVarP(
+			&compression,
+			"compression",
+			"c",
+			"",
+			fmt.Sprintf(
+				"Compression algorithm used to write (%s) files (zstd or lz4)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	MergeCmd.
+		Flags().
+		IntVarP(
+			&compressionLevel,
+			"compression-level",
+			"n",
+			0,
+			fmt.Sprintf(
+				"Compression level write (%s) files (0:default 1:fastest 2:better 3:best)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	_ = MergeCmd.MarkFlagRequired("input")
+	_ = MergeCmd.MarkFlagRequired("output")
+}
+
+func run() {
+	fileToProcess := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		isDir, err := utils.IsPathDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+
+		if !isDir {
+			if !strings.HasSuffix(input, constants.MCAPFIleExtension) {
+				logging.GetLogger().Info(fmt.Sprintf("Input %s does not end with %s extension", input, constants.MCAPFIleExtension))
+				os.Exit(1)
+			}
+			fileToProcess = append(fileToProcess, input)
+			continue
+		}
+
+		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, mcapFiles...)
+	}
+	logging.GetLogger().Info(fmt.Sprintf("Retrieved %d mcap files to merge", len(fileToProcess)))
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files to merge", constants.MCAPFIleExtension))
+		os.Exit(0)
+	}
+
+	for _, fPath := range fileToProcess {
+		isSame, err := utils.IsSameDirectory(fPath, output)
+		if err != nil {
+			logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+			os.Exit(1)
+		}
+		if isSame {
+			logging.GetLogger().Info(fmt.Sprintf("Cannot use input file %s as output file", fPath))
+			os.Exit(1)
+		}
+	}
+
+	outputDir := filepath.Dir(output)
+	if !utils.IsDirExists(outputDir) {
+		err := utils.CreateDir(outputDir)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	err := merge(fileToProcess, output)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	logging.GetLogger().Info(fmt.Sprintf("Merged %d files into %s", len(fileToProcess), output))
+	os.Exit(0)
+}
+
+// source is a single input file taking part in the merge, along with the
+// message it will yield next.
+type source struct {
+	idx      int
+	path     string
+	file     *os.File
+	reader   *mcap.Reader
+	info     *mcap.Info
+	msgs     mcap.MessageIterator
+	schema   *mcap.Schema
+	channel  *mcap.Channel
+	msg      *mcap.Message
+	channels map[uint16]uint16
+}
+
+func (s *source) next() error {
+	schema, channel, msg, err := s.msgs.NextInto(&mcap.Message{})
+	if err != nil {
+		if err == io.EOF {
+			s.msg = nil
+			return nil
+		}
+		return fmt.Errorf("failed to iterate messages of %s: %s", s.path, err)
+	}
+	s.schema, s.channel, s.msg = schema, channel, msg
+	return nil
+}
+
+// topicPrefix returns the topic prefix derived from the source file name.
+func (s *source) topicPrefix() string {
+	stem := strings.TrimSuffix(filepath.Base(s.path), filepath.Ext(s.path))
+	return "/" + strings.Trim(stem, "/")
+}
+
+// sourceHeap orders sources by the log time of their pending message, ties
+// are broken by input order so the merge is deterministic.
+type sourceHeap []*source
+
+func (h sourceHeap) Len() int { return len(h) }
+
+func (h sourceHeap) Less(i, j int) bool {
+	if h[i].msg.LogTime != h[j].msg.LogTime {
+		return h[i].msg.LogTime < h[j].msg.LogTime
+	}
+	return h[i].idx < h[j].idx
+}
+
+func (h sourceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *sourceHeap) Push(x any) { *h = append(*h, x.(*source)) }
+
+func (h *sourceHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+type schemaKey struct {
+	name     string
+	encoding string
+	data     string
+}
+
+type channelKey struct {
+	topic           string
+	schemaID        uint16
+	messageEncoding string
+	metadata        string
+}
+
+// merger assigns output schema and channel IDs, writing each distinct schema
+// and channel once.
+type merger struct {
+	writer   *mcap.Writer
+	schemas  map[schemaKey]uint16
+	channels map[channelKey]uint16
+}
+
+func (m *merger) schemaID(schema *mcap.Schema) (uint16, error) {
+	// Schema ID 0 means the channel has no schema.
+	if schema == nil {
+		return 0, nil
+	}
+
+	key := schemaKey{
+		name:     schema.Name,
+		encoding: schema.Encoding,
+		data:     string(schema.Data),
+	}
+	if id, ok := m.schemas[key]; ok {
+		return id, nil
+	}
+
+	if len(m.schemas) >= 0xFFFF {
+		return 0, fmt.Errorf("too many distinct schemas to merge")
+	}
+	id := uint16(len(m.schemas) + 1)
+	err := m.writer.WriteSchema(&mcap.Schema{
+		ID:       id,
+		Name:     schema.Name,
+		Encoding: schema.Encoding,
+		Data:     schema.Data,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write schema %s: %s", schema.Name, err)
+	}
+	m.schemas[key] = id
+	return id, nil
+}
+
+func (m *merger) channelID(s *source) (uint16, error) {
+	if id, ok := s.channels[s.channel.ID]; ok {
+		return id, nil
+	}
+
+	schemaID, err := m.schemaID(s.schema)
+	if err != nil {
+		return 0, err
+	}
+
+	topic := s.channel.Topic
+	if prefixTopics {
+		topic = s.topicPrefix() + "/" + strings.TrimPrefix(topic, "/")
+	}
+
+	key := channelKey{
+		topic:           topic,
+		schemaID:        schemaID,
+		messageEncoding: s.channel.MessageEncoding,
+		metadata:        joinMetadata(s.channel.Metadata),
+	}
+
+	id, ok := m.channels[key]
+	if !ok {
+		if len(m.channels) >= 0xFFFF {
+			return 0, fmt.Errorf("too many distinct channels to merge")
+		}
+		id = uint16(len(m.channels))
+		err = m.writer.WriteChannel(&mcap.Channel{
+			ID:              id,
+			SchemaID:        schemaID,
+			Topic:           topic,
+			MessageEncoding: s.channel.MessageEncoding,
+			Metadata:        s.channel.Metadata,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to write channel %s: %s", topic, err)
+		}
+		m.channels[key] = id
+	}
+
+	s.channels[s.channel.ID] = id
+	return id, nil
+}
+
+// joinMetadata returns a comparable form of a key-value map.
+func joinMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for _, k := range slices.Sorted(maps.Keys(metadata)) {
+		pairs = append(pairs, k+"="+metadata[k])
+	}
+	return strings.Join(pairs, "\x00")
+}
+
+// records skips the metadata records and attachments with the same name and
+// content as one already written from an earlier input.
+type records struct {
+	metadata    map[string]bool
+	attachments map[string]bool
+}
+
+func (r *records) metadataRecord(metadata *mcap.Metadata) (*mcap.Metadata, error) {
+	key := metadata.Name + "\x00" + joinMetadata(metadata.Metadata)
+	if r.metadata[key] {
+		return nil, nil
+	}
+	r.metadata[key] = true
+	return metadata, nil
+}
+
+// attachment reads the data of the attachment to compare it, the data is
+// held in memory until written.
+func (r *records) attachment(attachment *mcap.Attachment) (*mcap.Attachment, error) {
+	data, err := io.ReadAll(attachment.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %s", attachment.Name, err)
+	}
+	sum := sha256.Sum256(data)
+	key := attachment.Name + "\x00" + string(sum[:])
+	if r.attachments[key] {
+		return nil, nil
+	}
+	r.attachments[key] = true
+	attachment.Data = bytes.NewReader(data)
+	return attachment, nil
+}
+
+func merge(filePaths []string, outputPath string) (err error) {
+	sources := make([]*source, 0, len(filePaths))
+	defer func() {
+		for _, s := range sources {
+			s.reader.Close()
+			cErr := s.file.Close()
+			if cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+	}()
+
+	for idx, filePath := range filePaths {
+		inFile, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+
+		reader, err := mcap.NewReader(inFile)
+		if err != nil {
+			_ = inFile.Close()
+			return fmt.Errorf("failed to create new reader for %s: %s", filePath, err)
+		}
+
+		s := &source{
+			idx:      idx,
+			path:     filePath,
+			file:     inFile,
+			reader:   reader,
+			channels: map[uint16]uint16{},
+		}
+		sources = append(sources, s)
+
+		s.info, err = reader.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read mcap info of %s: %s", filePath, err)
+		}
+	}
+
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file %s: %s", outputPath, err)
+	}
+	defer func(outFile *os.File) {
+		cErr := outFile.Close()
+		if cErr != nil && err == nil {
+			err = cErr
+		}
+	}(outFile)
+
+	writer, err := mcap.NewWriter(outFile, writerOpt)
+	if err != nil {
+		return fmt.Errorf("failed to create new writer: %s", err)
+	}
+
+	// Keep the profile only if every input agrees on it.
+	header := &mcap.Header{
+		Profile: sources[0].reader.Header().Profile,
+	}
+	for _, s := range sources[1:] {
+		if s.reader.Header().Profile != header.Profile {
+			header.Profile = ""
+			break
+		}
+	}
+
+	err = writer.WriteHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write header: %s", err)
+	}
+
+	seen := &records{
+		metadata:    map[string]bool{},
+		attachments: map[string]bool{},
+	}
+	for _, s := range sources {
+		err = utils.CopyMetadata(s.reader, writer, s.info, seen.metadataRecord)
+		if err != nil {
+			return err
+		}
+
+		err = utils.CopyAttachments(s.reader, writer, s.info, seen.attachment)
+		if err != nil {
+			return err
+		}
+	}
+
+	h := make(sourceHeap, 0, len(sources))
+	for _, s := range sources {
+		s.msgs, err = s.reader.Messages(mcap.InOrder(mcap.LogTimeOrder))
+		if err != nil {
+			return fmt.Errorf("failed to read messages of %s: %s", s.path, err)
+		}
+
+		if err := s.next(); err != nil {
+			return err
+		}
+		if s.msg != nil {
+			h = append(h, s)
+		}
+	}
+	heap.Init(&h)
+
+	m := &merger{
+		writer:   writer,
+		schemas:  map[schemaKey]uint16{},
+		channels: map[channelKey]uint16{},
+	}
+
+	for h.Len() > 0 {
+		s := h[0]
+
+		channelID, err := m.channelID(s)
+		if err != nil {
+			return err
+		}
+
+		s.msg.ChannelID = channelID
+		if err := writer.WriteMessage(s.msg); err != nil {
+			return err
+		}
+
+		if err := s.next(); err != nil {
+			return err
+		}
+		if s.msg == nil {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+
+	return writer.Close()
+}
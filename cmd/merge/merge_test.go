@@ -0,0 +1,148 @@
+package merge
+
+import (
+	"bytes"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mcap-utility/internal/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type mergedMessage struct {
+	topic   string
+	logTime uint64
+	data    string
+}
+
+// writeInput writes an input with a /a channel and one message per log time,
+// and the given metadata and attachment.
+func writeInput(t *testing.T, path string, logTimes []uint64, metadata string, attachment string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	writer, err := mcap.NewWriter(f, &mcap.WriterOptions{Chunked: true, ChunkSize: 64})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 3, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 7, SchemaID: 3, Topic: "/a", MessageEncoding: "ros1"}))
+	for _, logTime := range logTimes {
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{
+			ChannelID: 7,
+			LogTime:   logTime,
+			Data:      []byte(filepath.Base(path)),
+		}))
+	}
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"id": metadata}}))
+	assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{
+		Name:      "calib.yaml",
+		MediaType: "text/yaml",
+		DataSize:  uint64(len(attachment)),
+		Data:      bytes.NewReader([]byte(attachment)),
+	}))
+	assert.NoError(t, writer.Close())
+}
+
+func readMerged(t *testing.T, path string) ([]mergedMessage, *mcap.Info) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	reader, err := mcap.NewReader(f)
+	assert.NoError(t, err)
+	defer reader.Close()
+	mcapInfo, err := reader.Info()
+	assert.NoError(t, err)
+
+	msgs, err := reader.Messages(mcap.InOrder(mcap.FileOrder))
+	assert.NoError(t, err)
+	var result []mergedMessage
+	for {
+		_, channel, msg, err := msgs.NextInto(nil)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		result = append(result, mergedMessage{channel.Topic, msg.LogTime, string(msg.Data)})
+	}
+	return result, mcapInfo
+}
+
+func setup(t *testing.T, prefix bool) string {
+	t.Helper()
+
+	var err error
+	writerOpt, err = utils.NewWriterOptions("", 0)
+	assert.NoError(t, err)
+	prefixTopics = prefix
+	t.Cleanup(func() { prefixTopics = false })
+
+	dir := t.TempDir()
+	writeInput(t, filepath.Join(dir, "run_a.mcap"), []uint64{10, 30, 50}, "1", "same")
+	writeInput(t, filepath.Join(dir, "run_b.mcap"), []uint64{20, 30, 40}, "1", "same")
+	writeInput(t, filepath.Join(dir, "run_c.mcap"), []uint64{5}, "2", "other")
+	return dir
+}
+
+func TestMerge(t *testing.T) {
+	dir := setup(t, false)
+	outputPath := filepath.Join(dir, "out", "merged.mcap")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(outputPath), 0o755))
+	assert.NoError(t, merge([]string{
+		filepath.Join(dir, "run_a.mcap"),
+		filepath.Join(dir, "run_b.mcap"),
+		filepath.Join(dir, "run_c.mcap"),
+	}, outputPath))
+
+	msgs, mcapInfo := readMerged(t, outputPath)
+	// Ties on log time keep the input order.
+	assert.Equal(t, []mergedMessage{
+		{"/a", 5, "run_c.mcap"},
+		{"/a", 10, "run_a.mcap"},
+		{"/a", 20, "run_b.mcap"},
+		{"/a", 30, "run_a.mcap"},
+		{"/a", 30, "run_b.mcap"},
+		{"/a", 40, "run_b.mcap"},
+		{"/a", 50, "run_a.mcap"},
+	}, msgs)
+
+	// The identical schemas and channels of the inputs are written once.
+	assert.Len(t, mcapInfo.Schemas, 1)
+	assert.Len(t, mcapInfo.Channels, 1)
+
+	// Metadata and attachments are written once per name and content.
+	assert.Len(t, mcapInfo.MetadataIndexes, 2)
+	assert.Len(t, mcapInfo.AttachmentIndexes, 2)
+}
+
+func TestMergePrefixTopics(t *testing.T) {
+	dir := setup(t, true)
+	outputPath := filepath.Join(dir, "out", "merged.mcap")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(outputPath), 0o755))
+	assert.NoError(t, merge([]string{
+		filepath.Join(dir, "run_a.mcap"),
+		filepath.Join(dir, "run_b.mcap"),
+	}, outputPath))
+
+	msgs, mcapInfo := readMerged(t, outputPath)
+	assert.Equal(t, []mergedMessage{
+		{"/run_a/a", 10, "run_a.mcap"},
+		{"/run_b/a", 20, "run_b.mcap"},
+		{"/run_a/a", 30, "run_a.mcap"},
+		{"/run_b/a", 30, "run_b.mcap"},
+		{"/run_b/a", 40, "run_b.mcap"},
+		{"/run_a/a", 50, "run_a.mcap"},
+	}, msgs)
+
+	// Channels are no longer identical once prefixed, but still share their
+	// schema.
+	assert.Len(t, mcapInfo.Schemas, 1)
+	assert.Len(t, mcapInfo.Channels, 2)
+}
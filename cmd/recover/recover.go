@@ -0,0 +1,220 @@
+package recover
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/spf13/cobra"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapedit"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var writerOpt *mcap.WriterOptions
+
+var (
+	input            string
+	output           string
+	compression      string
+	compressionLevel int
+)
+
+var RecoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: fmt.Sprintf("Salvage truncated or unindexed (%s) files", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Salvage truncated or unindexed (%s) files, e.g. recordings interrupted by a power loss.
+Records are scanned linearly from the start of each file without using its summary section. Every complete chunk and
+record that can be decoded is written to a new, fully indexed file, and the scan stops at the first truncated or
+corrupt record. A report of what was kept and lost is printed for each file.`,
+		constants.MCAPFIleExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		writerOpt, err = utils.NewWriterOptions(compression, compressionLevel)
+		return err
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+func init() {
+	RecoverCmd.
+		Flags().
+		StringVarP(
+			&input,
+			"input",
+			"i",
+			"",
+			fmt.Sprintf(
+				"Input (%s) files or directory contains (%s) file(s)",
+				constants.MCAPFIleExtension,
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	RecoverCmd.
+		Flags().
+		StringVarP(
+			&output,
+			"output",
+			"o",
+			"",
+			fmt.Sprintf(
+				"Output directory to save the recovered (%s) files",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	RecoverCmd.
+		Flags().
+		StringVarP(
+			&compression,
+			"compression",
+			"c",
+			"",
+			fmt.Sprintf(
+				"Compression algorithm used to write (%s) files (zstd or lz4)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	RecoverCmd.
+		Flags().
+		IntVarP(
+			&compressionLevel,
+			"compression-level",
+			"n",
+			0,
+			fmt.Sprintf(
+				"Compression level write (%s) files (0:default 1:fastest 2:better 3:best)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	_ = RecoverCmd.MarkFlagRequired("input")
+	_ = RecoverCmd.MarkFlagRequired("output")
+}
+
+func run() {
+	isDir, err := utils.IsPathDirectory(input)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	fileToProcess := make([]string, 0, 10)
+
+	if !isDir {
+		if !strings.HasSuffix(input, constants.MCAPFIleExtension) {
+			logging.GetLogger().Info(fmt.Sprintf("Input %s does not end with %s extension", input, constants.MCAPFIleExtension))
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, input)
+	} else {
+		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, mcapFiles...)
+	}
+	logging.GetLogger().Info(fmt.Sprintf("Retrieved %d mcap files to recover", len(fileToProcess)))
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files to recover", constants.MCAPFIleExtension))
+		os.Exit(0)
+	}
+
+	isSameDir, err := utils.IsSameDirectory(input, output)
+	if err != nil {
+		logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+		os.Exit(1)
+	}
+
+	if isSameDir {
+		logging.GetLogger().Info("Cannot use input directory as output directory")
+		os.Exit(1)
+	}
+
+	if !utils.IsDirExists(output) {
+		err := utils.CreateDir(output)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		logging.GetLogger().Info("Output directory created")
+	}
+
+	failed := 0
+	for _, fPath := range fileToProcess {
+		logging.GetLogger().Info(fmt.Sprintf("Recovering %s", fPath))
+		if err := recoverFile(fPath); err != nil {
+			logging.GetLogger().Error(err.Error())
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		logging.GetLogger().Error(fmt.Sprintf("Nothing could be recovered from %d of %d files", failed, len(fileToProcess)))
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func recoverFile(filePath string) error {
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer mcapFile.Close()
+
+	stat, err := mcapFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	outputPath := filepath.Join(output, filepath.Base(filePath))
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file %s: %s", outputPath, err)
+	}
+
+	report, err := mcapedit.Recover(context.Background(), bufio.NewReaderSize(mcapFile, 1<<20), outFile, writerOpt)
+	if cErr := outFile.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return fmt.Errorf("failed to recover %s: %s", filePath, err)
+	}
+
+	printReport(filePath, outputPath, stat.Size(), report)
+	return nil
+}
+
+func printReport(filePath string, outputPath string, size int64, report *mcapedit.RecoverReport) {
+	fmt.Printf("%s -> %s\n", filePath, outputPath)
+	if report.Complete {
+		fmt.Println("  Data section read in full, summary section rebuilt")
+	} else {
+		fmt.Printf("  Stopped: %s\n", report.Reason)
+		fmt.Printf("  Recovered: %d of %d bytes, lost: %d bytes\n", report.Recovered, size, size-report.Recovered)
+	}
+	fmt.Printf("  Kept: %d messages, %d chunks, %d schemas, %d channels, %d attachments, %d metadata\n",
+		report.Messages, report.Chunks, report.Schemas, report.Channels, report.Attachments, report.Metadata)
+	if report.Messages > 0 {
+		fmt.Printf("  Message time range: %d - %d (%s)\n", report.MessageStartTime, report.MessageEndTime,
+			time.Duration(report.MessageEndTime-report.MessageStartTime))
+	}
+	if report.SkippedMessages > 0 {
+		fmt.Printf("  Skipped: %d messages on unknown channels\n", report.SkippedMessages)
+	}
+}
@@ -3,8 +3,15 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"mcap-utility/cmd/cat"
+	"mcap-utility/cmd/convert"
 	"mcap-utility/cmd/edit"
+	"mcap-utility/cmd/export"
 	"mcap-utility/cmd/info"
+	"mcap-utility/cmd/merge"
+	"mcap-utility/cmd/recover"
+	"mcap-utility/cmd/split"
+	"mcap-utility/cmd/verify"
 	"mcap-utility/internal/constants"
 )
 
@@ -31,4 +38,11 @@ func Execute() error {
 func init() {
 	rootCmd.AddCommand(info.InfoCmd)
 	rootCmd.AddCommand(edit.EditCmd)
+	rootCmd.AddCommand(merge.MergeCmd)
+	rootCmd.AddCommand(split.SplitCmd)
+	rootCmd.AddCommand(verify.VerifyCmd)
+	rootCmd.AddCommand(recover.RecoverCmd)
+	rootCmd.AddCommand(cat.CatCmd)
+	rootCmd.AddCommand(export.ExportCmd)
+	rootCmd.AddCommand(convert.ConvertCmd)
 }
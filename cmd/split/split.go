@@ -0,0 +1,507 @@
+package split
+
+import (
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/spf13/cobra"
+	"io"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var writerOpt *mcap.WriterOptions
+
+var (
+	input            string
+	output           string
+	every            time.Duration
+	size             string
+	count            uint64
+	compression      string
+	compressionLevel int
+)
+
+var maxSize int64
+
+// Upper bounds of the sizes of the records a piece gets besides the ones
+// accounted for as they are written, used to start a new piece before the
+// size limit is crossed.
+const (
+	recordHeaderSize = 9
+	// chunkOverhead bounds the chunk record and compression framing around
+	// the records of a chunk, and its chunk index in the summary.
+	chunkOverhead = 256
+	// chunkChannelOverhead bounds the message index record and chunk index
+	// entry of each channel of a chunk.
+	chunkChannelOverhead = 32
+	// summaryOverhead bounds the data end, statistics, summary offset and
+	// footer records.
+	summaryOverhead = 512
+	// statisticsChannelSize is the size of the message count of a channel
+	// in the statistics record.
+	statisticsChannelSize = 10
+	// messageIndexEntrySize is the size of the entry of a message in the
+	// message index of its chunk.
+	messageIndexEntrySize = 16
+)
+
+var SplitCmd = &cobra.Command{
+	Use:   "split",
+	Short: fmt.Sprintf("Split (%s) files by duration, size or message count", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Split (%s) files into name_0000%s, name_0001%s, ... pieces.
+A new piece is started as soon as any of the given duration, size or message count limits is reached.`,
+		constants.MCAPFIleExtension,
+		constants.MCAPFIleExtension,
+		constants.MCAPFIleExtension,
+	),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		writerOpt, err = utils.NewWriterOptions(compression, compressionLevel)
+		if err != nil {
+			return err
+		}
+
+		if every < 0 {
+			return fmt.Errorf("invalid duration: %s", every)
+		}
+
+		if size != "" {
+			maxSize, err = utils.ParseByteSize(size)
+			if err != nil {
+				return err
+			}
+			// The chunk being written is accounted for uncompressed, keep
+			// chunks small so pieces are not cut much below the size limit.
+			if chunkSize := maxSize / 8; chunkSize < 1024*1024 {
+				writerOpt.ChunkSize = max(chunkSize, 4*1024)
+			}
+		}
+
+		if every == 0 && maxSize == 0 && count == 0 {
+			return fmt.Errorf("at least one of --every, --size or --count is required")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+func init() {
+	SplitCmd.
+		Flags().
+		StringVarP(
+			&input,
+			"input",
+			"i",
+			"",
+			fmt.Sprintf(
+				"Input (%s) files or directory contains (%s) file(s)",
+				constants.MCAPFIleExtension,
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	SplitCmd.
+		Flags().
+		StringVarP(
+			&output,
+			"output",
+			"o",
+			"",
+			fmt.Sprintf(
+				"Output directory to save the split (%s) files, mirroring the subdirectories of the input directory",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	SplitCmd.
+		Flags().
+		DurationVar(
+			&every,
+			"every",
+			0,
+			"Maximum log time span of each piece (e.g. 60s, 10m)",
+		)
+
+	SplitCmd.
+		Flags().
+		StringVar(
+			&size,
+			"size",
+			"",
+			"Maximum size of each piece (e.g. 500MB, 2GiB), a piece gets a single message larger than the limit",
+		)
+
+	SplitCmd.
+		Flags().
+		Uint64Var(
+			&count,
+			"count",
+			0,
+			"Maximum number of messages in each piece",
+		)
+
+	SplitCmd.
+		Flags().
+		StringVarP(
+			&compression,
+			"compression",
+			"c",
+			"",
+			fmt.Sprintf(
+				"Compression algorithm used to write (%s) files (zstd or lz4)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	SplitCmd.
+		Flags().
+		IntVarP(
+			&compressionLevel,
+			"compression-level",
+			"n",
+			0,
+			fmt.Sprintf(
+				"Compression level write (%s) files (0:default 1:fastest 2:better 3:best)",
+				constants.MCAPFIleExtension,
+			),
+		)
+
+	_ = SplitCmd.MarkFlagRequired("input")
+	_ = SplitCmd.MarkFlagRequired("output")
+}
+
+func run() {
+	isDir, err := utils.IsPathDirectory(input)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	fileToProcess := make([]string, 0, 10)
+
+	if !isDir {
+		if !strings.HasSuffix(input, constants.MCAPFIleExtension) {
+			logging.GetLogger().Info(fmt.Sprintf("Input %s does not end with %s extension", input, constants.MCAPFIleExtension))
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, input)
+	} else {
+		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, mcapFiles...)
+	}
+	logging.GetLogger().Info(fmt.Sprintf("Retrieved %d mcap files to split", len(fileToProcess)))
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files to split", constants.MCAPFIleExtension))
+		os.Exit(0)
+	}
+
+	isSameDir, err := utils.IsSameDirectory(input, output)
+	if err != nil {
+		logging.GetLogger().Error(fmt.Sprintf("Unable to determine current directory: %s", err))
+		os.Exit(1)
+	}
+
+	if isSameDir {
+		logging.GetLogger().Info("Cannot use input directory as output directory")
+		os.Exit(1)
+	}
+
+	root := input
+	if !isDir {
+		root = filepath.Dir(input)
+	}
+	// The pieces of every file are named after its output path, mirroring
+	// the input directory, which are checked for collisions before any file
+	// is written.
+	outputPaths, err := utils.OutputPaths(root, fileToProcess, output, utils.DefaultNameTemplate)
+	if err != nil {
+		logging.GetLogger().Error(err.Error())
+		os.Exit(1)
+	}
+
+	if !utils.IsDirExists(output) {
+		err := utils.CreateDir(output)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		logging.GetLogger().Info("Output directory created")
+	}
+
+	for _, fPath := range fileToProcess {
+		logging.GetLogger().Info(fmt.Sprintf("Splitting %s", fPath))
+		pieces, err := split(fPath, outputPaths[fPath])
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		logging.GetLogger().Info(fmt.Sprintf("Split %s into %d pieces", fPath, pieces))
+	}
+
+	os.Exit(0)
+}
+
+// piece is a single output file of a split.
+type piece struct {
+	path         string
+	file         *os.File
+	writer       *mcap.Writer
+	schemas      map[uint16]bool
+	channels     map[uint16]bool
+	messageCount uint64
+
+	// pending bounds the size of the records of the chunk being written,
+	// which started at offset flushed, and summary the size of the summary
+	// section.
+	pending int64
+	flushed uint64
+	summary int64
+}
+
+// newPiece creates a piece with the header and metadata records of the
+// input, and its attachments if attachments is set.
+func newPiece(outputPath string, header *mcap.Header, reader *mcap.Reader, mcapInfo *mcap.Info, attachments bool) (*piece, error) {
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file %s: %s", outputPath, err)
+	}
+
+	writer, err := mcap.NewWriter(outFile, writerOpt)
+	if err != nil {
+		_ = outFile.Close()
+		return nil, fmt.Errorf("failed to create new writer: %s", err)
+	}
+
+	p := &piece{
+		path:     outputPath,
+		file:     outFile,
+		writer:   writer,
+		schemas:  map[uint16]bool{},
+		channels: map[uint16]bool{},
+	}
+
+	if err := writer.WriteHeader(header); err != nil {
+		_ = p.close()
+		return nil, fmt.Errorf("failed to write header: %s", err)
+	}
+
+	if err := utils.CopyMetadata(reader, writer, mcapInfo, nil); err != nil {
+		_ = p.close()
+		return nil, err
+	}
+	for _, idx := range mcapInfo.MetadataIndexes {
+		p.summary += recordHeaderSize + 8 + 8 + 4 + int64(len(idx.Name))
+	}
+
+	if attachments {
+		if err := utils.CopyAttachments(reader, writer, mcapInfo, nil); err != nil {
+			_ = p.close()
+			return nil, err
+		}
+		for _, idx := range mcapInfo.AttachmentIndexes {
+			p.summary += recordHeaderSize + 5*8 + 4 + int64(len(idx.Name)) + 4 + int64(len(idx.MediaType))
+		}
+	}
+
+	p.flushed = writer.Offset()
+	return p, nil
+}
+
+// recordsSize returns the size of the records written to the chunk for msg,
+// and the size they add to the summary.
+func (p *piece) recordsSize(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (int64, int64) {
+	var records, summary int64
+	if schema != nil && !p.schemas[schema.ID] {
+		size := recordHeaderSize + 2 + 4 + int64(len(schema.Name)) + 4 + int64(len(schema.Encoding)) + 4 + int64(len(schema.Data))
+		// Schemas and channels are repeated in the summary.
+		records += size
+		summary += size
+	}
+	if !p.channels[channel.ID] {
+		size := recordHeaderSize + 2 + 2 + 4 + int64(len(channel.Topic)) + 4 + int64(len(channel.MessageEncoding)) + 4
+		for k, v := range channel.Metadata {
+			size += 4 + int64(len(k)) + 4 + int64(len(v))
+		}
+		records += size
+		summary += size + statisticsChannelSize
+	}
+	records += recordHeaderSize + 2 + 4 + 8 + 8 + int64(len(msg.Data)) + messageIndexEntrySize
+	return records, summary
+}
+
+// size returns an upper bound of the size of the piece once closed, with
+// records more bytes written to its chunk and summary more to its summary.
+func (p *piece) size(records int64, summary int64) int64 {
+	channels := int64(len(p.channels) + 1)
+	return int64(p.writer.Offset()) + p.pending + records + chunkOverhead + chunkChannelOverhead*channels +
+		p.summary + summary + summaryOverhead
+}
+
+func (p *piece) write(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) error {
+	records, summary := p.recordsSize(schema, channel, msg)
+
+	if schema != nil && !p.schemas[schema.ID] {
+		if err := p.writer.WriteSchema(schema); err != nil {
+			return fmt.Errorf("write schema: %w", err)
+		}
+		p.schemas[schema.ID] = true
+	}
+
+	if !p.channels[channel.ID] {
+		if err := p.writer.WriteChannel(channel); err != nil {
+			return fmt.Errorf("write channel: %w", err)
+		}
+		p.channels[channel.ID] = true
+	}
+
+	p.messageCount++
+	if err := p.writer.WriteMessage(msg); err != nil {
+		return err
+	}
+
+	p.summary += summary
+	if offset := p.writer.Offset(); offset != p.flushed {
+		// The chunk was flushed, its chunk index is left to write.
+		p.flushed = offset
+		p.pending = 0
+		p.summary += chunkOverhead + chunkChannelOverhead*int64(len(p.channels))
+	} else {
+		p.pending += records
+	}
+	return nil
+}
+
+// full reports whether msg must go to a new piece. A piece always gets at
+// least one message, even if it alone crosses the size limit.
+func (p *piece) full(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) bool {
+	if count > 0 && p.messageCount >= count {
+		return true
+	}
+	if maxSize == 0 || p.messageCount == 0 {
+		return false
+	}
+	return p.size(p.recordsSize(schema, channel, msg)) > maxSize
+}
+
+func (p *piece) close() error {
+	err := p.writer.Close()
+	cErr := p.file.Close()
+	if err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// split cuts filePath into pieces named after outputPath, with a piece number
+// appended to its stem.
+func split(filePath string, outputPath string) (pieceCount int, err error) {
+	inFile, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer func(inFile *os.File) {
+		cErr := inFile.Close()
+		if cErr != nil && err == nil {
+			err = cErr
+		}
+	}(inFile)
+
+	reader, err := mcap.NewReader(inFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create new reader for %s: %s", filePath, err)
+	}
+	defer reader.Close()
+
+	mcapInfo, err := reader.Info()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read mcap info: %s", err)
+	}
+
+	stem := strings.TrimSuffix(outputPath, constants.MCAPFIleExtension)
+	if err := utils.CreateDir(filepath.Dir(outputPath)); err != nil {
+		return 0, fmt.Errorf("failed to create output directory of %s: %s", outputPath, err)
+	}
+
+	var current *piece
+	defer func() {
+		if current != nil {
+			cErr := current.close()
+			if cErr != nil && err == nil {
+				err = cErr
+			}
+		}
+	}()
+
+	openPiece := func() error {
+		piecePath := fmt.Sprintf("%s_%04d%s", stem, pieceCount, constants.MCAPFIleExtension)
+		// Attachments are only written once, to the first piece.
+		p, err := newPiece(piecePath, reader.Header(), reader, mcapInfo, pieceCount == 0)
+		if err != nil {
+			return err
+		}
+
+		current = p
+		pieceCount++
+		return nil
+	}
+
+	msgs, err := reader.Messages(mcap.InOrder(mcap.LogTimeOrder))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read messages: %s", err)
+	}
+
+	var windowStart, windowEnd uint64
+	for {
+		schema, channel, msg, err := msgs.NextInto(&mcap.Message{})
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return pieceCount, fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		rotate := current == nil || current.full(schema, channel, msg)
+		if every > 0 {
+			if current == nil {
+				windowStart = msg.LogTime
+				windowEnd = windowStart + uint64(every)
+			} else if msg.LogTime >= windowEnd {
+				// Keep windows aligned to the first message, skipping empty ones.
+				windowEnd += (msg.LogTime-windowEnd)/uint64(every)*uint64(every) + uint64(every)
+				rotate = true
+			}
+		}
+
+		if rotate {
+			if current != nil {
+				err = current.close()
+				current = nil
+				if err != nil {
+					return pieceCount, err
+				}
+			}
+			if err := openPiece(); err != nil {
+				return pieceCount, err
+			}
+		}
+
+		if err := current.write(schema, channel, msg); err != nil {
+			return pieceCount, err
+		}
+	}
+
+	return pieceCount, nil
+}
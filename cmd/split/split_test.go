@@ -0,0 +1,175 @@
+package split
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"mcap-utility/internal/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeInput writes 100 messages on /a and /b, one every 100ms, with random
+// payloads so the size of the pieces does not depend on compression.
+func writeInput(t *testing.T, path string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	writer, err := mcap.NewWriter(f, &mcap.WriterOptions{Chunked: true, ChunkSize: 4096, Compression: mcap.CompressionZSTD})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 1, Topic: "/b", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"k": "v"}}))
+	assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{
+		Name:      "calib.yaml",
+		MediaType: "text/yaml",
+		DataSize:  3,
+		Data:      bytes.NewReader([]byte("abc")),
+	}))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := uint64(0); i < 100; i++ {
+		data := make([]byte, 1000)
+		rng.Read(data)
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{
+			ChannelID: uint16(1 + i%2),
+			LogTime:   1e9 + i*uint64(100*time.Millisecond),
+			Data:      data,
+		}))
+	}
+	assert.NoError(t, writer.Close())
+}
+
+type splitPiece struct {
+	messages    int
+	start, end  uint64
+	metadata    int
+	attachments int
+	size        int64
+}
+
+func readPieces(t *testing.T, stem string, pieceCount int) []splitPiece {
+	t.Helper()
+
+	pieces := make([]splitPiece, 0, pieceCount)
+	for i := range pieceCount {
+		f, err := os.Open(fmt.Sprintf("%s_%04d.mcap", stem, i))
+		assert.NoError(t, err)
+		stat, err := f.Stat()
+		assert.NoError(t, err)
+
+		reader, err := mcap.NewReader(f)
+		assert.NoError(t, err)
+		mcapInfo, err := reader.Info()
+		assert.NoError(t, err)
+		pieces = append(pieces, splitPiece{
+			messages:    int(mcapInfo.Statistics.MessageCount),
+			start:       mcapInfo.Statistics.MessageStartTime,
+			end:         mcapInfo.Statistics.MessageEndTime,
+			metadata:    len(mcapInfo.MetadataIndexes),
+			attachments: len(mcapInfo.AttachmentIndexes),
+			size:        stat.Size(),
+		})
+
+		// Every piece is readable on its own.
+		msgs, err := reader.Messages()
+		assert.NoError(t, err)
+		for {
+			_, _, _, err := msgs.NextInto(nil)
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+		}
+		reader.Close()
+		assert.NoError(t, f.Close())
+	}
+	_, err := os.Stat(fmt.Sprintf("%s_%04d.mcap", stem, pieceCount))
+	assert.True(t, os.IsNotExist(err))
+	return pieces
+}
+
+func setup(t *testing.T, splitEvery time.Duration, splitSize int64, splitCount uint64) (string, string) {
+	t.Helper()
+
+	var err error
+	writerOpt, err = utils.NewWriterOptions("", 0)
+	assert.NoError(t, err)
+	writerOpt.ChunkSize = 4096
+	every, maxSize, count = splitEvery, splitSize, splitCount
+	t.Cleanup(func() { every, maxSize, count = 0, 0, 0 })
+
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "in", "run.mcap")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(inputPath), 0o755))
+	writeInput(t, inputPath)
+	return inputPath, filepath.Join(dir, "out", "sub", "run.mcap")
+}
+
+func TestSplitEvery(t *testing.T) {
+	inputPath, outputPath := setup(t, 2*time.Second, 0, 0)
+	pieceCount, err := split(inputPath, outputPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, pieceCount)
+
+	pieces := readPieces(t, filepath.Join(filepath.Dir(outputPath), "run"), pieceCount)
+	for i, p := range pieces {
+		assert.Equal(t, 20, p.messages)
+		assert.Equal(t, uint64(1e9+i*2e9), p.start)
+		assert.Equal(t, uint64(1e9+i*2e9+19e8), p.end)
+		assert.Equal(t, 1, p.metadata)
+	}
+
+	// Attachments are only written to the first piece.
+	assert.Equal(t, 1, pieces[0].attachments)
+	for _, p := range pieces[1:] {
+		assert.Equal(t, 0, p.attachments)
+	}
+}
+
+func TestSplitCount(t *testing.T) {
+	inputPath, outputPath := setup(t, 0, 0, 30)
+	pieceCount, err := split(inputPath, outputPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, pieceCount)
+
+	var messages []int
+	for _, p := range readPieces(t, filepath.Join(filepath.Dir(outputPath), "run"), pieceCount) {
+		messages = append(messages, p.messages)
+	}
+	assert.Equal(t, []int{30, 30, 30, 10}, messages)
+}
+
+func TestSplitSize(t *testing.T) {
+	const limit = 20_000
+	inputPath, outputPath := setup(t, 0, limit, 0)
+	pieceCount, err := split(inputPath, outputPath)
+	assert.NoError(t, err)
+
+	var messages int
+	pieces := readPieces(t, filepath.Join(filepath.Dir(outputPath), "run"), pieceCount)
+	for _, p := range pieces {
+		assert.LessOrEqual(t, p.size, int64(limit))
+		messages += p.messages
+	}
+	assert.Equal(t, 100, messages)
+	// Pieces are not cut much below the limit either.
+	assert.Greater(t, pieces[0].size, int64(limit*3/4))
+}
+
+func TestSplitSizeSmallerThanMessage(t *testing.T) {
+	inputPath, outputPath := setup(t, 0, 100, 0)
+	pieceCount, err := split(inputPath, outputPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, pieceCount)
+}
@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"mcap-utility/internal/constants"
+	"mcap-utility/internal/logging"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapverify"
+	"os"
+	"runtime"
+	"sync"
+)
+
+var VerifyCmd = &cobra.Command{
+	Use:   "verify <file|dir>...",
+	Short: fmt.Sprintf("Check the integrity of (%s) files", constants.MCAPFIleExtension),
+	Long: fmt.Sprintf(
+		`Check the integrity of (%s) files or of every file in directories.
+Magic bytes, data section, chunk, attachment and summary CRCs, message indexes, summary indexes and statistics are
+validated. Every failing record is listed and the command exits with status 1 if any file fails.`,
+		constants.MCAPFIleExtension,
+	),
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		run(args)
+	},
+}
+
+type verifyResult struct {
+	path   string
+	result *mcapverify.Result
+	err    error
+}
+
+func run(inputs []string) {
+	fileToProcess := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		isDir, err := utils.IsPathDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		if !isDir {
+			fileToProcess = append(fileToProcess, input)
+			continue
+		}
+		mcapFiles, err := utils.ListMCAPFilesInDirectory(input)
+		if err != nil {
+			logging.GetLogger().Error(err.Error())
+			os.Exit(1)
+		}
+		fileToProcess = append(fileToProcess, mcapFiles...)
+	}
+
+	if len(fileToProcess) == 0 {
+		logging.GetLogger().Info(fmt.Sprintf("No (%s) files to verify", constants.MCAPFIleExtension))
+		os.Exit(0)
+	}
+
+	results := verifyFiles(fileToProcess)
+
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %s\n", r.path, r.err)
+		case !r.result.OK():
+			failed++
+			fmt.Printf("FAIL %s\n", r.path)
+			for _, problem := range r.result.Problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			if r.result.Omitted > 0 {
+				fmt.Printf("  ... and %d more problems\n", r.result.Omitted)
+			}
+		default:
+			fmt.Printf("OK   %s\n", r.path)
+		}
+	}
+
+	if failed > 0 {
+		logging.GetLogger().Error(fmt.Sprintf("%d of %d files failed verification", failed, len(results)))
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// verifyFiles verifies files on all CPU cores and returns the results in
+// the order of files.
+func verifyFiles(files []string) []verifyResult {
+	results := make([]verifyResult, len(files))
+
+	sem := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, filePath := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = verifyFile(filePath)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func verifyFile(filePath string) verifyResult {
+	result := verifyResult{path: filePath}
+
+	mcapFile, err := os.Open(filePath)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer mcapFile.Close()
+
+	result.result, result.err = mcapverify.Verify(context.Background(), mcapFile)
+	return result
+}
@@ -2,4 +2,5 @@ package constants
 
 const (
 	MCAPFIleExtension = ".mcap"
+	BagFileExtension  = ".bag"
 )
@@ -1,19 +1,44 @@
 package logging
 
 import (
+	"io"
 	"log/slog"
 	"os"
+	"sync"
 )
 
 var (
 	logger *slog.Logger
+	output = &switchWriter{w: os.Stderr}
 )
 
 func GetLogger() *slog.Logger {
 	return logger
 }
 
+// SetOutput redirects the logs of all loggers, including the ones already
+// retrieved, to w and returns the previous output.
+func SetOutput(w io.Writer) io.Writer {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	previous := output.w
+	output.w = w
+	return previous
+}
+
+// switchWriter writes to a writer that can be changed while logging.
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
+
 func init() {
-	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: false}))
+	logger = slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{AddSource: false}))
 	slog.SetDefault(logger)
 }
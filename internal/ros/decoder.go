@@ -0,0 +1,150 @@
+package ros
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/foxglove/mcap/go/mcap"
+)
+
+const (
+	SchemaEncodingROS1 = "ros1msg"
+	SchemaEncodingROS2 = "ros2msg"
+
+	MessageEncodingROS1 = "ros1"
+	MessageEncodingCDR  = "cdr"
+
+	cdrEncapsulationSize = 4
+)
+
+// Decoder decodes ROS 1 or CDR (ROS 2) serialized messages of a single schema.
+type Decoder struct {
+	defs *Definitions
+	cdr  bool
+}
+
+// NewDecoder builds a decoder for the given schema and channel message encoding.
+func NewDecoder(schema *mcap.Schema, messageEncoding string) (*Decoder, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("missing schema")
+	}
+
+	var cdr bool
+	switch {
+	case schema.Encoding == SchemaEncodingROS1 && messageEncoding == MessageEncodingROS1:
+		cdr = false
+	case schema.Encoding == SchemaEncodingROS2 && messageEncoding == MessageEncodingCDR:
+		cdr = true
+	default:
+		return nil, fmt.Errorf("unsupported schema encoding %s with message encoding %s", schema.Encoding, messageEncoding)
+	}
+
+	defs, err := ParseDefinitions(schema.Name, string(schema.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %s", schema.Name, err)
+	}
+
+	return &Decoder{
+		defs: defs,
+		cdr:  cdr,
+	}, nil
+}
+
+// Definitions returns the parsed schema definitions used by the decoder.
+func (d *Decoder) Definitions() *Definitions {
+	return d.defs
+}
+
+// HasHeader reports whether the root message has a top-level std_msgs/Header.
+func (d *Decoder) HasHeader() bool {
+	return d.defs.HeaderField() >= 0
+}
+
+// Decode decodes a serialized message into a map keyed by field name.
+func (d *Decoder) Decode(data []byte) (map[string]any, error) {
+	msg, err := d.DecodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Map(), nil
+}
+
+// DecodeMessage decodes a serialized message, keeping the field order of its
+// definition.
+func (d *Decoder) DecodeMessage(data []byte) (Message, error) {
+	r, err := d.newReader(data)
+	if err != nil {
+		return nil, err
+	}
+	return r.message(d.defs.Root)
+}
+
+// SetHeaderStamp overwrites header.stamp of the message in place with the
+// given time in nanoseconds. It reports false if the message has no header.
+func (d *Decoder) SetHeaderStamp(data []byte, nanos uint64) (bool, error) {
+	headerIdx := d.defs.HeaderField()
+	if headerIdx < 0 {
+		return false, nil
+	}
+
+	r, err := d.newReader(data)
+	if err != nil {
+		return false, err
+	}
+
+	for _, field := range d.defs.Root.Fields[:headerIdx] {
+		if _, err := r.field(field); err != nil {
+			return false, err
+		}
+	}
+
+	// ROS 1 headers start with a uint32 seq, ROS 2 headers start with the stamp.
+	if !d.cdr {
+		r.pos += 4
+	} else {
+		r.align(4)
+	}
+	if r.pos+8 > len(r.data) {
+		return false, fmt.Errorf("message too short to contain a header stamp")
+	}
+
+	sec := nanos / uint64(1e9)
+	nsec := nanos % uint64(1e9)
+	if sec > math.MaxUint32 || (d.cdr && sec > math.MaxInt32) {
+		return false, fmt.Errorf("timestamp %d does not fit in a ROS time", nanos)
+	}
+
+	r.order.PutUint32(r.data[r.pos:], uint32(sec))
+	r.order.PutUint32(r.data[r.pos+4:], uint32(nsec))
+
+	return true, nil
+}
+
+func (d *Decoder) newReader(data []byte) (*reader, error) {
+	if !d.cdr {
+		return &reader{
+			data:  data,
+			order: binary.LittleEndian,
+			types: d.defs.Types,
+		}, nil
+	}
+
+	if len(data) < cdrEncapsulationSize {
+		return nil, fmt.Errorf("%w: no CDR encapsulation header", ErrShortMessage)
+	}
+
+	r := &reader{
+		data:   data,
+		pos:    cdrEncapsulationSize,
+		origin: cdrEncapsulationSize,
+		cdr:    true,
+		order:  binary.LittleEndian,
+		types:  d.defs.Types,
+	}
+	// Representation identifiers: 0x0000 CDR_BE, 0x0001 CDR_LE.
+	if data[1]&0x01 == 0 {
+		r.order = binary.BigEndian
+	}
+	return r, nil
+}
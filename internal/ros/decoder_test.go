@@ -0,0 +1,141 @@
+package ros
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+)
+
+const poseStampedDefinition = `std_msgs/Header header
+Pose pose
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+================================================================================
+MSG: geometry_msgs/Pose
+float64 x
+float64 y
+`
+
+const ros2PoseStampedDefinition = `std_msgs/Header header
+float64 x
+================================================================================
+MSG: std_msgs/Header
+builtin_interfaces/Time stamp
+string frame_id
+================================================================================
+MSG: builtin_interfaces/Time
+int32 sec
+uint32 nanosec
+`
+
+func TestROS1SetHeaderStamp(t *testing.T) {
+	schema := &mcap.Schema{
+		Name:     "geometry_msgs/PoseStamped",
+		Encoding: SchemaEncodingROS1,
+		Data:     []byte(poseStampedDefinition),
+	}
+	decoder, err := NewDecoder(schema, MessageEncodingROS1)
+	assert.NoError(t, err)
+	assert.True(t, decoder.HasHeader())
+
+	data := binary.LittleEndian.AppendUint32(nil, 7)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 3)
+	data = append(data, "map"...)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+
+	ok, err := decoder.SetHeaderStamp(data, 5_000_000_123)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	decoded, err := decoder.Decode(data)
+	assert.NoError(t, err)
+	header := decoded["header"].(map[string]any)
+	assert.Equal(t, uint32(7), header["seq"])
+	assert.Equal(t, map[string]any{"sec": uint32(5), "nsec": uint32(123)}, header["stamp"])
+	assert.Equal(t, "map", header["frame_id"])
+}
+
+func TestCDRSetHeaderStamp(t *testing.T) {
+	schema := &mcap.Schema{
+		Name:     "geometry_msgs/msg/PoseStamped",
+		Encoding: SchemaEncodingROS2,
+		Data:     []byte(ros2PoseStampedDefinition),
+	}
+	decoder, err := NewDecoder(schema, MessageEncodingCDR)
+	assert.NoError(t, err)
+
+	data := []byte{0x00, 0x01, 0x00, 0x00}
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = append(data, 'a', 0, 0, 0)
+	data = binary.LittleEndian.AppendUint64(data, 0)
+
+	ok, err := decoder.SetHeaderStamp(data, 3_000_000_004)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	decoded, err := decoder.Decode(data)
+	assert.NoError(t, err)
+	header := decoded["header"].(map[string]any)
+	assert.Equal(t, map[string]any{"sec": int32(3), "nanosec": uint32(4)}, header["stamp"])
+	assert.Equal(t, "a", header["frame_id"])
+	assert.Equal(t, float64(0), decoded["x"])
+}
+
+func TestSetHeaderStampWithoutHeader(t *testing.T) {
+	schema := &mcap.Schema{
+		Name:     "std_msgs/String",
+		Encoding: SchemaEncodingROS1,
+		Data:     []byte("string data\n"),
+	}
+	decoder, err := NewDecoder(schema, MessageEncodingROS1)
+	assert.NoError(t, err)
+	assert.False(t, decoder.HasHeader())
+
+	data := []byte{1, 0, 0, 0, 'x'}
+	ok, err := decoder.SetHeaderStamp(data, 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, []byte{1, 0, 0, 0, 'x'}, data)
+}
+
+func TestParseDefinitionsConstants(t *testing.T) {
+	defs, err := ParseDefinitions("visualization_msgs/Marker", `uint8 ARROW=0 # an arrow
+string FRAME = map # not a comment
+int32 x # value=1
+uint8[] data
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Constant{
+		{Type: "uint8", Name: "ARROW", Value: "0"},
+		{Type: "string", Name: "FRAME", Value: "map # not a comment"},
+	}, defs.Root.Constants)
+	assert.Equal(t, []Field{
+		{Name: "x", Type: "int32"},
+		{Name: "data", Type: "uint8", IsArray: true},
+	}, defs.Root.Fields)
+}
+
+func TestNewDecoderRejectsWstring(t *testing.T) {
+	schema := &mcap.Schema{
+		Name:     "my_pkg/msg/Label",
+		Encoding: SchemaEncodingROS2,
+		Data:     []byte("string name\nwstring<=10 text\n"),
+	}
+	_, err := NewDecoder(schema, MessageEncodingCDR)
+	assert.ErrorContains(t, err, "field text: wstring is not supported")
+
+	// Wide string constants are not serialized and are kept.
+	defs, err := ParseDefinitions("my_pkg/Label", "wstring GREETING=hi\nstring name\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []Constant{{Type: "wstring", Name: "GREETING", Value: "hi"}}, defs.Root.Constants)
+}
@@ -0,0 +1,289 @@
+package ros
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerType = "std_msgs/Header"
+)
+
+var primitiveSizes = map[string]int{
+	"bool":     1,
+	"int8":     1,
+	"uint8":    1,
+	"byte":     1,
+	"char":     1,
+	"int16":    2,
+	"uint16":   2,
+	"int32":    4,
+	"uint32":   4,
+	"int64":    8,
+	"uint64":   8,
+	"float32":  4,
+	"float64":  8,
+	"time":     8,
+	"duration": 8,
+	"string":   0,
+	// wstring is a primitive of ROS 2 definitions, but fields of that type
+	// are rejected by parseField.
+	"wstring": 0,
+}
+
+// Field is a single field of a ROS message definition.
+type Field struct {
+	Name        string
+	Type        string
+	IsArray     bool
+	ArrayLength int
+	IsComplex   bool
+}
+
+// Constant is a constant of a ROS message definition. Constants are not part
+// of the serialized payload.
+type Constant struct {
+	Type  string
+	Name  string
+	Value string
+}
+
+// MessageDefinition is a parsed ROS message definition.
+type MessageDefinition struct {
+	Name      string
+	Fields    []Field
+	Constants []Constant
+}
+
+// Definitions holds a root message definition together with every dependency
+// embedded in the same schema.
+type Definitions struct {
+	Root  *MessageDefinition
+	Types map[string]*MessageDefinition
+}
+
+// IsPrimitive reports whether typeName is a primitive field type rather than
+// a message type.
+func IsPrimitive(typeName string) bool {
+	_, ok := primitiveSizes[typeName]
+	return ok
+}
+
+// NormalizeTypeName converts ROS 2 style names (pkg/msg/Type) into the
+// pkg/Type form used throughout this package.
+func NormalizeTypeName(name string) string {
+	parts := strings.Split(strings.TrimSpace(name), "/")
+	if len(parts) == 3 && parts[1] == "msg" {
+		return parts[0] + "/" + parts[2]
+	}
+	return strings.Join(parts, "/")
+}
+
+// ParseDefinitions parses a concatenated ros1msg or ros2msg schema, where
+// dependent definitions are separated by a line of '=' and introduced with
+// "MSG: pkg/Type".
+func ParseDefinitions(rootName string, text string) (*Definitions, error) {
+	rootName = NormalizeTypeName(rootName)
+	defs := &Definitions{
+		Types: map[string]*MessageDefinition{},
+	}
+
+	blocks := splitDefinitionBlocks(text)
+	for idx, block := range blocks {
+		name := rootName
+		if idx > 0 {
+			header, rest, _ := strings.Cut(strings.TrimLeft(block, "\r\n\t "), "\n")
+			header = strings.TrimSpace(header)
+			if !strings.HasPrefix(header, "MSG:") {
+				return nil, fmt.Errorf("invalid dependency definition header: %q", header)
+			}
+			name = NormalizeTypeName(strings.TrimPrefix(header, "MSG:"))
+			block = rest
+		}
+
+		def, err := parseMessageDefinition(name, block)
+		if err != nil {
+			return nil, err
+		}
+		defs.Types[name] = def
+		if idx == 0 {
+			defs.Root = def
+		}
+	}
+
+	for _, def := range defs.Types {
+		for i := range def.Fields {
+			field := &def.Fields[i]
+			if !field.IsComplex {
+				continue
+			}
+			resolved, err := defs.resolve(def.Name, field.Type)
+			if err != nil {
+				return nil, err
+			}
+			field.Type = resolved
+		}
+	}
+
+	return defs, nil
+}
+
+// HeaderField returns the index of the top-level std_msgs/Header field of the
+// root definition, or -1 if there is none.
+func (d *Definitions) HeaderField() int {
+	for idx, field := range d.Root.Fields {
+		if field.IsComplex && !field.IsArray && field.Type == headerType {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (d *Definitions) resolve(parent string, typeName string) (string, error) {
+	candidates := make([]string, 0, 3)
+	if strings.Contains(typeName, "/") {
+		candidates = append(candidates, NormalizeTypeName(typeName))
+	} else {
+		if pkg, _, ok := strings.Cut(parent, "/"); ok {
+			candidates = append(candidates, pkg+"/"+typeName)
+		}
+		if typeName == "Header" {
+			candidates = append(candidates, headerType)
+		}
+	}
+
+	for _, candidate := range candidates {
+		if _, ok := d.Types[candidate]; ok {
+			return candidate, nil
+		}
+	}
+
+	// Some writers omit the package in the MSG: line, fall back to the bare name.
+	_, bare, found := strings.Cut(typeName, "/")
+	if !found {
+		bare = typeName
+	}
+	for name := range d.Types {
+		if name == bare || strings.HasSuffix(name, "/"+bare) {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to resolve type %s referenced by %s", typeName, parent)
+}
+
+func splitDefinitionBlocks(text string) []string {
+	blocks := make([]string, 0, 4)
+	var current strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) >= 3 && strings.Trim(trimmed, "=") == "" {
+			blocks = append(blocks, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	return append(blocks, current.String())
+}
+
+func parseMessageDefinition(name string, text string) (*MessageDefinition, error) {
+	def := &MessageDefinition{
+		Name:   name,
+		Fields: make([]Field, 0, 8),
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if constant, ok := parseConstant(line); ok {
+			def.Constants = append(def.Constants, constant)
+			continue
+		}
+
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			continue
+		}
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("invalid field definition in %s: %q", name, line)
+		}
+
+		field, err := parseField(tokens[0], tokens[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field definition in %s: %s", name, err)
+		}
+		def.Fields = append(def.Fields, field)
+	}
+
+	return def, nil
+}
+
+// parseConstant parses a constant line, either TYPE NAME=value or
+// TYPE NAME = value. String constants hold the rest of the line, comments
+// included.
+func parseConstant(line string) (Constant, bool) {
+	line = strings.TrimSpace(line)
+	typeToken, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		typeToken, rest, ok = strings.Cut(line, "\t")
+	}
+	if !ok || strings.HasPrefix(typeToken, "#") {
+		return Constant{}, false
+	}
+
+	eq, hash := strings.Index(rest, "="), strings.Index(rest, "#")
+	if eq < 0 || (hash >= 0 && hash < eq) {
+		return Constant{}, false
+	}
+	value := rest[eq+1:]
+	if typeToken != "string" {
+		value, _, _ = strings.Cut(value, "#")
+	}
+	return Constant{
+		Type:  typeToken,
+		Name:  strings.TrimSpace(rest[:eq]),
+		Value: strings.TrimSpace(value),
+	}, true
+}
+
+func parseField(typeToken string, name string) (Field, error) {
+	field := Field{
+		Name: name,
+	}
+
+	if idx := strings.Index(typeToken, "["); idx >= 0 {
+		if !strings.HasSuffix(typeToken, "]") {
+			return field, fmt.Errorf("invalid array type %s", typeToken)
+		}
+		field.IsArray = true
+		size := typeToken[idx+1 : len(typeToken)-1]
+		typeToken = typeToken[:idx]
+		// Bounded sequences (<=N) are serialized like unbounded ones.
+		if size != "" && !strings.HasPrefix(size, "<=") {
+			length, err := strconv.Atoi(size)
+			if err != nil || length <= 0 {
+				return field, fmt.Errorf("invalid array length %s", size)
+			}
+			field.ArrayLength = length
+		}
+	}
+
+	// Bounded strings (string<=N) are serialized like unbounded ones.
+	if base, _, ok := strings.Cut(typeToken, "<="); ok {
+		typeToken = base
+	}
+	// The serialization of wide strings depends on the CDR library, so
+	// definitions using them are rejected rather than misread.
+	if typeToken == "wstring" {
+		return field, fmt.Errorf("field %s: wstring is not supported", name)
+	}
+
+	field.IsComplex = !IsPrimitive(typeToken)
+	field.Type = typeToken
+
+	return field, nil
+}
@@ -0,0 +1,239 @@
+package ros
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrShortMessage is returned when a message ends before its definition.
+var ErrShortMessage = errors.New("unexpected end of message")
+
+// FieldValue is a decoded field of a message.
+type FieldValue struct {
+	Name  string
+	Value any
+}
+
+// Message is a decoded message with its fields in definition order. Values
+// are bools, integers, float32 or float64, strings, []byte for uint8 arrays,
+// []any for other arrays and nested Messages. Times and durations are
+// Messages with sec and nsec fields.
+type Message []FieldValue
+
+// Map converts m and its nested messages to maps keyed by field name.
+func (m Message) Map() map[string]any {
+	result := make(map[string]any, len(m))
+	for _, field := range m {
+		result[field.Name] = mapValue(field.Value)
+	}
+	return result
+}
+
+func mapValue(value any) any {
+	switch value := value.(type) {
+	case Message:
+		return value.Map()
+	case []any:
+		values := make([]any, len(value))
+		for i, v := range value {
+			values[i] = mapValue(v)
+		}
+		return values
+	default:
+		return value
+	}
+}
+
+type reader struct {
+	data   []byte
+	pos    int
+	origin int
+	cdr    bool
+	order  binary.ByteOrder
+	types  map[string]*MessageDefinition
+}
+
+func (r *reader) message(def *MessageDefinition) (Message, error) {
+	result := make(Message, 0, len(def.Fields))
+	for _, field := range def.Fields {
+		value, err := r.field(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", def.Name, field.Name, err)
+		}
+		result = append(result, FieldValue{Name: field.Name, Value: value})
+	}
+	return result, nil
+}
+
+func (r *reader) field(field Field) (any, error) {
+	if !field.IsArray {
+		return r.value(field)
+	}
+
+	length := field.ArrayLength
+	if length == 0 {
+		n, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		length = int(n)
+	}
+
+	// uint8 arrays are kept as raw bytes, which is how ROS treats them (images, point clouds).
+	if field.Type == "uint8" || field.Type == "byte" || field.Type == "char" {
+		b, err := r.bytes(length)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, length)
+		copy(out, b)
+		return out, nil
+	}
+
+	if length > len(r.data)-r.pos && !field.IsComplex {
+		return nil, fmt.Errorf("%w: array length %d exceeds remaining %d bytes", ErrShortMessage, length, len(r.data)-r.pos)
+	}
+
+	values := make([]any, 0, min(length, len(r.data)-r.pos+1))
+	for i := 0; i < length; i++ {
+		value, err := r.value(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (r *reader) value(field Field) (any, error) {
+	if field.IsComplex {
+		def, ok := r.types[field.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", field.Type)
+		}
+		return r.message(def)
+	}
+
+	switch field.Type {
+	case "bool":
+		v, err := r.uint8()
+		return v != 0, err
+	case "int8":
+		v, err := r.uint8()
+		return int8(v), err
+	case "uint8", "byte", "char":
+		return r.uint8()
+	case "int16":
+		v, err := r.uint16()
+		return int16(v), err
+	case "uint16":
+		return r.uint16()
+	case "int32":
+		v, err := r.uint32()
+		return int32(v), err
+	case "uint32":
+		return r.uint32()
+	case "int64":
+		v, err := r.uint64()
+		return int64(v), err
+	case "uint64":
+		return r.uint64()
+	case "float32":
+		v, err := r.uint32()
+		return math.Float32frombits(v), err
+	case "float64":
+		v, err := r.uint64()
+		return math.Float64frombits(v), err
+	case "string":
+		return r.string()
+	case "time":
+		sec, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		nsec, err := r.uint32()
+		return Message{{Name: "sec", Value: sec}, {Name: "nsec", Value: nsec}}, err
+	case "duration":
+		sec, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		nsec, err := r.uint32()
+		return Message{{Name: "sec", Value: int32(sec)}, {Name: "nsec", Value: int32(nsec)}}, err
+	default:
+		return nil, fmt.Errorf("unsupported type %s", field.Type)
+	}
+}
+
+// align advances to the next multiple of n relative to the CDR stream origin.
+// ROS 1 serialization is packed, so it is a no-op there.
+func (r *reader) align(n int) {
+	if !r.cdr {
+		return
+	}
+	if rem := (r.pos - r.origin) % n; rem != 0 {
+		r.pos += n - rem
+	}
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("%w at offset %d reading %d bytes", ErrShortMessage, r.pos, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) uint8() (uint8, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *reader) uint16() (uint16, error) {
+	r.align(2)
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint16(b), nil
+}
+
+func (r *reader) uint32() (uint32, error) {
+	r.align(4)
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint32(b), nil
+}
+
+func (r *reader) uint64() (uint64, error) {
+	r.align(8)
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint64(b), nil
+}
+
+func (r *reader) string() (string, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	if err != nil {
+		return "", err
+	}
+	// CDR strings include the null terminator in their length.
+	if r.cdr && len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return string(b), nil
+}
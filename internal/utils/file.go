@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"mcap-utility/internal/constants"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -21,6 +22,11 @@ func IsDirExists(dir string) bool {
 	return err == nil
 }
 
+func IsFileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
 func CreateDir(dir string) error {
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
@@ -38,6 +44,20 @@ func HasAnySuffix(s string, suffixes []string) bool {
 	return false
 }
 
+// MatchesAnyPattern reports whether name matches one of the glob patterns.
+// An empty pattern list matches everything.
+func MatchesAnyPattern(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
 func RemoveEmptyStrings(input []string) []string {
 	var result []string
 	for _, str := range input {
@@ -49,13 +69,19 @@ func RemoveEmptyStrings(input []string) []string {
 }
 
 func ListMCAPFilesInDirectory(path string) ([]string, error) {
+	return ListFilesInDirectory(path, constants.MCAPFIleExtension)
+}
+
+// ListFilesInDirectory lists the files under path, recursively, whose name
+// ends with extension.
+func ListFilesInDirectory(path string, extension string) ([]string, error) {
 	var files []string
 
 	err := filepath.WalkDir(path, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !d.IsDir() && strings.HasSuffix(d.Name(), constants.MCAPFIleExtension) {
+		if !d.IsDir() && strings.HasSuffix(d.Name(), extension) {
 			files = append(files, path)
 		}
 		return nil
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/foxglove/mcap/go/mcap"
+)
+
+var compressionLevelMapper = map[int]mcap.CompressionLevel{
+	0: mcap.CompressionLevelDefault,
+	1: mcap.CompressionLevelFastest,
+	2: mcap.CompressionLevelBetter,
+	3: mcap.CompressionLevelBest,
+}
+
+// NewWriterOptions returns the writer options shared by every subcommand
+// writing MCAP files. An empty compression keeps the zstd default.
+func NewWriterOptions(compression string, compressionLevel int) (*mcap.WriterOptions, error) {
+	writerOpt := &mcap.WriterOptions{
+		IncludeCRC:               true,
+		Chunked:                  true,
+		Compression:              mcap.CompressionZSTD,
+		CompressionLevel:         mcap.CompressionLevelDefault,
+		SkipMessageIndexing:      false,
+		SkipStatistics:           false,
+		SkipRepeatedSchemas:      false,
+		SkipRepeatedChannelInfos: false,
+		SkipAttachmentIndex:      false,
+		SkipMetadataIndex:        false,
+		SkipChunkIndex:           false,
+		SkipSummaryOffsets:       false,
+		OverrideLibrary:          false,
+		SkipMagic:                false,
+	}
+
+	if compression != "" {
+		compression = strings.TrimSpace(strings.ToLower(compression))
+		switch mcap.CompressionFormat(compression) {
+		case mcap.CompressionZSTD, mcap.CompressionLZ4:
+			writerOpt.Compression = mcap.CompressionFormat(compression)
+		default:
+			return nil, fmt.Errorf("invalid compression: %s", compression)
+		}
+	}
+
+	level, ok := compressionLevelMapper[compressionLevel]
+	if !ok {
+		return nil, fmt.Errorf("invalid compression level: %d", compressionLevel)
+	}
+	writerOpt.CompressionLevel = level
+
+	return writerOpt, nil
+}
+
+// CopyMetadata copies every metadata record from reader to writer. A non-nil
+// transform may rewrite each record, or return nil to skip it.
+func CopyMetadata(
+	reader *mcap.Reader,
+	writer *mcap.Writer,
+	mcapInfo *mcap.Info,
+	transform func(*mcap.Metadata) (*mcap.Metadata, error),
+) error {
+	for _, metadataIdx := range mcapInfo.MetadataIndexes {
+		metadata, err := reader.GetMetadata(metadataIdx.Offset)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata %s: %s", metadataIdx.Name, err)
+		}
+
+		if transform != nil {
+			metadata, err = transform(metadata)
+			if err != nil {
+				return err
+			}
+			if metadata == nil {
+				continue
+			}
+		}
+
+		if err := writer.WriteMetadata(metadata); err != nil {
+			return fmt.Errorf("failed to write metadata %s: %s", metadataIdx.Name, err)
+		}
+	}
+	return nil
+}
+
+// CopyAttachments copies every attachment from reader to writer. A non-nil
+// transform may rewrite each attachment, or return nil to skip it without
+// reading its data.
+func CopyAttachments(
+	reader *mcap.Reader,
+	writer *mcap.Writer,
+	mcapInfo *mcap.Info,
+	transform func(*mcap.Attachment) (*mcap.Attachment, error),
+) error {
+	for _, attachmentIdx := range mcapInfo.AttachmentIndexes {
+		attachmentReader, err := reader.GetAttachmentReader(attachmentIdx.Offset)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %s", attachmentIdx.Name, err)
+		}
+
+		attachment := &mcap.Attachment{
+			LogTime:    attachmentReader.LogTime,
+			CreateTime: attachmentReader.CreateTime,
+			Name:       attachmentReader.Name,
+			MediaType:  attachmentReader.MediaType,
+			DataSize:   attachmentReader.DataSize,
+			Data:       attachmentReader.Data(),
+		}
+
+		if transform != nil {
+			attachment, err = transform(attachment)
+			if err != nil {
+				return err
+			}
+			if attachment == nil {
+				continue
+			}
+		}
+
+		err = writer.WriteAttachment(attachment)
+		if err != nil {
+			return fmt.Errorf("failed to write attachment %s: %s", attachmentIdx.Name, err)
+		}
+	}
+	return nil
+}
+
+// ReadMessages returns an iterator over the messages of the MCAP stream r in
+// log time order, along with its reader to close once done. Streams without a
+// summary section cannot be read through their indexes, so they are read
+// linearly in file order instead, which is reported by ordered being false.
+func ReadMessages(r io.ReadSeeker, readOpts ...mcap.ReadOpt) (reader *mcap.Reader, msgs mcap.MessageIterator, ordered bool, err error) {
+	reader, err = mcap.NewReader(r)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to create new reader: %s", err)
+	}
+
+	order := mcap.InOrder(mcap.LogTimeOrder)
+	ordered = true
+	if _, err := reader.Info(); err != nil {
+		reader.Close()
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to seek to start: %s", err)
+		}
+		reader, err = mcap.NewReader(r)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to create new reader: %s", err)
+		}
+		order = mcap.UsingIndex(false)
+		ordered = false
+	}
+
+	msgs, err = reader.Messages(append(readOpts[:len(readOpts):len(readOpts)], order)...)
+	if err != nil {
+		reader.Close()
+		return nil, nil, false, fmt.Errorf("failed to read messages: %s", err)
+	}
+	return reader, msgs, ordered, nil
+}
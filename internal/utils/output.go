@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"fmt"
+	"mcap-utility/internal/constants"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultNameTemplate keeps the name of the input files.
+const DefaultNameTemplate = "{name}"
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ExpandNameTemplate returns the output file name of the input file name
+// given by template, whose {name}, {stem} and {ext} placeholders are replaced
+// with the input file name, the name without its extension and the
+// extension. For example {stem}_edited{ext} names the output of run.mcap
+// run_edited.mcap.
+func ExpandNameTemplate(template string, fileName string) (string, error) {
+	ext := filepath.Ext(fileName)
+	if strings.HasSuffix(fileName, constants.MCAPFIleExtension) {
+		ext = constants.MCAPFIleExtension
+	}
+	values := map[string]string{
+		"{name}": fileName,
+		"{stem}": strings.TrimSuffix(fileName, ext),
+		"{ext}":  ext,
+	}
+
+	var unknown string
+	name := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok && unknown == "" {
+			unknown = placeholder
+		}
+		return value
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown placeholder %s in name template %s, use {name}, {stem} or {ext}", unknown, template)
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("name template %s gives invalid file name %q", template, name)
+	}
+	return name, nil
+}
+
+// OutputPaths maps each input file to its output path under output, in the
+// directory mirroring the one of the file under root, named after template.
+// It fails if two inputs have the same output path, or if an output path is
+// one of the inputs, before anything is written.
+func OutputPaths(root string, files []string, output string, template string) (map[string]string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	absInputs := make(map[string]string, len(files))
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		absInputs[absFile] = file
+	}
+
+	outputs := make(map[string]string, len(files))
+	byOutput := make(map[string]string, len(files))
+	for _, file := range files {
+		absFile, _ := filepath.Abs(file)
+		rel, err := filepath.Rel(absRoot, filepath.Dir(absFile))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("input %s is not under %s", file, root)
+		}
+		name, err := ExpandNameTemplate(template, filepath.Base(file))
+		if err != nil {
+			return nil, err
+		}
+
+		outputPath := filepath.Join(output, rel, name)
+		absOutput, err := filepath.Abs(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := byOutput[absOutput]; ok {
+			return nil, fmt.Errorf("inputs %s and %s would both be written to %s", other, file, outputPath)
+		}
+		if input, ok := absInputs[absOutput]; ok {
+			return nil, fmt.Errorf("output of %s would overwrite input %s", file, input)
+		}
+		byOutput[absOutput] = file
+		outputs[file] = outputPath
+	}
+	return outputs, nil
+}
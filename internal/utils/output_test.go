@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandNameTemplate(t *testing.T) {
+	cases := map[string]string{
+		DefaultNameTemplate:  "run.mcap",
+		"{stem}_edited{ext}": "run_edited.mcap",
+		"edited_{name}":      "edited_run.mcap",
+		"{stem}":             "run",
+	}
+	for template, expected := range cases {
+		name, err := ExpandNameTemplate(template, "run.mcap")
+		assert.NoError(t, err, template)
+		assert.Equal(t, expected, name, template)
+	}
+
+	_, err := ExpandNameTemplate("{base}.mcap", "run.mcap")
+	assert.ErrorContains(t, err, "unknown placeholder {base}")
+	_, err = ExpandNameTemplate("out/{name}", "run.mcap")
+	assert.Error(t, err)
+	_, err = ExpandNameTemplate("{}", "run.mcap")
+	assert.Error(t, err)
+}
+
+func TestOutputPaths(t *testing.T) {
+	files := []string{
+		filepath.Join("logs", "run.mcap"),
+		filepath.Join("logs", "a", "run.mcap"),
+		filepath.Join("logs", "b", "c", "run.mcap"),
+	}
+	outputs, err := OutputPaths("logs", files, "out", "{stem}_edited{ext}")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		files[0]: filepath.Join("out", "run_edited.mcap"),
+		files[1]: filepath.Join("out", "a", "run_edited.mcap"),
+		files[2]: filepath.Join("out", "b", "c", "run_edited.mcap"),
+	}, outputs)
+
+	_, err = OutputPaths("logs", append(files, filepath.Join("logs", "a", "other.mcap")), "out", "edited{ext}")
+	assert.ErrorContains(t, err, "would both be written to")
+
+	_, err = OutputPaths("logs", files, "logs", DefaultNameTemplate)
+	assert.ErrorContains(t, err, "would overwrite input")
+
+	_, err = OutputPaths("logs", []string{filepath.Join("other", "run.mcap")}, "out", DefaultNameTemplate)
+	assert.ErrorContains(t, err, "is not under")
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// ReplaceFile atomically replaces the file at path with the complete, synced
+// file at tmpPath, which must be in the same directory. The file mode of path
+// is kept. With a backup suffix, the original file is first kept as
+// path+backupSuffix. At any time, path holds either the original or the new
+// file.
+func ReplaceFile(tmpPath string, path string, backupSuffix string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if backupSuffix != "" {
+		backupPath := path + backupSuffix
+		if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove previous backup %s: %s", backupPath, err)
+		}
+		// A hard link keeps the original in place, copying is only needed
+		// on file systems without them.
+		if err := os.Link(path, backupPath); err != nil {
+			if err := copyFile(path, backupPath); err != nil {
+				return fmt.Errorf("failed to back up %s: %s", path, err)
+			}
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return SyncDir(filepath.Dir(path))
+}
+
+// SyncDir flushes the entries of the directory dir, so a file created or
+// renamed in it survives a crash.
+func SyncDir(dir string) error {
+	// Directories cannot be opened for syncing on Windows, where renames are
+	// flushed with the file system journal.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func copyFile(src string, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := out.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run.mcap")
+	tmpPath := filepath.Join(dir, ".run.mcap.tmp")
+	assert.NoError(t, os.WriteFile(path, []byte("original"), 0o640))
+	assert.NoError(t, os.WriteFile(tmpPath, []byte("edited"), 0o600))
+
+	assert.NoError(t, ReplaceFile(tmpPath, path, ".bak"))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited", string(data))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	data, err = os.ReadFile(path + ".bak")
+	assert.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+	assert.NoFileExists(t, tmpPath)
+
+	assert.NoError(t, os.WriteFile(tmpPath, []byte("edited again"), 0o600))
+	assert.NoError(t, ReplaceFile(tmpPath, path, ".bak"))
+	data, err = os.ReadFile(path + ".bak")
+	assert.NoError(t, err)
+	assert.Equal(t, "edited", string(data))
+
+	assert.Error(t, ReplaceFile(tmpPath, path, ""))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited again", string(data))
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"1024":   1024,
+		"10B":    10,
+		"2KB":    2000,
+		"2KiB":   2048,
+		"1.5GiB": 3 << 29,
+		"500mb":  500_000_000,
+		"4M":     4 << 20,
+	}
+	for input, expected := range cases {
+		size, err := ParseByteSize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	_, err := ParseByteSize("ten MB")
+	assert.Error(t, err)
+	_, err = ParseByteSize("-1GB")
+	assert.Error(t, err)
+}
@@ -0,0 +1,217 @@
+// Package mcapconvert converts ROS 1 bag files and ROS 2 rosbag2 sqlite3
+// recordings to MCAP, and MCAP files back to ROS 1 bag files.
+package mcapconvert
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pierrec/lz4/v4"
+	"io"
+)
+
+// bagMagic starts every ROS 1 bag v2.0 file.
+const bagMagic = "#ROSBAG V2.0\n"
+
+// Op codes of the records of a ROS 1 bag.
+const (
+	bagOpMessageData = 0x02
+	bagOpBagHeader   = 0x03
+	bagOpIndexData   = 0x04
+	bagOpChunk       = 0x05
+	bagOpChunkInfo   = 0x06
+	bagOpConnection  = 0x07
+)
+
+// Compressions of the chunks of a ROS 1 bag.
+const (
+	bagCompressionNone = "none"
+	bagCompressionBZ2  = "bz2"
+	bagCompressionLZ4  = "lz4"
+)
+
+// bagHeader holds the fields of a record header, or of a connection header,
+// by name.
+type bagHeader map[string][]byte
+
+// parseBagHeader parses a sequence of length prefixed name=value fields.
+func parseBagHeader(data []byte) (bagHeader, error) {
+	header := bagHeader{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated header field length")
+		}
+		length := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("header field length %d exceeds remaining %d bytes", length, len(data))
+		}
+		field := data[:length]
+		data = data[length:]
+
+		name, value, ok := bytes.Cut(field, []byte("="))
+		if !ok {
+			return nil, fmt.Errorf("header field without '=': %q", field)
+		}
+		header[string(name)] = value
+	}
+	return header, nil
+}
+
+func (h bagHeader) op() (byte, error) {
+	value, ok := h["op"]
+	if !ok || len(value) != 1 {
+		return 0, fmt.Errorf("missing or invalid op field")
+	}
+	return value[0], nil
+}
+
+func (h bagHeader) uint32(name string) (uint32, error) {
+	value, ok := h[name]
+	if !ok || len(value) != 4 {
+		return 0, fmt.Errorf("missing or invalid %s field", name)
+	}
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+// time returns the nanoseconds of a time field, stored as seconds and
+// nanoseconds.
+func (h bagHeader) time(name string) (uint64, error) {
+	value, ok := h[name]
+	if !ok || len(value) != 8 {
+		return 0, fmt.Errorf("missing or invalid %s field", name)
+	}
+	sec := binary.LittleEndian.Uint32(value)
+	nsec := binary.LittleEndian.Uint32(value[4:])
+	return uint64(sec)*1e9 + uint64(nsec), nil
+}
+
+func (h bagHeader) string(name string) (string, error) {
+	value, ok := h[name]
+	if !ok {
+		return "", fmt.Errorf("missing %s field", name)
+	}
+	return string(value), nil
+}
+
+// appendBagField appends the name=value field of a record header, or of a
+// connection header, to buf.
+func appendBagField(buf []byte, name string, value []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(name)+1+len(value)))
+	buf = append(buf, name...)
+	buf = append(buf, '=')
+	return append(buf, value...)
+}
+
+// appendBagRecord appends the record with the given header and data to buf.
+func appendBagRecord(buf []byte, header []byte, data []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(header)))
+	buf = append(buf, header...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// bagTime encodes nanoseconds as the seconds and nanoseconds of a time
+// field.
+func bagTime(nanos uint64) []byte {
+	time := binary.LittleEndian.AppendUint32(nil, uint32(nanos/1e9))
+	return binary.LittleEndian.AppendUint32(time, uint32(nanos%1e9))
+}
+
+// bagRecordReader reads the records of a ROS 1 bag, or of one of its chunks.
+type bagRecordReader struct {
+	r      io.Reader
+	buf    []byte
+	header bagHeader
+	data   []byte
+}
+
+func newBagRecordReader(r io.Reader) *bagRecordReader {
+	return &bagRecordReader{r: r}
+}
+
+// next reads the next record into header and data, which are valid until the
+// following call. It returns io.EOF at the end of the stream, and
+// io.ErrUnexpectedEOF for a truncated record.
+func (br *bagRecordReader) next() error {
+	headerData, err := br.readLengthPrefixed(0)
+	if err != nil {
+		return err
+	}
+	br.header, err = parseBagHeader(headerData)
+	if err != nil {
+		return fmt.Errorf("invalid record header: %s", err)
+	}
+	br.data, err = br.readLengthPrefixed(len(headerData))
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readLengthPrefixed reads a length prefixed field into buf from offset.
+func (br *bagRecordReader) readLengthPrefixed(offset int) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(br.r, length[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint32(length[:]))
+	if cap(br.buf) < offset+n {
+		grown := make([]byte, offset+n)
+		copy(grown, br.buf[:offset])
+		br.buf = grown
+	}
+	br.buf = br.buf[:offset+n]
+	if _, err := io.ReadFull(br.r, br.buf[offset:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return br.buf[offset:], nil
+}
+
+// readBagMagic checks r starts with the magic of a ROS 1 bag v2.0 file.
+func readBagMagic(r io.Reader) error {
+	magic := make([]byte, len(bagMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("failed to read magic: %s", err)
+	}
+	if string(magic) != bagMagic {
+		return fmt.Errorf("not a ROS 1 bag v2.0 file")
+	}
+	return nil
+}
+
+// decompressChunk returns the records held by the chunk with the given
+// record header and data.
+func decompressChunk(header bagHeader, data []byte) ([]byte, error) {
+	compression, err := header.string("compression")
+	if err != nil {
+		return nil, err
+	}
+	size, err := header.uint32("size")
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	switch compression {
+	case bagCompressionNone:
+		return data, nil
+	case bagCompressionBZ2:
+		r = bzip2.NewReader(bytes.NewReader(data))
+	case bagCompressionLZ4:
+		r = lz4.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported chunk compression: %s", compression)
+	}
+
+	records := make([]byte, size)
+	if _, err := io.ReadFull(r, records); err != nil {
+		return nil, fmt.Errorf("failed to decompress %s chunk: %s", compression, err)
+	}
+	return records, nil
+}
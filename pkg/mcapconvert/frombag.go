@@ -0,0 +1,264 @@
+package mcapconvert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"mcap-utility/internal/utils"
+)
+
+// BagReport describes the MCAP file written by BagToMCAP or DB3ToMCAP.
+type BagReport struct {
+	// Chunks is the number of chunks read from a ROS 1 bag.
+	Chunks   int
+	Schemas  int
+	Channels int
+	Messages uint64
+	// MessageStartTime and MessageEndTime bound the log times of the
+	// messages.
+	MessageStartTime uint64
+	MessageEndTime   uint64
+	// UnknownTypes lists the message types of ROS 2 topics written without a
+	// schema, as their definition was not found.
+	UnknownTypes []string
+	// SkippedTopics lists the topics left out of a ROS 1 bag, as their
+	// messages are not ros1 encoded.
+	SkippedTopics []string
+}
+
+// bagConverter writes the records of a ROS 1 bag as MCAP records.
+type bagConverter struct {
+	writer *mcap.Writer
+	report *BagReport
+	// schemas maps the type and definition of the connections to their
+	// schema ID.
+	schemas map[string]uint16
+	// channels maps connection IDs to channel IDs.
+	channels  map[uint32]uint16
+	sequences map[uint16]uint32
+	msg       mcap.Message
+}
+
+// BagToMCAP converts the ROS 1 bag v2.0 stream r to an MCAP file with the ros1
+// profile written to w. Records are read linearly, so bags without an index
+// section, e.g. of an interrupted recording, are converted too. Every
+// connection becomes a channel with the ros1 message encoding and a ros1msg
+// schema holding its message definition, and the other fields of its
+// connection header, such as md5sum, callerid and latching, are kept as
+// channel metadata. Messages keep the order of the bag and their time is used
+// as both log and publish time.
+func BagToMCAP(ctx context.Context, r io.Reader, w io.Writer, writerOpt *mcap.WriterOptions) (*BagReport, error) {
+	if writerOpt == nil {
+		var err error
+		writerOpt, err = utils.NewWriterOptions("", 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := readBagMagic(r); err != nil {
+		return nil, err
+	}
+
+	writer, err := mcap.NewWriter(w, writerOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new writer: %s", err)
+	}
+	if err := writer.WriteHeader(&mcap.Header{Profile: "ros1"}); err != nil {
+		return nil, fmt.Errorf("failed to write header: %s", err)
+	}
+
+	c := &bagConverter{
+		writer:    writer,
+		report:    &BagReport{},
+		schemas:   map[string]uint16{},
+		channels:  map[uint32]uint16{},
+		sequences: map[uint16]uint32{},
+	}
+
+	records := newBagRecordReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := records.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read record: %s", err)
+		}
+
+		op, err := records.header.op()
+		if err != nil {
+			return nil, fmt.Errorf("invalid record: %s", err)
+		}
+		if op == bagOpChunk {
+			chunk, err := decompressChunk(records.header, records.data)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.chunk(chunk); err != nil {
+				return nil, err
+			}
+			c.report.Chunks++
+			continue
+		}
+		if err := c.record(op, records.header, records.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writer: %s", err)
+	}
+	return c.report, nil
+}
+
+// chunk converts the records held by a decompressed chunk.
+func (c *bagConverter) chunk(data []byte) error {
+	records := newBagRecordReader(bytes.NewReader(data))
+	for {
+		err := records.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read chunk record: %s", err)
+		}
+
+		op, err := records.header.op()
+		if err != nil {
+			return fmt.Errorf("invalid chunk record: %s", err)
+		}
+		if err := c.record(op, records.header, records.data); err != nil {
+			return err
+		}
+	}
+}
+
+// record converts a record other than a chunk. Index records are rebuilt by
+// the MCAP writer, so only connections and messages are kept.
+func (c *bagConverter) record(op byte, header bagHeader, data []byte) error {
+	switch op {
+	case bagOpConnection:
+		return c.connection(header, data)
+	case bagOpMessageData:
+		return c.message(header, data)
+	case bagOpBagHeader, bagOpIndexData, bagOpChunkInfo:
+		return nil
+	default:
+		return fmt.Errorf("unknown record op: %#x", op)
+	}
+}
+
+// connection writes the schema and channel of a connection the first time it
+// is found. Connections are written both in the chunk holding their first
+// message and in the index section of the bag.
+func (c *bagConverter) connection(header bagHeader, data []byte) error {
+	conn, err := header.uint32("conn")
+	if err != nil {
+		return fmt.Errorf("invalid connection record: %s", err)
+	}
+	if _, ok := c.channels[conn]; ok {
+		return nil
+	}
+	topic, err := header.string("topic")
+	if err != nil {
+		return fmt.Errorf("invalid connection record: %s", err)
+	}
+	if len(c.channels) >= 0xffff {
+		return fmt.Errorf("too many connections for MCAP channel IDs")
+	}
+
+	connHeader, err := parseBagHeader(data)
+	if err != nil {
+		return fmt.Errorf("invalid header of connection %d on %s: %s", conn, topic, err)
+	}
+	messageType, err := connHeader.string("type")
+	if err != nil {
+		return fmt.Errorf("invalid header of connection %d on %s: %s", conn, topic, err)
+	}
+	definition := connHeader["message_definition"]
+
+	key := messageType + "\x00" + string(definition)
+	schemaID, ok := c.schemas[key]
+	if !ok {
+		schemaID = uint16(len(c.schemas) + 1)
+		err := c.writer.WriteSchema(&mcap.Schema{
+			ID:       schemaID,
+			Name:     messageType,
+			Encoding: "ros1msg",
+			Data:     bytes.Clone(definition),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write schema %s: %s", messageType, err)
+		}
+		c.schemas[key] = schemaID
+		c.report.Schemas++
+	}
+
+	metadata := make(map[string]string, len(connHeader))
+	for name, value := range connHeader {
+		switch name {
+		case "topic", "type", "message_definition":
+		default:
+			metadata[name] = string(value)
+		}
+	}
+
+	channelID := uint16(len(c.channels) + 1)
+	err = c.writer.WriteChannel(&mcap.Channel{
+		ID:              channelID,
+		SchemaID:        schemaID,
+		Topic:           topic,
+		MessageEncoding: "ros1",
+		Metadata:        metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write channel %s: %s", topic, err)
+	}
+	c.channels[conn] = channelID
+	c.report.Channels++
+	return nil
+}
+
+func (c *bagConverter) message(header bagHeader, data []byte) error {
+	conn, err := header.uint32("conn")
+	if err != nil {
+		return fmt.Errorf("invalid message record: %s", err)
+	}
+	logTime, err := header.time("time")
+	if err != nil {
+		return fmt.Errorf("invalid message record: %s", err)
+	}
+	channelID, ok := c.channels[conn]
+	if !ok {
+		return fmt.Errorf("message at time %d on unknown connection %d", logTime, conn)
+	}
+
+	c.msg = mcap.Message{
+		ChannelID:   channelID,
+		Sequence:    c.sequences[channelID],
+		LogTime:     logTime,
+		PublishTime: logTime,
+		Data:        data,
+	}
+	if err := c.writer.WriteMessage(&c.msg); err != nil {
+		return fmt.Errorf("failed to write message: %s", err)
+	}
+	c.sequences[channelID]++
+
+	if c.report.Messages == 0 || logTime < c.report.MessageStartTime {
+		c.report.MessageStartTime = logTime
+	}
+	if logTime > c.report.MessageEndTime {
+		c.report.MessageEndTime = logTime
+	}
+	c.report.Messages++
+	return nil
+}
@@ -0,0 +1,147 @@
+package mcapconvert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+const stringDefinition = "string data\n"
+
+func bagConnection(conn uint32, topic string) []byte {
+	header := appendBagField(nil, "op", []byte{bagOpConnection})
+	header = appendBagField(header, "conn", binary.LittleEndian.AppendUint32(nil, conn))
+	header = appendBagField(header, "topic", []byte(topic))
+
+	data := appendBagField(nil, "topic", []byte(topic))
+	data = appendBagField(data, "type", []byte("std_msgs/String"))
+	data = appendBagField(data, "md5sum", []byte("992ce8a1687cec8c8bd883ec73ca41d1"))
+	data = appendBagField(data, "message_definition", []byte(stringDefinition))
+	data = appendBagField(data, "callerid", []byte("/talker"))
+	return appendBagRecord(nil, header, data)
+}
+
+func bagMessage(conn uint32, sec, nsec uint32, text string) []byte {
+	header := appendBagField(nil, "op", []byte{bagOpMessageData})
+	header = appendBagField(header, "conn", binary.LittleEndian.AppendUint32(nil, conn))
+	header = appendBagField(header, "time", binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, sec), nsec))
+
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(text)))
+	return appendBagRecord(nil, header, append(data, text...))
+}
+
+func bagChunk(t *testing.T, compression string, records []byte) []byte {
+	t.Helper()
+
+	data := records
+	if compression == bagCompressionLZ4 {
+		buf := &bytes.Buffer{}
+		w := lz4.NewWriter(buf)
+		_, err := w.Write(records)
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		data = buf.Bytes()
+	}
+
+	header := appendBagField(nil, "op", []byte{bagOpChunk})
+	header = appendBagField(header, "compression", []byte(compression))
+	header = appendBagField(header, "size", binary.LittleEndian.AppendUint32(nil, uint32(len(records))))
+	return appendBagRecord(nil, header, data)
+}
+
+// writeTestBag writes a bag of two chunks followed by the connection records
+// of its index section. The chunk info and index data records are left out,
+// as they are not read.
+func writeTestBag(t *testing.T, compression string) []byte {
+	t.Helper()
+
+	bag := []byte(bagMagic)
+	header := appendBagField(nil, "op", []byte{bagOpBagHeader})
+	header = appendBagField(header, "index_pos", make([]byte, 8))
+	bag = appendBagRecord(bag, header, bytes.Repeat([]byte(" "), 64))
+
+	first := bagConnection(0, "/chatter")
+	first = append(first, bagMessage(0, 1, 0, "hello")...)
+	first = append(first, bagConnection(1, "/echo")...)
+	first = append(first, bagMessage(1, 1, 500, "hello back")...)
+	bag = append(bag, bagChunk(t, compression, first)...)
+
+	second := bagMessage(0, 2, 0, "world")
+	bag = append(bag, bagChunk(t, compression, second)...)
+
+	bag = append(bag, bagConnection(0, "/chatter")...)
+	return append(bag, bagConnection(1, "/echo")...)
+}
+
+func TestBagToMCAP(t *testing.T) {
+	for _, compression := range []string{bagCompressionNone, bagCompressionLZ4} {
+		t.Run(compression, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			report, err := BagToMCAP(context.Background(), bytes.NewReader(writeTestBag(t, compression)), out, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, &BagReport{
+				Chunks:           2,
+				Schemas:          1,
+				Channels:         2,
+				Messages:         3,
+				MessageStartTime: 1e9,
+				MessageEndTime:   2e9,
+			}, report)
+
+			reader, err := mcap.NewReader(bytes.NewReader(out.Bytes()))
+			assert.NoError(t, err)
+			defer reader.Close()
+
+			assert.Equal(t, "ros1", reader.Header().Profile)
+			mcapInfo, err := reader.Info()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(3), mcapInfo.Statistics.MessageCount)
+
+			schema := mcapInfo.Schemas[1]
+			assert.Equal(t, "std_msgs/String", schema.Name)
+			assert.Equal(t, "ros1msg", schema.Encoding)
+			assert.Equal(t, stringDefinition, string(schema.Data))
+
+			channel := mcapInfo.Channels[1]
+			assert.Equal(t, "/chatter", channel.Topic)
+			assert.Equal(t, "ros1", channel.MessageEncoding)
+			assert.Equal(t, map[string]string{
+				"md5sum":   "992ce8a1687cec8c8bd883ec73ca41d1",
+				"callerid": "/talker",
+			}, channel.Metadata)
+
+			it, err := reader.Messages(mcap.InOrder(mcap.FileOrder))
+			assert.NoError(t, err)
+			texts := []string{}
+			for {
+				_, channel, msg, err := it.NextInto(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, msg.LogTime, msg.PublishTime)
+				texts = append(texts, channel.Topic+" "+string(msg.Data[4:]))
+			}
+			assert.Equal(t, []string{"/chatter hello", "/echo hello back", "/chatter world"}, texts)
+		})
+	}
+}
+
+func TestBagToMCAPInvalid(t *testing.T) {
+	_, err := BagToMCAP(context.Background(), bytes.NewReader([]byte("#ROSBAG V1.2\n")), &bytes.Buffer{}, nil)
+	assert.Error(t, err)
+
+	bag := writeTestBag(t, bagCompressionNone)
+	_, err = BagToMCAP(context.Background(), bytes.NewReader(bag[:len(bag)-10]), &bytes.Buffer{}, nil)
+	assert.ErrorContains(t, err, "unexpected EOF")
+
+	unknown := append([]byte(bagMagic), bagMessage(3, 1, 0, "orphan")...)
+	_, err = BagToMCAP(context.Background(), bytes.NewReader(unknown), &bytes.Buffer{}, nil)
+	assert.ErrorContains(t, err, "unknown connection 3")
+}
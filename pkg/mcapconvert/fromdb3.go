@@ -0,0 +1,390 @@
+package mcapconvert
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mcap-utility/internal/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Rosbag2MetadataFile describes the storage files of a rosbag2 directory.
+const Rosbag2MetadataFile = "metadata.yaml"
+
+// rosbag2Metadata holds the fields of metadata.yaml used to read the storage
+// files.
+type rosbag2Metadata struct {
+	Info struct {
+		StorageIdentifier string   `yaml:"storage_identifier"`
+		RelativeFilePaths []string `yaml:"relative_file_paths"`
+		CompressionFormat string   `yaml:"compression_format"`
+		CompressionMode   string   `yaml:"compression_mode"`
+	} `yaml:"rosbag2_bagfile_information"`
+}
+
+// Compression modes of rosbag2, which compress either whole storage files or
+// each message.
+const (
+	rosbag2CompressionFile    = "FILE"
+	rosbag2CompressionMessage = "MESSAGE"
+)
+
+// db3Converter writes the topics and messages of rosbag2 sqlite3 storage files
+// as MCAP records.
+type db3Converter struct {
+	writer  *mcap.Writer
+	report  *BagReport
+	msgPath msgPath
+	decoder *zstd.Decoder
+	// schemas maps message types to their schema ID, 0 for unknown types.
+	schemas map[string]uint16
+	// channels maps the topic and type of the topics to their channel ID.
+	channels  map[string]uint16
+	sequences map[uint16]uint32
+}
+
+// DB3ToMCAP converts a ROS 2 rosbag2 recording stored in sqlite3 to an MCAP
+// file with the ros2 profile written to w. path is either a rosbag2
+// directory, whose metadata.yaml lists the storage files and their
+// compression, or a single .db3 file.
+//
+// Every topic becomes a channel with its serialization format as message
+// encoding and its offered QoS profiles as metadata. Schemas are taken from the
+// message_definitions table of the storage files when they have one, and are
+// otherwise rebuilt as ros2msg from the .msg files found in msgPaths, share
+// directories laid out as <dir>/<package>/msg/<Type>.msg. Topics whose
+// definition is not found are written without a schema and listed in the
+// report. Messages are written in the order of their receive time, which is
+// used as both log and publish time.
+func DB3ToMCAP(ctx context.Context, path string, w io.Writer, writerOpt *mcap.WriterOptions, msgPaths []string) (*BagReport, error) {
+	if writerOpt == nil {
+		var err error
+		writerOpt, err = utils.NewWriterOptions("", 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := []string{path}
+	metadata := &rosbag2Metadata{}
+	isDir, err := utils.IsPathDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		metadata, err = readRosbag2Metadata(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, relativePath := range metadata.Info.RelativeFilePaths {
+			files = append(files, filepath.Join(path, relativePath))
+		}
+	}
+
+	c := &db3Converter{
+		report:    &BagReport{},
+		msgPath:   msgPaths,
+		schemas:   map[string]uint16{},
+		channels:  map[string]uint16{},
+		sequences: map[uint16]uint32{},
+	}
+	if metadata.Info.CompressionMode != "" {
+		if metadata.Info.CompressionFormat != "zstd" {
+			return nil, fmt.Errorf("unsupported compression format: %s", metadata.Info.CompressionFormat)
+		}
+		c.decoder, err = zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer c.decoder.Close()
+	}
+
+	c.writer, err = mcap.NewWriter(w, writerOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new writer: %s", err)
+	}
+	if err := c.writer.WriteHeader(&mcap.Header{Profile: "ros2"}); err != nil {
+		return nil, fmt.Errorf("failed to write header: %s", err)
+	}
+
+	for _, file := range files {
+		switch strings.ToUpper(metadata.Info.CompressionMode) {
+		case "":
+			err = c.file(ctx, file, false)
+		case rosbag2CompressionMessage:
+			err = c.file(ctx, file, true)
+		case rosbag2CompressionFile:
+			err = c.compressedFile(ctx, file)
+		default:
+			err = fmt.Errorf("unsupported compression mode: %s", metadata.Info.CompressionMode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(file), err)
+		}
+	}
+
+	if err := c.writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writer: %s", err)
+	}
+	return c.report, nil
+}
+
+func readRosbag2Metadata(dir string) (*rosbag2Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, Rosbag2MetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	metadata := &rosbag2Metadata{}
+	if err := yaml.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", Rosbag2MetadataFile, err)
+	}
+	if id := metadata.Info.StorageIdentifier; id != "sqlite3" {
+		return nil, fmt.Errorf("unsupported storage: %s", id)
+	}
+	if len(metadata.Info.RelativeFilePaths) == 0 {
+		return nil, fmt.Errorf("no storage files in %s", Rosbag2MetadataFile)
+	}
+	return metadata, nil
+}
+
+// compressedFile converts a storage file compressed as a whole, which is
+// decompressed to a temporary file for sqlite to open it.
+func (c *db3Converter) compressedFile(ctx context.Context, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp("", "*.db3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := c.decoder.Reset(in); err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = io.Copy(tmp, c.decoder)
+	if cErr := tmp.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress: %s", err)
+	}
+	return c.file(ctx, tmp.Name(), false)
+}
+
+// file converts the topics and messages of a sqlite3 storage file, whose
+// message payloads are each compressed when compressed is set.
+func (c *db3Converter) file(ctx context.Context, file string, compressed bool) error {
+	if _, err := os.Stat(file); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+(&url.URL{Path: file}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	definitions, err := readMessageDefinitions(ctx, db)
+	if err != nil {
+		return err
+	}
+	channels, err := c.topics(ctx, db, definitions)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT topic_id, timestamp, data FROM messages ORDER BY timestamp, id")
+	if err != nil {
+		return fmt.Errorf("failed to read messages: %s", err)
+	}
+	defer rows.Close()
+
+	var (
+		topicID   int64
+		timestamp int64
+		data      []byte
+		msg       mcap.Message
+	)
+	for rows.Next() {
+		if err := rows.Scan(&topicID, &timestamp, &data); err != nil {
+			return fmt.Errorf("failed to read message: %s", err)
+		}
+		channelID, ok := channels[topicID]
+		if !ok {
+			return fmt.Errorf("message at time %d on unknown topic %d", timestamp, topicID)
+		}
+		if compressed {
+			data, err = c.decoder.DecodeAll(data, nil)
+			if err != nil {
+				return fmt.Errorf("failed to decompress message at time %d: %s", timestamp, err)
+			}
+		}
+
+		logTime := uint64(timestamp)
+		msg = mcap.Message{
+			ChannelID:   channelID,
+			Sequence:    c.sequences[channelID],
+			LogTime:     logTime,
+			PublishTime: logTime,
+			Data:        data,
+		}
+		if err := c.writer.WriteMessage(&msg); err != nil {
+			return fmt.Errorf("failed to write message: %s", err)
+		}
+		c.sequences[channelID]++
+
+		if c.report.Messages == 0 || logTime < c.report.MessageStartTime {
+			c.report.MessageStartTime = logTime
+		}
+		if logTime > c.report.MessageEndTime {
+			c.report.MessageEndTime = logTime
+		}
+		c.report.Messages++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read messages: %s", err)
+	}
+	return nil
+}
+
+// messageDefinition is a row of the message_definitions table, written by
+// rosbag2 since ROS 2 Iron.
+type messageDefinition struct {
+	encoding   string
+	definition string
+}
+
+// readMessageDefinitions returns the message definitions of the storage file
+// by type, empty when it has no message_definitions table.
+func readMessageDefinitions(ctx context.Context, db *sql.DB) (map[string]messageDefinition, error) {
+	definitions := map[string]messageDefinition{}
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'message_definitions'").Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tables: %s", err)
+	}
+	if count == 0 {
+		return definitions, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT topic_type, encoding, encoded_message_definition FROM message_definitions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message definitions: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageType string
+		var definition messageDefinition
+		if err := rows.Scan(&messageType, &definition.encoding, &definition.definition); err != nil {
+			return nil, fmt.Errorf("failed to read message definition: %s", err)
+		}
+		// Types without a known definition are stored with an unknown encoding.
+		if definition.encoding == "ros2msg" || definition.encoding == "ros2idl" {
+			definitions[messageType] = definition
+		}
+	}
+	return definitions, rows.Err()
+}
+
+// topics writes the schemas and channels of the topics of the storage file
+// not written yet, and returns the channel IDs by topic ID.
+func (c *db3Converter) topics(ctx context.Context, db *sql.DB, definitions map[string]messageDefinition) (map[int64]uint16, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name, type, serialization_format, offered_qos_profiles FROM topics ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topics: %s", err)
+	}
+	defer rows.Close()
+
+	channels := map[int64]uint16{}
+	for rows.Next() {
+		var (
+			id                  int64
+			topic               string
+			messageType         string
+			serializationFormat string
+			qos                 string
+		)
+		if err := rows.Scan(&id, &topic, &messageType, &serializationFormat, &qos); err != nil {
+			return nil, fmt.Errorf("failed to read topic: %s", err)
+		}
+
+		key := topic + "\x00" + messageType
+		channelID, ok := c.channels[key]
+		if !ok {
+			schemaID, err := c.schema(messageType, definitions)
+			if err != nil {
+				return nil, err
+			}
+			if len(c.channels) >= 0xffff {
+				return nil, fmt.Errorf("too many topics for MCAP channel IDs")
+			}
+			channelID = uint16(len(c.channels) + 1)
+			err = c.writer.WriteChannel(&mcap.Channel{
+				ID:              channelID,
+				SchemaID:        schemaID,
+				Topic:           topic,
+				MessageEncoding: serializationFormat,
+				Metadata:        map[string]string{"offered_qos_profiles": qos},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to write channel %s: %s", topic, err)
+			}
+			c.channels[key] = channelID
+			c.report.Channels++
+		}
+		channels[id] = channelID
+	}
+	return channels, rows.Err()
+}
+
+// schema writes the schema of messageType the first time it is used and
+// returns its ID, or 0 when its definition is not found.
+func (c *db3Converter) schema(messageType string, definitions map[string]messageDefinition) (uint16, error) {
+	if schemaID, ok := c.schemas[messageType]; ok {
+		return schemaID, nil
+	}
+
+	definition, ok := definitions[messageType]
+	if !ok {
+		data, err := c.msgPath.schema(messageType)
+		if errors.Is(err, errNoDefinition) {
+			c.schemas[messageType] = 0
+			c.report.UnknownTypes = append(c.report.UnknownTypes, messageType)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		definition = messageDefinition{encoding: "ros2msg", definition: string(data)}
+	}
+
+	schemaID := uint16(c.report.Schemas + 1)
+	err := c.writer.WriteSchema(&mcap.Schema{
+		ID:       schemaID,
+		Name:     messageType,
+		Encoding: definition.encoding,
+		Data:     []byte(definition.definition),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write schema %s: %s", messageType, err)
+	}
+	c.schemas[messageType] = schemaID
+	c.report.Schemas++
+	return schemaID, nil
+}
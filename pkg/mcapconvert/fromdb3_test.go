@@ -0,0 +1,262 @@
+package mcapconvert
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"mcap-utility/pkg/mcapdecode"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const db3Schema = `
+CREATE TABLE topics(id INTEGER PRIMARY KEY, name TEXT NOT NULL, type TEXT NOT NULL,
+	serialization_format TEXT NOT NULL, offered_qos_profiles TEXT NOT NULL);
+CREATE TABLE messages(id INTEGER PRIMARY KEY, topic_id INTEGER NOT NULL, timestamp INTEGER NOT NULL, data BLOB NOT NULL);
+`
+
+const messageDefinitionsSchema = `
+CREATE TABLE message_definitions(id INTEGER PRIMARY KEY, topic_type TEXT NOT NULL, encoding TEXT NOT NULL,
+	encoded_message_definition TEXT NOT NULL, type_description_hash TEXT NOT NULL);
+`
+
+type db3Message struct {
+	topicID   int
+	timestamp int64
+	data      []byte
+}
+
+// cdrString serializes a std_msgs/msg/String as little endian CDR.
+func cdrString(text string) []byte {
+	data := []byte{0, 1, 0, 0}
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(text)+1))
+	data = append(data, text...)
+	return append(data, 0)
+}
+
+// cdrStamped serializes a my_msgs/msg/Stamped, a builtin_interfaces/Time
+// followed by a my_msgs/Point of two float64.
+func cdrStamped(sec int32, x, y float64) []byte {
+	data := []byte{0, 1, 0, 0}
+	data = binary.LittleEndian.AppendUint32(data, uint32(sec))
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(x))
+	return binary.LittleEndian.AppendUint64(data, math.Float64bits(y))
+}
+
+func writeDB3(t *testing.T, path string, definitions bool, topics [][3]string, messages []db3Message) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(db3Schema)
+	assert.NoError(t, err)
+	for i, topic := range topics {
+		_, err := db.Exec("INSERT INTO topics VALUES (?, ?, ?, 'cdr', ?)", i+1, topic[0], topic[1], "- history: 3")
+		assert.NoError(t, err)
+		if definitions {
+			if i == 0 {
+				_, err = db.Exec(messageDefinitionsSchema)
+				assert.NoError(t, err)
+			}
+			_, err = db.Exec("INSERT INTO message_definitions VALUES (?, ?, 'ros2msg', ?, '')", i+1, topic[1], topic[2])
+			assert.NoError(t, err)
+		}
+	}
+	for _, msg := range messages {
+		_, err := db.Exec("INSERT INTO messages (topic_id, timestamp, data) VALUES (?, ?, ?)", msg.topicID, msg.timestamp, msg.data)
+		assert.NoError(t, err)
+	}
+}
+
+// writeMsgPath writes a share directory holding the definitions of
+// std_msgs/String, my_msgs/Stamped and their dependencies.
+func writeMsgPath(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"std_msgs/msg/String.msg":           "string data\n",
+		"my_msgs/msg/Stamped.msg":           "builtin_interfaces/Time stamp # receive time\nPoint point\n",
+		"my_msgs/msg/Point.msg":             "float64 x\nfloat64 y\nint32 ORIGIN=0\n",
+		"builtin_interfaces/msg/Time.msg":   "int32 sec\nuint32 nanosec\n",
+		"builtin_interfaces/msg/Unused.msg": "int32 unused\n",
+	} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(text), 0o644))
+	}
+	return dir
+}
+
+// readDecoded reads the messages of an MCAP file decoded to JSON, prefixed
+// with their topic.
+func readDecoded(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	reader, err := mcap.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	it, err := reader.Messages(mcap.InOrder(mcap.FileOrder))
+	assert.NoError(t, err)
+	decoder := mcapdecode.NewDecoder()
+	decoded := []string{}
+	for {
+		schema, channel, msg, err := it.NextInto(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if schema == nil {
+			decoded = append(decoded, channel.Topic+" <no schema>")
+			continue
+		}
+		value, err := decoder.Decode(schema, channel, msg.Data)
+		assert.NoError(t, err)
+		text, err := json.Marshal(value)
+		assert.NoError(t, err)
+		decoded = append(decoded, channel.Topic+" "+string(text))
+	}
+	return decoded
+}
+
+func TestDB3ToMCAPMsgPath(t *testing.T) {
+	dir := t.TempDir()
+	topics := [][3]string{
+		{"/chatter", "std_msgs/msg/String"},
+		{"/stamped", "my_msgs/msg/Stamped"},
+		{"/other", "other_msgs/msg/Unknown"},
+	}
+	writeDB3(t, filepath.Join(dir, "rec_0.db3"), false, topics, []db3Message{
+		{1, 200, cdrString("second")},
+		{1, 100, cdrString("first")},
+		{2, 150, cdrStamped(7, 1.5, -2)},
+	})
+	writeDB3(t, filepath.Join(dir, "rec_1.db3"), false, topics[:1], []db3Message{
+		{1, 300, cdrString("third")},
+	})
+	writeDB3(t, filepath.Join(dir, "rec_2.db3"), false, topics[2:], []db3Message{
+		{1, 400, []byte{0, 1, 0, 0}},
+	})
+	metadata := `rosbag2_bagfile_information:
+  version: 5
+  storage_identifier: sqlite3
+  relative_file_paths:
+    - rec_0.db3
+    - rec_1.db3
+    - rec_2.db3
+  compression_format: ""
+  compression_mode: ""
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, Rosbag2MetadataFile), []byte(metadata), 0o644))
+
+	out := &bytes.Buffer{}
+	report, err := DB3ToMCAP(context.Background(), dir, out, nil, []string{t.TempDir(), writeMsgPath(t)})
+	assert.NoError(t, err)
+	assert.Equal(t, &BagReport{
+		Schemas:          2,
+		Channels:         3,
+		Messages:         5,
+		MessageStartTime: 100,
+		MessageEndTime:   400,
+		UnknownTypes:     []string{"other_msgs/msg/Unknown"},
+	}, report)
+
+	assert.Equal(t, []string{
+		`/chatter {"data":"first"}`,
+		`/stamped {"stamp":{"sec":7,"nanosec":0},"point":{"x":1.5,"y":-2}}`,
+		`/chatter {"data":"second"}`,
+		`/chatter {"data":"third"}`,
+		`/other <no schema>`,
+	}, readDecoded(t, out.Bytes()))
+
+	reader, err := mcap.NewReader(bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, "ros2", reader.Header().Profile)
+	mcapInfo, err := reader.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, "ros2msg", mcapInfo.Schemas[2].Encoding)
+	assert.Equal(t, "cdr", mcapInfo.Channels[1].MessageEncoding)
+	assert.Equal(t, map[string]string{"offered_qos_profiles": "- history: 3"}, mcapInfo.Channels[1].Metadata)
+	assert.Equal(t, uint64(1), mcapInfo.Statistics.ChannelMessageCounts[2])
+}
+
+func TestDB3ToMCAPMessageDefinitions(t *testing.T) {
+	dir := t.TempDir()
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	writeDB3(t, filepath.Join(dir, "rec_0.db3"), true, [][3]string{
+		{"/chatter", "std_msgs/msg/String", "string data"},
+	}, []db3Message{
+		{1, 100, encoder.EncodeAll(cdrString("compressed"), nil)},
+	})
+	metadata := `rosbag2_bagfile_information:
+  storage_identifier: sqlite3
+  relative_file_paths: [rec_0.db3]
+  compression_format: zstd
+  compression_mode: MESSAGE
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, Rosbag2MetadataFile), []byte(metadata), 0o644))
+
+	out := &bytes.Buffer{}
+	report, err := DB3ToMCAP(context.Background(), dir, out, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, report.UnknownTypes)
+	assert.Equal(t, []string{`/chatter {"data":"compressed"}`}, readDecoded(t, out.Bytes()))
+}
+
+func TestDB3ToMCAPFileCompression(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "rec_0.db3")
+	writeDB3(t, path, true, [][3]string{
+		{"/chatter", "std_msgs/msg/String", "string data"},
+	}, []db3Message{
+		{1, 100, cdrString("whole file")},
+	})
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rec_0.db3.zstd"), encoder.EncodeAll(data, nil), 0o644))
+	metadata := `rosbag2_bagfile_information:
+  storage_identifier: sqlite3
+  relative_file_paths: [rec_0.db3.zstd]
+  compression_format: zstd
+  compression_mode: FILE
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, Rosbag2MetadataFile), []byte(metadata), 0o644))
+
+	out := &bytes.Buffer{}
+	_, err = DB3ToMCAP(context.Background(), dir, out, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`/chatter {"data":"whole file"}`}, readDecoded(t, out.Bytes()))
+}
+
+func TestDB3ToMCAPSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.db3")
+	writeDB3(t, path, false, [][3]string{{"/chatter", "std_msgs/msg/String"}}, []db3Message{
+		{1, 100, cdrString("alone")},
+	})
+
+	out := &bytes.Buffer{}
+	report, err := DB3ToMCAP(context.Background(), path, out, nil, []string{writeMsgPath(t)})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), report.Messages)
+	assert.Equal(t, []string{`/chatter {"data":"alone"}`}, readDecoded(t, out.Bytes()))
+
+	_, err = DB3ToMCAP(context.Background(), filepath.Dir(path), &bytes.Buffer{}, nil, nil)
+	assert.Error(t, err)
+}
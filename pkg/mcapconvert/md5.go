@@ -0,0 +1,64 @@
+package mcapconvert
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"mcap-utility/internal/ros"
+	"strings"
+)
+
+// ros1MD5 computes the md5sum of the message type messageType from its
+// ros1msg schema, the way genmsg does: the md5 of its constants, then its
+// fields, with the md5sum of their type in place of message types.
+func ros1MD5(messageType string, definition string) (string, error) {
+	defs, err := ros.ParseDefinitions(messageType, definition)
+	if err != nil {
+		return "", err
+	}
+
+	md5s := map[string]string{}
+	var compute func(def *ros.MessageDefinition, depth int) (string, error)
+	compute = func(def *ros.MessageDefinition, depth int) (string, error) {
+		if sum, ok := md5s[def.Name]; ok {
+			return sum, nil
+		}
+		if depth > len(defs.Types) {
+			return "", fmt.Errorf("recursive definition of %s", def.Name)
+		}
+
+		text := &strings.Builder{}
+		for _, constant := range def.Constants {
+			fmt.Fprintf(text, "%s %s=%s\n", constant.Type, constant.Name, constant.Value)
+		}
+		for _, field := range def.Fields {
+			if !field.IsComplex {
+				fmt.Fprintf(text, "%s %s\n", ros1FieldType(field), field.Name)
+				continue
+			}
+			sum, err := compute(defs.Types[field.Type], depth+1)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(text, "%s %s\n", sum, field.Name)
+		}
+
+		digest := md5.Sum([]byte(strings.TrimSpace(text.String())))
+		md5s[def.Name] = hex.EncodeToString(digest[:])
+		return md5s[def.Name], nil
+	}
+	return compute(defs.Root, 0)
+}
+
+// ros1FieldType returns the type of a primitive field as written in its
+// definition, with its array suffix.
+func ros1FieldType(field ros.Field) string {
+	switch {
+	case !field.IsArray:
+		return field.Type
+	case field.ArrayLength > 0:
+		return fmt.Sprintf("%s[%d]", field.Type, field.ArrayLength)
+	default:
+		return field.Type + "[]"
+	}
+}
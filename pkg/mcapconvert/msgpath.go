@@ -0,0 +1,103 @@
+package mcapconvert
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mcap-utility/internal/ros"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errNoDefinition is returned when the .msg file of a type is not found.
+var errNoDefinition = errors.New("no message definition")
+
+// definitionSeparator separates the definitions of the dependencies in a
+// ros1msg or ros2msg schema.
+var definitionSeparator = strings.Repeat("=", 80)
+
+// msgPath finds the .msg files of ROS 2 message types in share directories,
+// laid out as <dir>/<package>/msg/<Type>.msg.
+type msgPath []string
+
+// schema returns the ros2msg schema of messageType, e.g. std_msgs/msg/String:
+// its .msg file followed by those of the types it depends on, each introduced
+// by a separator line and "MSG: pkg/Type".
+func (p msgPath) schema(messageType string) ([]byte, error) {
+	root := ros.NormalizeTypeName(messageType)
+	text, err := p.read(root)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &strings.Builder{}
+	schema.WriteString(text)
+	seen := map[string]bool{root: true}
+	queue := []struct{ name, text string }{{root, text}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, dependency := range msgDependencies(current.name, current.text) {
+			if seen[dependency] {
+				continue
+			}
+			seen[dependency] = true
+			text, err := p.read(dependency)
+			if err != nil {
+				return nil, fmt.Errorf("%s depends on %s: %w", current.name, dependency, err)
+			}
+			fmt.Fprintf(schema, "\n%s\nMSG: %s\n%s", definitionSeparator, dependency, text)
+			queue = append(queue, struct{ name, text string }{dependency, text})
+		}
+	}
+	return []byte(schema.String()), nil
+}
+
+// read returns the .msg file of the type pkg/Type from the first directory
+// holding it.
+func (p msgPath) read(name string) (string, error) {
+	pkg, typ, ok := strings.Cut(name, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid message type %s", name)
+	}
+	for _, dir := range p {
+		data, err := os.ReadFile(filepath.Join(dir, pkg, "msg", typ+".msg"))
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: no %s/msg/%s.msg file in the message paths", errNoDefinition, pkg, typ)
+}
+
+// msgDependencies returns the message types, as pkg/Type, of the fields of
+// the .msg definition text of the type name.
+func msgDependencies(name, text string) []string {
+	var dependencies []string
+	for _, line := range strings.Split(text, "\n") {
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) < 2 || strings.Contains(tokens[1], "=") {
+			continue
+		}
+		typ := tokens[0]
+		if idx := strings.IndexAny(typ, "[<"); idx >= 0 {
+			typ = typ[:idx]
+		}
+		if ros.IsPrimitive(typ) {
+			continue
+		}
+		typ = ros.NormalizeTypeName(typ)
+		if !strings.Contains(typ, "/") {
+			pkg, _, _ := strings.Cut(name, "/")
+			typ = pkg + "/" + typ
+		}
+		dependencies = append(dependencies, typ)
+	}
+	return dependencies
+}
@@ -0,0 +1,347 @@
+package mcapconvert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/pierrec/lz4/v4"
+	"io"
+	"maps"
+	"mcap-utility/internal/utils"
+	"slices"
+)
+
+// BagCompressions lists the supported compressions of ROS 1 bag chunks.
+var BagCompressions = []string{bagCompressionNone, bagCompressionBZ2, bagCompressionLZ4}
+
+// DefaultBagChunkSize is the chunk size of rosbag record.
+const DefaultBagChunkSize = 768 * 1024
+
+// bagHeaderLength is the length of the bag header record, padded so it can be
+// rewritten in place once the index section is written.
+const bagHeaderLength = 4096
+
+// BagOptions configures the ROS 1 bags written by MCAPToBag.
+type BagOptions struct {
+	// Compression of the chunks, one of BagCompressions. Empty is none.
+	Compression string
+	// ChunkSize is the uncompressed size from which a chunk is written.
+	// Zero uses DefaultBagChunkSize.
+	ChunkSize int
+}
+
+// bagWriterConnection is a connection of the bag being written.
+type bagWriterConnection struct {
+	id     uint32
+	topic  string
+	header []byte
+	// written is set once the connection record is written in a chunk.
+	written bool
+}
+
+// bagChunkInfo describes a chunk written to the bag.
+type bagChunkInfo struct {
+	offset    int64
+	startTime uint64
+	endTime   uint64
+	counts    map[uint32]uint32
+}
+
+// bagWriter writes ROS 1 bag v2.0 files.
+type bagWriter struct {
+	w           io.WriteSeeker
+	offset      int64
+	opts        BagOptions
+	connections []*bagWriterConnection
+	// chunk holds the uncompressed records of the current chunk, and
+	// indexes the index data entries of each of its connections.
+	chunk      []byte
+	indexes    map[uint32][]byte
+	info       *bagChunkInfo
+	chunkInfos []*bagChunkInfo
+	compressed bytes.Buffer
+}
+
+func newBagWriter(w io.WriteSeeker, opts BagOptions) (*bagWriter, error) {
+	bw := &bagWriter{w: w, opts: opts, indexes: map[uint32][]byte{}}
+	if _, err := io.WriteString(w, bagMagic); err != nil {
+		return nil, err
+	}
+	bw.offset = int64(len(bagMagic))
+	if err := bw.writeBagHeader(0); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+func (bw *bagWriter) write(data []byte) error {
+	n, err := bw.w.Write(data)
+	bw.offset += int64(n)
+	return err
+}
+
+// writeBagHeader writes the bag header record pointing to the index section
+// at indexPos.
+func (bw *bagWriter) writeBagHeader(indexPos int64) error {
+	header := appendBagField(nil, "op", []byte{bagOpBagHeader})
+	header = appendBagField(header, "index_pos", binary.LittleEndian.AppendUint64(nil, uint64(indexPos)))
+	header = appendBagField(header, "conn_count", binary.LittleEndian.AppendUint32(nil, uint32(len(bw.connections))))
+	header = appendBagField(header, "chunk_count", binary.LittleEndian.AppendUint32(nil, uint32(len(bw.chunkInfos))))
+	padding := bytes.Repeat([]byte(" "), bagHeaderLength-8-len(header))
+	return bw.write(appendBagRecord(nil, header, padding))
+}
+
+// addConnection registers a connection, whose record is written in the chunk
+// of its first message and in the index section.
+func (bw *bagWriter) addConnection(topic string, header []byte) uint32 {
+	conn := &bagWriterConnection{id: uint32(len(bw.connections)), topic: topic, header: header}
+	bw.connections = append(bw.connections, conn)
+	return conn.id
+}
+
+func (bw *bagWriter) connectionRecord(conn *bagWriterConnection) []byte {
+	header := appendBagField(nil, "op", []byte{bagOpConnection})
+	header = appendBagField(header, "conn", binary.LittleEndian.AppendUint32(nil, conn.id))
+	header = appendBagField(header, "topic", []byte(conn.topic))
+	return appendBagRecord(nil, header, conn.header)
+}
+
+func (bw *bagWriter) writeMessage(connID uint32, logTime uint64, data []byte) error {
+	conn := bw.connections[connID]
+	if !conn.written {
+		bw.chunk = append(bw.chunk, bw.connectionRecord(conn)...)
+		conn.written = true
+	}
+
+	if bw.info == nil {
+		bw.info = &bagChunkInfo{startTime: logTime, endTime: logTime, counts: map[uint32]uint32{}}
+	}
+	bw.info.startTime = min(bw.info.startTime, logTime)
+	bw.info.endTime = max(bw.info.endTime, logTime)
+	bw.info.counts[connID]++
+
+	index := append(bw.indexes[connID], bagTime(logTime)...)
+	bw.indexes[connID] = binary.LittleEndian.AppendUint32(index, uint32(len(bw.chunk)))
+
+	header := appendBagField(nil, "op", []byte{bagOpMessageData})
+	header = appendBagField(header, "conn", binary.LittleEndian.AppendUint32(nil, connID))
+	header = appendBagField(header, "time", bagTime(logTime))
+	bw.chunk = appendBagRecord(bw.chunk, header, data)
+
+	chunkSize := bw.opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultBagChunkSize
+	}
+	if len(bw.chunk) >= chunkSize {
+		return bw.flushChunk()
+	}
+	return nil
+}
+
+// flushChunk writes the current chunk followed by the index data records of
+// its connections.
+func (bw *bagWriter) flushChunk() error {
+	if bw.info == nil {
+		return nil
+	}
+
+	compression := bw.opts.Compression
+	if compression == "" {
+		compression = bagCompressionNone
+	}
+	data := bw.chunk
+	if compression != bagCompressionNone {
+		bw.compressed.Reset()
+		var cw io.WriteCloser
+		switch compression {
+		case bagCompressionBZ2:
+			var err error
+			cw, err = bzip2.NewWriter(&bw.compressed, nil)
+			if err != nil {
+				return err
+			}
+		case bagCompressionLZ4:
+			cw = lz4.NewWriter(&bw.compressed)
+		default:
+			return fmt.Errorf("unsupported chunk compression: %s", compression)
+		}
+		if _, err := cw.Write(bw.chunk); err != nil {
+			return fmt.Errorf("failed to compress chunk: %s", err)
+		}
+		if err := cw.Close(); err != nil {
+			return fmt.Errorf("failed to compress chunk: %s", err)
+		}
+		data = bw.compressed.Bytes()
+	}
+
+	bw.info.offset = bw.offset
+	header := appendBagField(nil, "op", []byte{bagOpChunk})
+	header = appendBagField(header, "compression", []byte(compression))
+	header = appendBagField(header, "size", binary.LittleEndian.AppendUint32(nil, uint32(len(bw.chunk))))
+	if err := bw.write(appendBagRecord(nil, header, data)); err != nil {
+		return err
+	}
+
+	for _, connID := range slices.Sorted(maps.Keys(bw.indexes)) {
+		header := appendBagField(nil, "op", []byte{bagOpIndexData})
+		header = appendBagField(header, "ver", binary.LittleEndian.AppendUint32(nil, 1))
+		header = appendBagField(header, "conn", binary.LittleEndian.AppendUint32(nil, connID))
+		header = appendBagField(header, "count", binary.LittleEndian.AppendUint32(nil, bw.info.counts[connID]))
+		if err := bw.write(appendBagRecord(nil, header, bw.indexes[connID])); err != nil {
+			return err
+		}
+	}
+
+	bw.chunkInfos = append(bw.chunkInfos, bw.info)
+	bw.info = nil
+	bw.chunk = bw.chunk[:0]
+	clear(bw.indexes)
+	return nil
+}
+
+// close writes the last chunk and the index section, then points the bag
+// header to it.
+func (bw *bagWriter) close() error {
+	if err := bw.flushChunk(); err != nil {
+		return err
+	}
+
+	indexPos := bw.offset
+	for _, conn := range bw.connections {
+		if err := bw.write(bw.connectionRecord(conn)); err != nil {
+			return err
+		}
+	}
+	for _, info := range bw.chunkInfos {
+		header := appendBagField(nil, "op", []byte{bagOpChunkInfo})
+		header = appendBagField(header, "ver", binary.LittleEndian.AppendUint32(nil, 1))
+		header = appendBagField(header, "chunk_pos", binary.LittleEndian.AppendUint64(nil, uint64(info.offset)))
+		header = appendBagField(header, "start_time", bagTime(info.startTime))
+		header = appendBagField(header, "end_time", bagTime(info.endTime))
+		header = appendBagField(header, "count", binary.LittleEndian.AppendUint32(nil, uint32(len(info.counts))))
+		var data []byte
+		for _, connID := range slices.Sorted(maps.Keys(info.counts)) {
+			data = binary.LittleEndian.AppendUint32(data, connID)
+			data = binary.LittleEndian.AppendUint32(data, info.counts[connID])
+		}
+		if err := bw.write(appendBagRecord(nil, header, data)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := bw.w.Seek(int64(len(bagMagic)), io.SeekStart); err != nil {
+		return err
+	}
+	if err := bw.writeBagHeader(indexPos); err != nil {
+		return err
+	}
+	_, err := bw.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// MCAPToBag converts the messages of the MCAP stream r to a ROS 1 bag v2.0
+// file written to w, in log time order. Every channel with the ros1 message
+// encoding and a ros1msg schema becomes a connection, whose message
+// definition is the schema and whose md5sum is computed from it. The callerid
+// and latching channel metadata are kept in the connection header. Messages
+// of other channels cannot be read by ROS 1 and are left out, their topics
+// listed in the report.
+func MCAPToBag(ctx context.Context, r io.ReadSeeker, w io.WriteSeeker, opts BagOptions) (*BagReport, error) {
+	if opts.Compression != "" && !slices.Contains(BagCompressions, opts.Compression) {
+		return nil, fmt.Errorf("invalid bag compression: %s", opts.Compression)
+	}
+
+	reader, msgs, _, err := utils.ReadMessages(r)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	bw, err := newBagWriter(w, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write bag header: %s", err)
+	}
+
+	report := &BagReport{}
+	// connections maps channel IDs to connection IDs, or -1 for skipped
+	// channels.
+	connections := map[uint16]int64{}
+	schemas := map[uint16]bool{}
+	msg := &mcap.Message{}
+	for count := 0; ; count++ {
+		if count%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		schema, channel, _, err := msgs.NextInto(msg)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		connID, ok := connections[channel.ID]
+		if !ok {
+			connID = -1
+			if channel.MessageEncoding == "ros1" && schema != nil && schema.Encoding == "ros1msg" {
+				header, err := connectionHeader(schema, channel)
+				if err != nil {
+					return nil, fmt.Errorf("channel %s: %s", channel.Topic, err)
+				}
+				connID = int64(bw.addConnection(channel.Topic, header))
+				report.Channels++
+				if !schemas[schema.ID] {
+					schemas[schema.ID] = true
+					report.Schemas++
+				}
+			} else if !slices.Contains(report.SkippedTopics, channel.Topic) {
+				report.SkippedTopics = append(report.SkippedTopics, channel.Topic)
+			}
+			connections[channel.ID] = connID
+		}
+		if connID < 0 {
+			continue
+		}
+
+		if err := bw.writeMessage(uint32(connID), msg.LogTime, msg.Data); err != nil {
+			return nil, fmt.Errorf("failed to write message: %s", err)
+		}
+		if report.Messages == 0 || msg.LogTime < report.MessageStartTime {
+			report.MessageStartTime = msg.LogTime
+		}
+		report.MessageEndTime = max(report.MessageEndTime, msg.LogTime)
+		report.Messages++
+	}
+
+	if err := bw.close(); err != nil {
+		return nil, fmt.Errorf("failed to write index: %s", err)
+	}
+	report.Chunks = len(bw.chunkInfos)
+	return report, nil
+}
+
+// connectionHeader returns the connection header of a ros1 channel.
+func connectionHeader(schema *mcap.Schema, channel *mcap.Channel) ([]byte, error) {
+	md5sum, err := ros1MD5(schema.Name, string(schema.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute md5sum of %s: %s", schema.Name, err)
+	}
+
+	header := appendBagField(nil, "topic", []byte(channel.Topic))
+	header = appendBagField(header, "type", []byte(schema.Name))
+	header = appendBagField(header, "md5sum", []byte(md5sum))
+	header = appendBagField(header, "message_definition", schema.Data)
+	for _, name := range []string{"callerid", "latching"} {
+		if value, ok := channel.Metadata[name]; ok {
+			header = appendBagField(header, name, []byte(value))
+		}
+	}
+	return header, nil
+}
@@ -0,0 +1,205 @@
+package mcapconvert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var separator = strings.Repeat("=", 80)
+
+const pointDefinition = "float64 x\nfloat64 y\nfloat64 z\n"
+
+const headerDefinition = "uint32 seq\ntime stamp\nstring frame_id\n"
+
+var pointStampedDefinition = "# A point with a frame\nHeader header\ngeometry_msgs/Point point\n" +
+	separator + "\nMSG: std_msgs/Header\n" + headerDefinition +
+	separator + "\nMSG: geometry_msgs/Point\n" + pointDefinition
+
+func TestROS1MD5(t *testing.T) {
+	for _, test := range []struct {
+		messageType string
+		definition  string
+		md5sum      string
+	}{
+		{"std_msgs/String", stringDefinition, "992ce8a1687cec8c8bd883ec73ca41d1"},
+		{"std_msgs/Header", headerDefinition, "2176decaecbce78abc3b96ef049fabed"},
+		{"geometry_msgs/Point", pointDefinition, "4a842b65f413084dc2b10fb484ea7f17"},
+		{"geometry_msgs/PointStamped", pointStampedDefinition, "c63aecb41bfdfd6b7e1fac37c7cbe7bf"},
+	} {
+		md5sum, err := ros1MD5(test.messageType, test.definition)
+		assert.NoError(t, err)
+		assert.Equal(t, test.md5sum, md5sum, test.messageType)
+	}
+
+	_, err := ros1MD5("my_msgs/Stamped", "Header header\nPoint point\n")
+	assert.ErrorContains(t, err, "unable to resolve type Header")
+}
+
+// writeROS1MCAP writes an MCAP file holding std_msgs/String messages on
+// /chatter, geometry_msgs/PointStamped messages on /stamped, and a cdr
+// channel that cannot be written to a bag.
+func writeROS1MCAP(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, w.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte(stringDefinition)}))
+	assert.NoError(t, w.WriteSchema(&mcap.Schema{ID: 2, Name: "geometry_msgs/PointStamped", Encoding: "ros1msg", Data: []byte(pointStampedDefinition)}))
+	assert.NoError(t, w.WriteSchema(&mcap.Schema{ID: 3, Name: "std_msgs/msg/String", Encoding: "ros2msg", Data: []byte(stringDefinition)}))
+	assert.NoError(t, w.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/chatter", MessageEncoding: "ros1",
+		Metadata: map[string]string{"callerid": "/talker", "latching": "1"}}))
+	assert.NoError(t, w.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 2, Topic: "/stamped", MessageEncoding: "ros1"}))
+	assert.NoError(t, w.WriteChannel(&mcap.Channel{ID: 3, SchemaID: 3, Topic: "/other", MessageEncoding: "cdr"}))
+
+	stamped := make([]byte, 12)
+	stamped = binary.LittleEndian.AppendUint32(stamped, 0)
+	stamped = append(stamped, make([]byte, 24)...)
+	for i, text := range []string{"hello", "world", "again"} {
+		data := binary.LittleEndian.AppendUint32(nil, uint32(len(text)))
+		logTime := uint64(i+1) * 1e9
+		assert.NoError(t, w.WriteMessage(&mcap.Message{ChannelID: 1, LogTime: logTime, Data: append(data, text...)}))
+		assert.NoError(t, w.WriteMessage(&mcap.Message{ChannelID: 2, LogTime: logTime + 500, Data: stamped}))
+		assert.NoError(t, w.WriteMessage(&mcap.Message{ChannelID: 3, LogTime: logTime, Data: cdrString(text)}))
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// readBagIndex reads the connection and chunk info records of the index
+// section of a bag.
+func readBagIndex(t *testing.T, bag []byte) (connections []bagHeader, chunkInfos []bagHeader) {
+	t.Helper()
+
+	br := newBagRecordReader(bytes.NewReader(bag[len(bagMagic):]))
+	assert.NoError(t, br.next())
+	assert.Equal(t, bagHeaderLength, 8+len(br.buf))
+	indexPos := binary.LittleEndian.Uint64(br.header["index_pos"])
+	connCount, err := br.header.uint32("conn_count")
+	assert.NoError(t, err)
+	chunkCount, err := br.header.uint32("chunk_count")
+	assert.NoError(t, err)
+
+	br = newBagRecordReader(bytes.NewReader(bag[indexPos:]))
+	for {
+		err := br.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		op, err := br.header.op()
+		assert.NoError(t, err)
+		header := bagHeader{}
+		for name, value := range br.header {
+			header[name] = bytes.Clone(value)
+		}
+		switch op {
+		case bagOpConnection:
+			connections = append(connections, header)
+		case bagOpChunkInfo:
+			chunkInfos = append(chunkInfos, header)
+		default:
+			t.Fatalf("unexpected op %d in index section", op)
+		}
+	}
+	assert.Len(t, connections, int(connCount))
+	assert.Len(t, chunkInfos, int(chunkCount))
+	return connections, chunkInfos
+}
+
+func TestMCAPToBag(t *testing.T) {
+	input := writeROS1MCAP(t)
+	for _, compression := range BagCompressions {
+		t.Run(compression, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.bag")
+			file, err := os.Create(path)
+			assert.NoError(t, err)
+			report, err := MCAPToBag(context.Background(), bytes.NewReader(input), file,
+				BagOptions{Compression: compression, ChunkSize: 1})
+			assert.NoError(t, file.Close())
+			assert.NoError(t, err)
+			assert.Equal(t, &BagReport{
+				Chunks:           6,
+				Schemas:          2,
+				Channels:         2,
+				Messages:         6,
+				MessageStartTime: 1e9,
+				MessageEndTime:   3e9 + 500,
+				SkippedTopics:    []string{"/other"},
+			}, report)
+
+			bag, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			connections, chunkInfos := readBagIndex(t, bag)
+			assert.Equal(t, "/chatter", string(connections[0]["topic"]))
+			assert.Equal(t, "/stamped", string(connections[1]["topic"]))
+			assert.Equal(t, bagTime(1e9), []byte(chunkInfos[0]["start_time"]))
+			assert.Equal(t, bagTime(1e9+500), []byte(chunkInfos[1]["end_time"]))
+			count, err := chunkInfos[1].uint32("count")
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(1), count)
+
+			out := &bytes.Buffer{}
+			bagReport, err := BagToMCAP(context.Background(), bytes.NewReader(bag), out, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(6), bagReport.Messages)
+			assert.Equal(t, 6, bagReport.Chunks)
+
+			reader, err := mcap.NewReader(bytes.NewReader(out.Bytes()))
+			assert.NoError(t, err)
+			defer reader.Close()
+			mcapInfo, err := reader.Info()
+			assert.NoError(t, err)
+			assert.Equal(t, pointStampedDefinition, string(mcapInfo.Schemas[2].Data))
+			assert.Equal(t, map[string]string{
+				"md5sum":   "992ce8a1687cec8c8bd883ec73ca41d1",
+				"callerid": "/talker",
+				"latching": "1",
+			}, mcapInfo.Channels[1].Metadata)
+			assert.Equal(t, map[string]string{
+				"md5sum": "c63aecb41bfdfd6b7e1fac37c7cbe7bf",
+			}, mcapInfo.Channels[2].Metadata)
+
+			it, err := reader.Messages(mcap.InOrder(mcap.FileOrder))
+			assert.NoError(t, err)
+			messages := []string{}
+			for {
+				_, channel, msg, err := it.NextInto(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.NoError(t, err)
+				if channel.Topic == "/chatter" {
+					messages = append(messages, channel.Topic+" "+string(msg.Data[4:]))
+				} else {
+					messages = append(messages, channel.Topic)
+				}
+			}
+			assert.Equal(t, []string{
+				"/chatter hello", "/stamped",
+				"/chatter world", "/stamped",
+				"/chatter again", "/stamped",
+			}, messages)
+		})
+	}
+}
+
+func TestMCAPToBagInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.bag")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	_, err = MCAPToBag(context.Background(), bytes.NewReader(writeROS1MCAP(t)), file, BagOptions{Compression: "zstd"})
+	assert.ErrorContains(t, err, "invalid bag compression")
+}
@@ -0,0 +1,142 @@
+// Package mcapdecode decodes MCAP message payloads into values ready for JSON
+// encoding, using the schemas stored in the file. It supports the json,
+// ros1 (ros1msg schemas), cdr (ros2msg schemas) and protobuf message
+// encodings.
+package mcapdecode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"math"
+	"mcap-utility/internal/ros"
+)
+
+// ErrUnsupportedEncoding is returned for channels whose message or schema
+// encoding cannot be decoded.
+var ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+// Field is a named value of a decoded message.
+type Field struct {
+	Name  string
+	Value any
+}
+
+// Message is a decoded message. It keeps the field order of its schema and
+// encodes to a JSON object.
+//
+// Values are bools, integers, float32 or float64, strings, []byte for byte
+// arrays (base64 in JSON), []any for other arrays, nested Messages and nil
+// for unset protobuf messages. Non-finite floats are the strings "NaN",
+// "Infinity" and "-Infinity", as JSON has no numbers for them.
+type Message []Field
+
+// MarshalJSON encodes m to a JSON object with its fields in order.
+func (m Message) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, field := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type decodeFunc func(data []byte) (any, error)
+
+// Decoder decodes the messages of one MCAP file. The schema of each channel
+// is parsed once, on its first message, so a Decoder must not be shared by
+// files whose channel IDs may collide. It is not safe for concurrent use.
+type Decoder struct {
+	channels map[uint16]decodeFunc
+	errs     map[uint16]error
+}
+
+// NewDecoder returns a Decoder for the messages of one file.
+func NewDecoder() *Decoder {
+	return &Decoder{
+		channels: map[uint16]decodeFunc{},
+		errs:     map[uint16]error{},
+	}
+}
+
+// Decode decodes the payload data of a message on channel, whose schema is
+// nil for schemaless channels. The result is a json.RawMessage for json
+// channels and a Message otherwise. It does not keep references to data.
+func (d *Decoder) Decode(schema *mcap.Schema, channel *mcap.Channel, data []byte) (any, error) {
+	if err := d.errs[channel.ID]; err != nil {
+		return nil, err
+	}
+	decode, ok := d.channels[channel.ID]
+	if !ok {
+		var err error
+		decode, err = newDecodeFunc(schema, channel)
+		if err != nil {
+			err = fmt.Errorf("channel %s: %w", channel.Topic, err)
+			d.errs[channel.ID] = err
+			return nil, err
+		}
+		d.channels[channel.ID] = decode
+	}
+	return decode(data)
+}
+
+func newDecodeFunc(schema *mcap.Schema, channel *mcap.Channel) (decodeFunc, error) {
+	schemaEncoding := ""
+	if schema != nil {
+		schemaEncoding = schema.Encoding
+	}
+
+	switch {
+	case channel.MessageEncoding == "json":
+		return func(data []byte) (any, error) {
+			if !json.Valid(data) {
+				return nil, errors.New("invalid JSON payload")
+			}
+			return json.RawMessage(bytes.Clone(data)), nil
+		}, nil
+	case channel.MessageEncoding == ros.MessageEncodingROS1 && schemaEncoding == ros.SchemaEncodingROS1,
+		channel.MessageEncoding == ros.MessageEncodingCDR && schemaEncoding == ros.SchemaEncodingROS2:
+		return newROSDecodeFunc(schema, channel)
+	case channel.MessageEncoding == "protobuf" && schemaEncoding == "protobuf":
+		return newProtobufDecodeFunc(schema)
+	default:
+		return nil, fmt.Errorf("%w: message encoding %q with schema encoding %q",
+			ErrUnsupportedEncoding, channel.MessageEncoding, schemaEncoding)
+	}
+}
+
+func jsonFloat64(f float64) any {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	default:
+		return f
+	}
+}
+
+func jsonFloat32(f float32) any {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return jsonFloat64(float64(f))
+	}
+	return f
+}
@@ -0,0 +1,171 @@
+package mcapdecode
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"math"
+	"mcap-utility/internal/ros"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, schema *mcap.Schema, channel *mcap.Channel, data []byte) string {
+	t.Helper()
+
+	value, err := NewDecoder().Decode(schema, channel, data)
+	assert.NoError(t, err)
+	out, err := json.Marshal(value)
+	assert.NoError(t, err)
+	return string(out)
+}
+
+const ros1Definition = `Header header
+float64[2] position # fixed size
+uint8[] raw
+string name
+int32 COUNT=5
+Point[] points
+================================================================================
+MSG: std_msgs/Header
+uint32 seq
+time stamp
+string frame_id
+================================================================================
+MSG: my_pkg/Point
+float32 x
+int16 y
+`
+
+func TestDecodeROS1(t *testing.T) {
+	le := binary.LittleEndian
+	data := le.AppendUint32(nil, 7)
+	data = le.AppendUint32(data, 100)
+	data = le.AppendUint32(data, 200)
+	data = le.AppendUint32(data, 3)
+	data = append(data, "map"...)
+	data = le.AppendUint64(data, math.Float64bits(1.5))
+	data = le.AppendUint64(data, math.Float64bits(math.NaN()))
+	data = le.AppendUint32(data, 2)
+	data = append(data, 0xab, 0xcd)
+	data = le.AppendUint32(data, 2)
+	data = append(data, "hi"...)
+	data = le.AppendUint32(data, 1)
+	data = le.AppendUint32(data, math.Float32bits(0.25))
+	data = le.AppendUint16(data, uint16(0xffff))
+
+	schema := &mcap.Schema{ID: 1, Name: "my_pkg/Test", Encoding: "ros1msg", Data: []byte(ros1Definition)}
+	channel := &mcap.Channel{ID: 1, SchemaID: 1, Topic: "/test", MessageEncoding: "ros1"}
+
+	assert.Equal(t,
+		`{"header":{"seq":7,"stamp":{"sec":100,"nsec":200},"frame_id":"map"},"position":[1.5,"NaN"],`+
+			`"raw":"q80=","name":"hi","points":[{"x":0.25,"y":-1}]}`,
+		decodeJSON(t, schema, channel, data))
+
+	_, err := NewDecoder().Decode(schema, channel, data[:len(data)-1])
+	assert.ErrorIs(t, err, ros.ErrShortMessage)
+}
+
+func TestDecodeCDR(t *testing.T) {
+	definition := `uint8 flag
+float64 x
+string label
+int16[] values
+builtin_interfaces/Time stamp
+================================================================================
+MSG: builtin_interfaces/Time
+int32 sec
+uint32 nanosec
+`
+	le := binary.LittleEndian
+	data := []byte{0x00, 0x01, 0x00, 0x00, 1, 0, 0, 0, 0, 0, 0, 0}
+	data = le.AppendUint64(data, math.Float64bits(-2))
+	data = le.AppendUint32(data, 3)
+	data = append(data, 'a', 'b', 0, 0)
+	data = le.AppendUint32(data, 2)
+	data = le.AppendUint16(data, 10)
+	data = le.AppendUint16(data, 20)
+	data = le.AppendUint32(data, 5)
+	data = le.AppendUint32(data, 6)
+
+	schema := &mcap.Schema{ID: 1, Name: "my_pkg/msg/Sample", Encoding: "ros2msg", Data: []byte(definition)}
+	channel := &mcap.Channel{ID: 1, SchemaID: 1, Topic: "/sample", MessageEncoding: "cdr"}
+
+	assert.Equal(t,
+		`{"flag":1,"x":-2,"label":"ab","values":[10,20],"stamp":{"sec":5,"nanosec":6}}`,
+		decodeJSON(t, schema, channel, data))
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("reading.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("GOOD"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Reading"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("name"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				{Name: proto.String("value"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+				{Name: proto.String("samples"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+					Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+				{Name: proto.String("kind"), Number: proto.Int32(4), Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum(),
+					TypeName: proto.String(".test.Kind")},
+				{Name: proto.String("parent"), Number: proto.Int32(5), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: proto.String(".test.Reading")},
+			},
+		}},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	assert.NoError(t, err)
+	schemaData, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	assert.NoError(t, err)
+
+	md := fd.Messages().ByName("Reading")
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("name"), protoreflect.ValueOfString("imu"))
+	msg.Set(md.Fields().ByName("value"), protoreflect.ValueOfFloat64(3.5))
+	samples := msg.Mutable(md.Fields().ByName("samples")).List()
+	samples.Append(protoreflect.ValueOfInt32(1))
+	samples.Append(protoreflect.ValueOfInt32(2))
+	msg.Set(md.Fields().ByName("kind"), protoreflect.ValueOfEnum(1))
+	data, err := proto.Marshal(msg)
+	assert.NoError(t, err)
+
+	schema := &mcap.Schema{ID: 1, Name: "test.Reading", Encoding: "protobuf", Data: schemaData}
+	channel := &mcap.Channel{ID: 1, SchemaID: 1, Topic: "/reading", MessageEncoding: "protobuf"}
+
+	assert.Equal(t,
+		`{"name":"imu","value":3.5,"samples":[1,2],"kind":"GOOD","parent":null}`,
+		decodeJSON(t, schema, channel, data))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	channel := &mcap.Channel{ID: 1, Topic: "/json", MessageEncoding: "json"}
+	assert.Equal(t, `{"b":1,"a":[true]}`, decodeJSON(t, nil, channel, []byte(`{"b":1,"a":[true]}`)))
+
+	_, err := NewDecoder().Decode(nil, channel, []byte(`{"b":`))
+	assert.Error(t, err)
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	decoder := NewDecoder()
+	schema := &mcap.Schema{ID: 1, Name: "my_pkg/msg/Sample", Encoding: "ros2idl", Data: []byte("module my_pkg {};")}
+	channel := &mcap.Channel{ID: 1, SchemaID: 1, Topic: "/sample", MessageEncoding: "cdr"}
+
+	_, err := decoder.Decode(schema, channel, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+	_, err = decoder.Decode(schema, channel, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+}
@@ -0,0 +1,112 @@
+package mcapdecode
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"slices"
+)
+
+// newProtobufDecodeFunc decodes the messages of a protobuf schema, whose data
+// is a serialized FileDescriptorSet holding the message type schema.Name.
+func newProtobufDecodeFunc(schema *mcap.Schema) (decodeFunc, error) {
+	descriptorSet := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(schema.Data, descriptorSet); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %s", schema.Name, err)
+	}
+	files, err := protodesc.NewFiles(descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema %s: %s", schema.Name, err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(schema.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message type %s: %s", schema.Name, err)
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", schema.Name)
+	}
+
+	return func(data []byte) (any, error) {
+		msg := dynamicpb.NewMessage(messageDescriptor)
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return nil, err
+		}
+		return protoMessage(msg), nil
+	}, nil
+}
+
+// protoMessage converts every field of msg, in declaration order. Unset
+// fields have their default value, or nil for messages.
+func protoMessage(msg protoreflect.Message) Message {
+	fields := msg.Descriptor().Fields()
+	result := make(Message, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		value := msg.Get(fd)
+
+		var converted any
+		switch {
+		case fd.IsList():
+			list := value.List()
+			values := make([]any, 0, list.Len())
+			for j := 0; j < list.Len(); j++ {
+				values = append(values, protoValue(fd, list.Get(j)))
+			}
+			converted = values
+		case fd.IsMap():
+			entries := make(Message, 0, value.Map().Len())
+			value.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				entries = append(entries, Field{Name: key.String(), Value: protoValue(fd.MapValue(), value)})
+				return true
+			})
+			slices.SortFunc(entries, func(a, b Field) int {
+				return cmp.Compare(a.Name, b.Name)
+			})
+			converted = entries
+		case fd.Message() != nil && !msg.Has(fd):
+			converted = nil
+		default:
+			converted = protoValue(fd, value)
+		}
+		result = append(result, Field{Name: string(fd.Name()), Value: converted})
+	}
+	return result
+}
+
+func protoValue(fd protoreflect.FieldDescriptor, value protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return value.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return int32(value.Int())
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return value.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return uint32(value.Uint())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return value.Uint()
+	case protoreflect.FloatKind:
+		return jsonFloat32(float32(value.Float()))
+	case protoreflect.DoubleKind:
+		return jsonFloat64(value.Float())
+	case protoreflect.StringKind:
+		return value.String()
+	case protoreflect.BytesKind:
+		return value.Bytes()
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(value.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int32(value.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protoMessage(value.Message())
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package mcapdecode
+
+import (
+	"github.com/foxglove/mcap/go/mcap"
+	"mcap-utility/internal/ros"
+)
+
+func newROSDecodeFunc(schema *mcap.Schema, channel *mcap.Channel) (decodeFunc, error) {
+	decoder, err := ros.NewDecoder(schema, channel.MessageEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) (any, error) {
+		msg, err := decoder.DecodeMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		return fromROS(msg), nil
+	}, nil
+}
+
+// fromROS converts a decoded ROS message to a Message, replacing the
+// non-finite floats JSON cannot encode.
+func fromROS(msg ros.Message) Message {
+	result := make(Message, 0, len(msg))
+	for _, field := range msg {
+		result = append(result, Field{Name: field.Name, Value: fromROSValue(field.Value)})
+	}
+	return result
+}
+
+func fromROSValue(value any) any {
+	switch value := value.(type) {
+	case ros.Message:
+		return fromROS(value)
+	case []any:
+		for i, v := range value {
+			value[i] = fromROSValue(v)
+		}
+		return value
+	case float32:
+		return jsonFloat32(value)
+	case float64:
+		return jsonFloat64(value)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,921 @@
+package mcapedit
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"mcap-utility/internal/utils"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// chunkCopier writes the output of Transform chunk by chunk, using the chunk
+// indexes of the input. Chunks are read in file order, processed on a pool of
+// workers and written in their original order.
+//
+// Without writerOpt, compressed chunks are copied as they are and only the
+// chunks holding records the transformers change or drop are decoded and
+// rebuilt. With writerOpt, every chunk is decoded and compressed again.
+// In both cases the summary section is rewritten from scratch.
+type chunkCopier struct {
+	r        io.ReadSeeker
+	reader   *mcap.Reader
+	mcapInfo *mcap.Info
+	chain    *chain
+	// trims are the trims applied to the original log times, used to skip
+	// whole chunks.
+	trims     []*TrimTime
+	writerOpt *mcap.WriterOptions
+	// summaryOpt holds the Skip options of the output, honored when writing
+	// the summary section like mcap.Writer does.
+	summaryOpt mcap.WriterOptions
+	workers    int
+	progress   *Progress
+
+	// schemas and channels are the output records, by output ID.
+	schemas  map[uint16]*mcap.Schema
+	channels map[uint16]*mcap.Channel
+	// stages holds the chain stages of every input channel. They are computed
+	// ahead so workers only read the chain.
+	stages map[uint16][]stage
+	// dropped holds the input channels removed by the transformers, changed
+	// the ones they rewrote. Chunks holding their records are rebuilt.
+	dropped map[uint16]bool
+	changed map[uint16]bool
+	scan    bool
+
+	out   *countingWriter
+	enc   *mcap.Writer
+	stats *mcap.Statistics
+	// timed is set once the message times of stats are set.
+	timed bool
+
+	chunkIndexes []*mcap.ChunkIndex
+}
+
+// countingWriter tracks the offset and the CRC of the bytes written through it.
+type countingWriter struct {
+	w    io.Writer
+	size uint64
+	crc  hash.Hash32
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.size += uint64(n)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+// chunkRecord is a schema, channel or message record read from a chunk.
+type chunkRecord struct {
+	schema  *mcap.Schema
+	channel *mcap.Channel
+	message *mcap.Message
+}
+
+// chunkJob holds consecutive input chunks processed together. A chunk that
+// may be copied comes alone, along with its message index records.
+type chunkJob struct {
+	idxs              []*mcap.ChunkIndex
+	chunks            [][]byte
+	size              uint64
+	messageIndexes    []byte
+	messageIndexStart uint64
+	result            chan chunkResult
+}
+
+// chunkResult holds the records written for a chunk. Offsets of the chunk
+// indexes are relative to the start of data.
+type chunkResult struct {
+	data    [][]byte
+	indexes []*mcap.ChunkIndex
+	counts  map[uint16]uint64
+	err     error
+}
+
+// chunkCodecs holds the compressors and decompressors of a worker, reused
+// from chunk to chunk since creating them costs more than compressing a chunk.
+type chunkCodecs struct {
+	level         mcap.CompressionLevel
+	compressors   map[mcap.CompressionFormat]mcap.CustomCompressor
+	decompressors map[mcap.CompressionFormat]mcap.ResettableReader
+}
+
+func newChunkCodecs(level mcap.CompressionLevel) *chunkCodecs {
+	return &chunkCodecs{
+		level:         level,
+		compressors:   map[mcap.CompressionFormat]mcap.CustomCompressor{},
+		decompressors: map[mcap.CompressionFormat]mcap.ResettableReader{},
+	}
+}
+
+// compressor returns the compressor of format, nil for formats handled by
+// mcap.Writer alone.
+func (c *chunkCodecs) compressor(format mcap.CompressionFormat) (mcap.CustomCompressor, error) {
+	if compressor, ok := c.compressors[format]; ok {
+		return compressor, nil
+	}
+
+	var compressor mcap.CustomCompressor
+	switch format {
+	case mcap.CompressionZSTD:
+		level := zstd.SpeedDefault
+		switch c.level {
+		case mcap.CompressionLevelFastest:
+			level = zstd.SpeedFastest
+		case mcap.CompressionLevelBetter:
+			level = zstd.SpeedBetterCompression
+		case mcap.CompressionLevelBest:
+			level = zstd.SpeedBestCompression
+		}
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		compressor = mcap.NewCustomCompressor(format, encoder)
+	case mcap.CompressionLZ4:
+		level := lz4.Level3
+		switch c.level {
+		case mcap.CompressionLevelFastest:
+			level = lz4.Fast
+		case mcap.CompressionLevelBetter:
+			level = lz4.Level6
+		case mcap.CompressionLevelBest:
+			level = lz4.Level9
+		}
+		encoder := lz4.NewWriter(nil)
+		if err := encoder.Apply(lz4.CompressionLevelOption(level)); err != nil {
+			return nil, err
+		}
+		compressor = mcap.NewCustomCompressor(format, encoder)
+	}
+
+	c.compressors[format] = compressor
+	return compressor, nil
+}
+
+// decompressorsFor returns the decompressors to give to mcap.Lexer for a
+// chunk compressed with format.
+func (c *chunkCodecs) decompressorsFor(format mcap.CompressionFormat) (map[mcap.CompressionFormat]mcap.ResettableReader, error) {
+	if _, ok := c.decompressors[format]; !ok && format == mcap.CompressionZSTD {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.decompressors[format] = decoder
+	}
+	return c.decompressors, nil
+}
+
+func (c *chunkCodecs) close() {
+	if decoder, ok := c.decompressors[mcap.CompressionZSTD].(*zstd.Decoder); ok {
+		decoder.Close()
+	}
+}
+
+// newChunkCopier returns nil if the transformers or the input require
+// decoding every message in a single pass.
+func newChunkCopier(
+	r io.ReadSeeker,
+	reader *mcap.Reader,
+	mcapInfo *mcap.Info,
+	c *chain,
+	transformers []Transformer,
+	opts *Options,
+) (*chunkCopier, error) {
+	if mcapInfo.Statistics == nil || len(mcapInfo.ChunkIndexes) == 0 ||
+		int(mcapInfo.Statistics.ChunkCount) != len(mcapInfo.ChunkIndexes) {
+		return nil, nil
+	}
+	// The message indexes of copied chunks are kept, outputs without them
+	// are written by mcap.Writer.
+	if opts.WriterOptions != nil && opts.WriterOptions.SkipMessageIndexing {
+		return nil, nil
+	}
+
+	p := &chunkCopier{
+		r:        r,
+		reader:   reader,
+		mcapInfo: mcapInfo,
+		chain:    c,
+		workers:  opts.Workers,
+		progress: opts.Progress,
+		schemas:  map[uint16]*mcap.Schema{},
+		channels: map[uint16]*mcap.Channel{},
+		stages:   map[uint16][]stage{},
+		dropped:  map[uint16]bool{},
+		changed:  map[uint16]bool{},
+	}
+	if opts.WriterOptions != nil {
+		p.summaryOpt = *opts.WriterOptions
+	}
+	if p.workers == 0 {
+		p.workers = runtime.NumCPU()
+	}
+
+	// Chunks can only be copied when the transformers drop messages without
+	// modifying them. Trims following a transformer that may change log
+	// times cannot be used to skip chunks.
+	copyable, keepsTimes := true, true
+	for _, t := range transformers {
+		switch t := t.(type) {
+		case *TrimTime:
+			if keepsTimes {
+				p.trims = append(p.trims, t)
+			}
+		case *DeleteTopics, *RenameTopics, *FilterAttachments, *FilterMetadata, *Passthrough:
+		case *PublishTimeStamp:
+			copyable = false
+		default:
+			copyable, keepsTimes = false, false
+		}
+	}
+
+	if opts.Recompress || !copyable {
+		writerOpt := opts.WriterOptions
+		if writerOpt == nil {
+			var err error
+			writerOpt, err = utils.NewWriterOptions("", 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// A custom compressor cannot be shared between workers.
+		if p.workers < 2 || !writerOpt.Chunked || writerOpt.Compressor != nil {
+			return nil, nil
+		}
+		p.writerOpt = writerOpt
+	}
+
+	for _, id := range sortedKeys(mcapInfo.Channels) {
+		channel := mcapInfo.Channels[id]
+		schema := mcapInfo.Schemas[channel.SchemaID]
+		stages, err := c.channel(schema, channel)
+		if err != nil {
+			return nil, err
+		}
+		p.stages[channel.ID] = stages
+
+		out := stages[len(stages)-1]
+		if out.channel == nil {
+			p.dropped[channel.ID] = true
+			continue
+		}
+		if p.writerOpt == nil {
+			if out.channel.ID != channel.ID || out.schema != schema {
+				return nil, nil
+			}
+			if out.channel != channel {
+				p.changed[channel.ID] = true
+			}
+		}
+
+		if _, ok := p.channels[out.channel.ID]; !ok {
+			p.channels[out.channel.ID] = out.channel
+		}
+		if out.schema != nil {
+			if _, ok := p.schemas[out.schema.ID]; !ok {
+				p.schemas[out.schema.ID] = out.schema
+			}
+		}
+	}
+
+	// Schemas left without channels are not written, like in the decoding path.
+	p.scan = p.writerOpt == nil &&
+		(len(p.dropped) > 0 || len(p.changed) > 0 || len(p.schemas) != len(mcapInfo.Schemas))
+	return p, nil
+}
+
+// run writes the output to w.
+func (p *chunkCopier) run(ctx context.Context, w io.Writer) error {
+	p.out = &countingWriter{w: w, crc: crc32.NewIEEE()}
+
+	var err error
+	p.enc, err = mcap.NewWriter(p.out, &mcap.WriterOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create new writer: %s", err)
+	}
+
+	err = p.enc.WriteHeader(p.reader.Header())
+	if err != nil {
+		return fmt.Errorf("failed to write header: %s", err)
+	}
+
+	// Metadata and attachments go first, while the offsets of the encoder
+	// still match the output.
+	err = utils.CopyMetadata(p.reader, p.enc, p.mcapInfo, p.chain.metadata)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CopyAttachments(p.reader, p.enc, p.mcapInfo, p.chain.attachment)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sortedKeys(p.schemas) {
+		if err := p.enc.WriteSchema(p.schemas[id]); err != nil {
+			return fmt.Errorf("write schema: %w", err)
+		}
+	}
+
+	for _, id := range sortedKeys(p.channels) {
+		if err := p.enc.WriteChannel(p.channels[id]); err != nil {
+			return fmt.Errorf("write channel: %w", err)
+		}
+	}
+
+	p.stats = &mcap.Statistics{
+		SchemaCount:          uint16(len(p.schemas)),
+		ChannelCount:         uint32(len(p.channels)),
+		AttachmentCount:      uint32(len(p.enc.AttachmentIndexes)),
+		MetadataCount:        uint32(len(p.enc.MetadataIndexes)),
+		ChannelMessageCounts: map[uint16]uint64{},
+	}
+
+	if err := p.copyChunks(ctx); err != nil {
+		return err
+	}
+
+	return p.writeSummary()
+}
+
+// copyChunks reads the chunks on one goroutine, processes them on p.workers
+// goroutines and writes the results in order. At most 2*p.workers chunks are
+// held in memory.
+func (p *chunkCopier) copyChunks(ctx context.Context) error {
+	chunkIndexes := slices.Clone(p.mcapInfo.ChunkIndexes)
+	slices.SortFunc(chunkIndexes, func(a, b *mcap.ChunkIndex) int {
+		return cmp.Compare(a.ChunkStartOffset, b.ChunkStartOffset)
+	})
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Small input chunks are merged up to the output chunk size when they
+	// are compressed again.
+	var chunkSize uint64
+	if p.writerOpt != nil {
+		chunkSize = 1024 * 1024
+		if p.writerOpt.ChunkSize > 0 {
+			chunkSize = uint64(p.writerOpt.ChunkSize)
+		}
+	}
+
+	jobs := make(chan *chunkJob, p.workers)
+	pending := make(chan *chunkJob, 2*p.workers)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(pending)
+
+		send := func(job *chunkJob) bool {
+			select {
+			case pending <- job:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return false
+			}
+			return true
+		}
+
+		job := &chunkJob{result: make(chan chunkResult, 1)}
+		for _, idx := range chunkIndexes {
+			if p.skip(idx) {
+				continue
+			}
+
+			if len(job.idxs) > 0 && job.size+idx.UncompressedSize > chunkSize {
+				if !send(job) {
+					return
+				}
+				job = &chunkJob{result: make(chan chunkResult, 1)}
+			}
+
+			if err := p.readChunk(job, idx); err != nil {
+				job.result <- chunkResult{err: err}
+				select {
+				case pending <- job:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+
+		if len(job.idxs) > 0 {
+			send(job)
+		}
+	}()
+
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			level := mcap.CompressionLevelDefault
+			if p.writerOpt != nil {
+				level = p.writerOpt.CompressionLevel
+			}
+			codecs := newChunkCodecs(level)
+			defer codecs.close()
+
+			for job := range jobs {
+				job.result <- p.processChunk(job, codecs)
+			}
+		}()
+	}
+
+	// The reader may stop after queueing a job no worker will get.
+	for job := range pending {
+		var result chunkResult
+		select {
+		case result = <-job.result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+		if err := p.writeResult(result); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// skip reports whether the chunk is entirely outside of a trim window.
+func (p *chunkCopier) skip(idx *mcap.ChunkIndex) bool {
+	for _, trim := range p.trims {
+		if idx.MessageEndTime < trim.Start || (trim.End != 0 && idx.MessageStartTime > trim.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// readChunk adds the chunk of idx to job and, when the chunk may be copied,
+// reads its message index records.
+func (p *chunkCopier) readChunk(job *chunkJob, idx *mcap.ChunkIndex) error {
+	chunk, err := p.readAt(idx.ChunkStartOffset, idx.ChunkLength)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk at %d: %s", idx.ChunkStartOffset, err)
+	}
+	job.idxs = append(job.idxs, idx)
+	job.chunks = append(job.chunks, chunk)
+	job.size += idx.UncompressedSize
+
+	if p.writerOpt != nil {
+		return nil
+	}
+
+	for _, offset := range idx.MessageIndexOffsets {
+		if job.messageIndexStart == 0 || offset < job.messageIndexStart {
+			job.messageIndexStart = offset
+		}
+	}
+	job.messageIndexes, err = p.readAt(job.messageIndexStart, idx.MessageIndexLength)
+	if err != nil {
+		return fmt.Errorf("failed to read message indexes at %d: %s", job.messageIndexStart, err)
+	}
+	return nil
+}
+
+// processChunk copies or rebuilds the chunks of job.
+func (p *chunkCopier) processChunk(job *chunkJob, codecs *chunkCodecs) chunkResult {
+	idx := job.idxs[0]
+	rebuild := p.writerOpt != nil
+	for _, trim := range p.trims {
+		if !trim.contains(idx.MessageStartTime) || !trim.contains(idx.MessageEndTime) {
+			rebuild = true
+		}
+	}
+	for channelID := range idx.MessageIndexOffsets {
+		if p.dropped[channelID] {
+			rebuild = true
+		}
+	}
+
+	var records []chunkRecord
+	if rebuild || p.scan {
+		for i, chunk := range job.chunks {
+			decompressors, err := codecs.decompressorsFor(job.idxs[i].Compression)
+			if err != nil {
+				return chunkResult{err: err}
+			}
+			chunkRecords, err := readChunkRecords(chunk, decompressors)
+			if err != nil {
+				return chunkResult{err: fmt.Errorf("failed to read chunk at %d: %s", job.idxs[i].ChunkStartOffset, err)}
+			}
+			records = append(records, chunkRecords...)
+		}
+		rebuild = rebuild || slices.ContainsFunc(records, p.needsRebuild)
+	}
+
+	if rebuild {
+		var count uint64
+		for _, record := range records {
+			if record.message != nil {
+				count++
+			}
+		}
+		p.progress.addMessages(count)
+
+		result, err := p.rebuildChunk(idx, records, codecs)
+		if err != nil {
+			return chunkResult{err: err}
+		}
+		return result
+	}
+
+	counts, err := countMessages(job.messageIndexes)
+	if err != nil {
+		return chunkResult{err: fmt.Errorf("failed to read message indexes at %d: %s", job.messageIndexStart, err)}
+	}
+	for _, count := range counts {
+		p.progress.addMessages(count)
+	}
+
+	outIdx := *idx
+	outIdx.ChunkStartOffset = 0
+	outIdx.MessageIndexOffsets = make(map[uint16]uint64, len(idx.MessageIndexOffsets))
+	for channelID, offset := range idx.MessageIndexOffsets {
+		outIdx.MessageIndexOffsets[channelID] = offset - job.messageIndexStart + idx.ChunkLength
+	}
+
+	return chunkResult{
+		data:    [][]byte{job.chunks[0], job.messageIndexes},
+		indexes: []*mcap.ChunkIndex{&outIdx},
+		counts:  counts,
+	}
+}
+
+// needsRebuild reports whether a record of a chunk differs from the output.
+func (p *chunkCopier) needsRebuild(record chunkRecord) bool {
+	switch {
+	case record.schema != nil:
+		return p.schemas[record.schema.ID] == nil
+	case record.channel != nil:
+		return p.dropped[record.channel.ID] || p.changed[record.channel.ID]
+	}
+	return false
+}
+
+// rebuildChunk runs the messages of one or several chunks starting with idx
+// through the transformers and writes them to new chunks, compressed with
+// writerOpt or with the compression of the input chunk.
+func (p *chunkCopier) rebuildChunk(idx *mcap.ChunkIndex, records []chunkRecord, codecs *chunkCodecs) (chunkResult, error) {
+	writerOpt := &mcap.WriterOptions{
+		IncludeCRC:  true,
+		ChunkSize:   math.MaxInt64,
+		Compression: idx.Compression,
+	}
+	if p.writerOpt != nil {
+		opts := *p.writerOpt
+		writerOpt = &opts
+	}
+	writerOpt.Chunked = true
+	writerOpt.SkipMagic = true
+	// The chunk indexes and statistics of the chunk writer locate and count
+	// the rebuilt chunks, whatever the options of the output.
+	writerOpt.SkipChunkIndex = false
+	writerOpt.SkipStatistics = false
+
+	var err error
+	writerOpt.Compressor, err = codecs.compressor(writerOpt.Compression)
+	if err != nil {
+		return chunkResult{}, fmt.Errorf("failed to create compressor: %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	chunkWriter, err := mcap.NewWriter(buf, writerOpt)
+	if err != nil {
+		return chunkResult{}, fmt.Errorf("failed to create chunk writer: %s", err)
+	}
+
+	// Schemas and channels are written ahead of the chunks, they are only
+	// repeated in the chunks using them.
+	schemaWritten := map[uint16]bool{}
+	channelWritten := map[uint16]bool{}
+
+	for _, record := range records {
+		if record.message == nil {
+			continue
+		}
+
+		msg := record.message
+		stages, ok := p.stages[msg.ChannelID]
+		if !ok {
+			return chunkResult{}, fmt.Errorf("message on unknown channel %d", msg.ChannelID)
+		}
+		out := stages[len(stages)-1]
+		if out.channel == nil {
+			continue
+		}
+
+		msg, err = p.chain.message(stages, msg)
+		if err != nil {
+			return chunkResult{}, err
+		}
+		if msg == nil {
+			continue
+		}
+
+		if out.schema != nil && !schemaWritten[out.schema.ID] {
+			if err := chunkWriter.WriteSchema(out.schema); err != nil {
+				return chunkResult{}, fmt.Errorf("write schema: %w", err)
+			}
+			schemaWritten[out.schema.ID] = true
+		}
+
+		if !channelWritten[out.channel.ID] {
+			if err := chunkWriter.WriteChannel(out.channel); err != nil {
+				return chunkResult{}, fmt.Errorf("write channel: %w", err)
+			}
+			channelWritten[out.channel.ID] = true
+		}
+
+		msg.ChannelID = out.channel.ID
+		if err := chunkWriter.WriteMessage(msg); err != nil {
+			return chunkResult{}, err
+		}
+	}
+
+	// A chunk left without messages is dropped.
+	if chunkWriter.Statistics.MessageCount == 0 {
+		return chunkResult{}, nil
+	}
+
+	if err := chunkWriter.Close(); err != nil {
+		return chunkResult{}, fmt.Errorf("failed to rebuild chunk at %d: %s", idx.ChunkStartOffset, err)
+	}
+
+	if len(chunkWriter.ChunkIndexes) == 0 {
+		return chunkResult{}, fmt.Errorf("failed to rebuild chunk at %d: no chunk written", idx.ChunkStartOffset)
+	}
+	last := chunkWriter.ChunkIndexes[len(chunkWriter.ChunkIndexes)-1]
+	end := last.ChunkStartOffset + last.ChunkLength + last.MessageIndexLength
+	return chunkResult{
+		data:    [][]byte{buf.Bytes()[:end]},
+		indexes: chunkWriter.ChunkIndexes,
+		counts:  chunkWriter.Statistics.ChannelMessageCounts,
+	}, nil
+}
+
+// writeResult writes the records of a processed chunk and records them in
+// the statistics and the chunk indexes.
+func (p *chunkCopier) writeResult(result chunkResult) error {
+	start := p.out.size
+	for _, data := range result.data {
+		if _, err := p.out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	for _, idx := range result.indexes {
+		idx.ChunkStartOffset += start
+		for channelID := range idx.MessageIndexOffsets {
+			idx.MessageIndexOffsets[channelID] += start
+		}
+
+		if len(idx.MessageIndexOffsets) > 0 {
+			if !p.timed || idx.MessageStartTime < p.stats.MessageStartTime {
+				p.stats.MessageStartTime = idx.MessageStartTime
+			}
+			if !p.timed || idx.MessageEndTime > p.stats.MessageEndTime {
+				p.stats.MessageEndTime = idx.MessageEndTime
+			}
+			p.timed = true
+		}
+		p.stats.ChunkCount++
+		p.chunkIndexes = append(p.chunkIndexes, idx)
+	}
+
+	for channelID, count := range result.counts {
+		p.stats.ChannelMessageCounts[channelID] += count
+		p.stats.MessageCount += count
+	}
+	return nil
+}
+
+// writeSummary ends the data section and writes the summary section, the
+// footer and the closing magic.
+func (p *chunkCopier) writeSummary() error {
+	err := p.enc.WriteDataEnd(&mcap.DataEnd{DataSectionCRC: p.out.crc.Sum32()})
+	if err != nil {
+		return fmt.Errorf("failed to write data end: %s", err)
+	}
+
+	p.out.crc.Reset()
+	summaryStart := p.out.size
+	offsets := make([]*mcap.SummaryOffset, 0, 6)
+	group := func(opcode mcap.OpCode, write func() error) error {
+		start := p.out.size
+		if err := write(); err != nil {
+			return fmt.Errorf("failed to write summary section: %s", err)
+		}
+		if p.out.size > start {
+			offsets = append(offsets, &mcap.SummaryOffset{
+				GroupOpcode: opcode,
+				GroupStart:  start,
+				GroupLength: p.out.size - start,
+			})
+		}
+		return nil
+	}
+
+	skip := p.summaryOpt
+	groups := []struct {
+		opcode mcap.OpCode
+		skip   bool
+		write  func() error
+	}{
+		{mcap.OpSchema, skip.SkipRepeatedSchemas, func() error {
+			for _, id := range sortedKeys(p.schemas) {
+				if err := p.enc.WriteSchema(p.schemas[id]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{mcap.OpChannel, skip.SkipRepeatedChannelInfos, func() error {
+			for _, id := range sortedKeys(p.channels) {
+				if err := p.enc.WriteChannel(p.channels[id]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{mcap.OpStatistics, skip.SkipStatistics, func() error {
+			return p.enc.WriteStatistics(p.stats)
+		}},
+		{mcap.OpChunkIndex, skip.SkipChunkIndex, func() error {
+			for _, idx := range p.chunkIndexes {
+				if err := p.enc.WriteChunkIndex(idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{mcap.OpAttachmentIndex, skip.SkipAttachmentIndex, func() error {
+			for _, idx := range p.enc.AttachmentIndexes {
+				if err := p.enc.WriteAttachmentIndex(idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{mcap.OpMetadataIndex, skip.SkipMetadataIndex, func() error {
+			for _, idx := range p.enc.MetadataIndexes {
+				if err := p.enc.WriteMetadataIndex(idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+	for _, g := range groups {
+		if g.skip {
+			continue
+		}
+		if err := group(g.opcode, g.write); err != nil {
+			return err
+		}
+	}
+	// An empty summary section has no start, like in mcap.Writer.
+	if len(offsets) == 0 {
+		summaryStart = 0
+	}
+
+	var summaryOffsetStart uint64
+	if !skip.SkipSummaryOffsets {
+		summaryOffsetStart = p.out.size
+		for _, offset := range offsets {
+			if err := p.enc.WriteSummaryOffset(offset); err != nil {
+				return fmt.Errorf("failed to write summary offset: %s", err)
+			}
+		}
+	}
+
+	// The footer CRC covers the summary section and the footer up to itself.
+	footer := make([]byte, 1+8+8+8)
+	footer[0] = byte(mcap.OpFooter)
+	binary.LittleEndian.PutUint64(footer[1:], 8+8+4)
+	binary.LittleEndian.PutUint64(footer[9:], summaryStart)
+	binary.LittleEndian.PutUint64(footer[17:], summaryOffsetStart)
+	if _, err := p.out.Write(footer); err != nil {
+		return fmt.Errorf("failed to write footer record: %s", err)
+	}
+	if _, err := p.out.Write(binary.LittleEndian.AppendUint32(nil, p.out.crc.Sum32())); err != nil {
+		return fmt.Errorf("failed to write footer record: %s", err)
+	}
+
+	if _, err := p.out.Write(mcap.Magic); err != nil {
+		return fmt.Errorf("failed to write closing magic: %s", err)
+	}
+	return nil
+}
+
+func (p *chunkCopier) readAt(offset uint64, length uint64) ([]byte, error) {
+	if _, err := p.r.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readChunkRecords decompresses a chunk record, including its opcode and
+// length, and parses the records it holds.
+func readChunkRecords(chunk []byte, decompressors map[mcap.CompressionFormat]mcap.ResettableReader) ([]chunkRecord, error) {
+	lexer, err := mcap.NewLexer(bytes.NewReader(chunk), &mcap.LexerOptions{
+		SkipMagic:         true,
+		ValidateChunkCRCs: true,
+		Decompressors:     decompressors,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer lexer.Close()
+
+	records := make([]chunkRecord, 0)
+	for {
+		token, data, err := lexer.Next(nil)
+		if err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, err
+		}
+
+		var record chunkRecord
+		switch token {
+		case mcap.TokenSchema:
+			record.schema, err = mcap.ParseSchema(data)
+		case mcap.TokenChannel:
+			record.channel, err = mcap.ParseChannel(data)
+		case mcap.TokenMessage:
+			record.message, err = mcap.ParseMessage(data)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// countMessages returns the number of messages per channel listed by a
+// sequence of message index records.
+func countMessages(messageIndexes []byte) (map[uint16]uint64, error) {
+	counts := map[uint16]uint64{}
+	for len(messageIndexes) > 0 {
+		if len(messageIndexes) < 9 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		length := binary.LittleEndian.Uint64(messageIndexes[1:9])
+		if uint64(len(messageIndexes)-9) < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if mcap.OpCode(messageIndexes[0]) == mcap.OpMessageIndex {
+			idx, err := mcap.ParseMessageIndex(messageIndexes[9 : 9+length])
+			if err != nil {
+				return nil, err
+			}
+			counts[idx.ChannelID] += uint64(len(idx.Records))
+		}
+		messageIndexes = messageIndexes[9+length:]
+	}
+	return counts, nil
+}
+
+func sortedKeys[V any](m map[uint16]V) []uint16 {
+	keys := make([]uint16, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
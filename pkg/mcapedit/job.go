@@ -0,0 +1,300 @@
+package mcapedit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mcap-utility/internal/utils"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// OpCompress names the job operation selecting the output compression. It is
+// not a Transformer since it only configures the writer.
+const OpCompress = "compress"
+
+// Job is a declarative list of edit operations, read from a YAML or JSON file:
+//
+//	operations:
+//	  - rename: {/old_topic: /new_topic}
+//	  - delete: [/debug]
+//	  - trim: {start: "2024-01-01T00:00:00Z", end: "1704070800000000000", attachments: true}
+//	  - shift: {log: 10s, publish: -1s, topics: [/imu]}
+//	  - pub-time: true
+//	  - attachments: {drop: false, names: ["calib/*"]}
+//	  - metadata: {drop: true}
+//	  - compress: {format: lz4, level: 1}
+//
+// Operations are applied in the listed order and may be repeated, e.g. to
+// shift several topics by different durations.
+type Job struct {
+	Operations []JobOperation `yaml:"operations"`
+}
+
+// JobOperation is a single operation of a Job. Exactly one field is set.
+type JobOperation struct {
+	Rename      map[string]string `yaml:"rename,omitempty"`
+	Delete      []string          `yaml:"delete,omitempty"`
+	Trim        *JobTrim          `yaml:"trim,omitempty"`
+	Shift       *JobShift         `yaml:"shift,omitempty"`
+	PubTime     *bool             `yaml:"pub-time,omitempty"`
+	Attachments *JobFilter        `yaml:"attachments,omitempty"`
+	Metadata    *JobFilter        `yaml:"metadata,omitempty"`
+	Compress    *JobCompress      `yaml:"compress,omitempty"`
+}
+
+// JobTrim bounds messages by log time. Start and End accept the same formats
+// as edit --trim-start and --trim-end.
+type JobTrim struct {
+	Start       string `yaml:"start,omitempty"`
+	End         string `yaml:"end,omitempty"`
+	Attachments bool   `yaml:"attachments,omitempty"`
+}
+
+// JobShift shifts log and publish times by Go durations (e.g. 100ms, -1h).
+type JobShift struct {
+	Log     string   `yaml:"log,omitempty"`
+	Publish string   `yaml:"publish,omitempty"`
+	Topics  []string `yaml:"topics,omitempty"`
+}
+
+// JobFilter drops or keeps attachments or metadata records by name.
+type JobFilter struct {
+	Drop  bool     `yaml:"drop,omitempty"`
+	Names []string `yaml:"names,omitempty"`
+}
+
+// JobCompress selects the output compression, see utils.NewWriterOptions.
+type JobCompress struct {
+	Format string `yaml:"format,omitempty"`
+	Level  int    `yaml:"level,omitempty"`
+}
+
+// LoadJob reads and validates a YAML or JSON job file.
+func LoadJob(filePath string) (*Job, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := ParseJob(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job file %s: %s", filePath, err)
+	}
+	return job, nil
+}
+
+// ParseJob decodes and validates a YAML or JSON job. Unknown keys are rejected.
+func ParseJob(data []byte) (*Job, error) {
+	job := &Job{}
+
+	// JSON is valid YAML, a single decoder handles both.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(job); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Validate checks every operation of the job.
+func (j *Job) Validate() error {
+	for idx := range j.Operations {
+		if err := j.Operations[idx].Validate(); err != nil {
+			return fmt.Errorf("operation %d: %s", idx+1, err)
+		}
+	}
+	return nil
+}
+
+// Kind returns the name of the operation (OpRename, OpTrim, ...).
+func (op *JobOperation) Kind() string {
+	kinds := op.kinds()
+	if len(kinds) != 1 {
+		return ""
+	}
+	return kinds[0]
+}
+
+func (op *JobOperation) kinds() []string {
+	kinds := make([]string, 0, 1)
+	if op.Rename != nil {
+		kinds = append(kinds, OpRename)
+	}
+	if op.Delete != nil {
+		kinds = append(kinds, OpDelete)
+	}
+	if op.Trim != nil {
+		kinds = append(kinds, OpTrim)
+	}
+	if op.Shift != nil {
+		kinds = append(kinds, OpShift)
+	}
+	if op.PubTime != nil {
+		kinds = append(kinds, OpPubTime)
+	}
+	if op.Attachments != nil {
+		kinds = append(kinds, OpAttachments)
+	}
+	if op.Metadata != nil {
+		kinds = append(kinds, OpMetadata)
+	}
+	if op.Compress != nil {
+		kinds = append(kinds, OpCompress)
+	}
+	return kinds
+}
+
+// Validate checks that exactly one operation is set and that its parameters
+// are well formed.
+func (op *JobOperation) Validate() error {
+	kinds := op.kinds()
+	if len(kinds) != 1 {
+		return fmt.Errorf("expected exactly one of %s, %s, got %d",
+			strings.Join(DefaultOrder, ", "), OpCompress, len(kinds))
+	}
+
+	switch kinds[0] {
+	case OpRename:
+		for oldTopic, newTopic := range op.Rename {
+			if !strings.HasPrefix(newTopic, "/") {
+				return fmt.Errorf("invalid new topic name for %s: %s", oldTopic, newTopic)
+			}
+		}
+	case OpTrim:
+		start, end, err := op.Trim.bounds()
+		if err != nil {
+			return err
+		}
+		if start == 0 && end == 0 {
+			return fmt.Errorf("trim requires a start or an end")
+		}
+		if start != 0 && end != 0 && end < start {
+			return fmt.Errorf("trim end time [%d] is before trim start time [%d]", end, start)
+		}
+	case OpShift:
+		if _, _, err := op.Shift.durations(); err != nil {
+			return err
+		}
+	case OpAttachments:
+		return op.Attachments.validate()
+	case OpMetadata:
+		return op.Metadata.validate()
+	case OpCompress:
+		if _, err := utils.NewWriterOptions(op.Compress.Format, op.Compress.Level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transformer builds the transformer for the operation, or nil for OpCompress.
+func (op *JobOperation) Transformer(opts *Options) (Transformer, error) {
+	switch op.Kind() {
+	case OpRename:
+		return &RenameTopics{Mapping: op.Rename}, nil
+	case OpDelete:
+		return &DeleteTopics{Topics: op.Delete}, nil
+	case OpTrim:
+		start, end, err := op.Trim.bounds()
+		if err != nil {
+			return nil, err
+		}
+		return &TrimTime{Start: start, End: end, Attachments: op.Trim.Attachments}, nil
+	case OpShift:
+		logShift, publishShift, err := op.Shift.durations()
+		if err != nil {
+			return nil, err
+		}
+		return &ShiftTime{Log: logShift, Publish: publishShift, Topics: op.Shift.Topics}, nil
+	case OpPubTime:
+		if !*op.PubTime {
+			return &Passthrough{}, nil
+		}
+		return &PublishTimeStamp{Logger: opts.logger()}, nil
+	case OpAttachments:
+		return &FilterAttachments{Drop: op.Attachments.Drop, Names: op.Attachments.Names}, nil
+	case OpMetadata:
+		return &FilterMetadata{Drop: op.Metadata.Drop, Names: op.Metadata.Names}, nil
+	case OpCompress:
+		return nil, nil
+	default:
+		return nil, op.Validate()
+	}
+}
+
+func (j *Job) hasTransforms() bool {
+	for _, op := range j.Operations {
+		if op.Kind() != OpCompress {
+			return true
+		}
+	}
+	return false
+}
+
+// Compression returns the last compress operation of the job, or nil.
+func (j *Job) Compression() *JobCompress {
+	var compress *JobCompress
+	for _, op := range j.Operations {
+		if op.Compress != nil {
+			compress = op.Compress
+		}
+	}
+	return compress
+}
+
+func (t *JobTrim) bounds() (uint64, uint64, error) {
+	var start, end int64
+	var err error
+	if t.Start != "" {
+		start, err = utils.TryParseTimestamp(t.Start)
+		if err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("invalid trim start time: %s", t.Start)
+		}
+	}
+	if t.End != "" {
+		end, err = utils.TryParseTimestamp(t.End)
+		if err != nil || end < 0 {
+			return 0, 0, fmt.Errorf("invalid trim end time: %s", t.End)
+		}
+	}
+	return uint64(start), uint64(end), nil
+}
+
+func (s *JobShift) durations() (time.Duration, time.Duration, error) {
+	var logShift, publishShift time.Duration
+	var err error
+	if s.Log != "" {
+		logShift, err = time.ParseDuration(s.Log)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid shift log time %s: %s", s.Log, err)
+		}
+	}
+	if s.Publish != "" {
+		publishShift, err = time.ParseDuration(s.Publish)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid shift publish time %s: %s", s.Publish, err)
+		}
+	}
+	if logShift == 0 && publishShift == 0 {
+		return 0, 0, fmt.Errorf("shift requires a log or a publish duration")
+	}
+	return logShift, publishShift, nil
+}
+
+func (f *JobFilter) validate() error {
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid name pattern: %s", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseJob(t *testing.T) {
+	job, err := ParseJob([]byte(`
+operations:
+  - rename: {/a: /renamed}
+  - shift: {log: 1ns, topics: [/renamed]}
+  - compress: {format: lz4, level: 1}
+`))
+	assert.NoError(t, err)
+	assert.Len(t, job.Operations, 3)
+	assert.Equal(t, OpShift, job.Operations[1].Kind())
+	assert.Equal(t, &JobCompress{Format: "lz4", Level: 1}, job.Compression())
+
+	jsonJob, err := ParseJob([]byte(`{"operations": [{"delete": ["/b"]}, {"trim": {"start": "120"}}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, OpDelete, jsonJob.Operations[0].Kind())
+
+	out := &bytes.Buffer{}
+	err = Transform(context.Background(), bytes.NewReader(writeTestFile(t)), out, Options{Job: job})
+	assert.NoError(t, err)
+
+	msgs, _ := readTestFile(t, out.Bytes())
+	assert.Equal(t, readMessage{"/renamed", 101, 100}, msgs[0])
+}
+
+func TestParseJobInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown key":        `operations: [{rename: {/a: /b}, extra: 1}]`,
+		"unknown operation":  `operations: [{compression: lz4}]`,
+		"several operations": `operations: [{rename: {/a: /b}, delete: [/c]}]`,
+		"empty operation":    `operations: [{}]`,
+		"bad duration":       `operations: [{shift: {log: soon}}]`,
+		"bad timestamp":      `operations: [{trim: {start: yesterday}}]`,
+		"bad topic":          `operations: [{rename: {/a: b}}]`,
+		"bad compression":    `operations: [{compress: {format: gzip}}]`,
+	}
+	for name, data := range cases {
+		_, err := ParseJob([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
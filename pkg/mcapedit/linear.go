@@ -0,0 +1,129 @@
+package mcapedit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+)
+
+// transformLinear applies the chain to the records of the MCAP stream r,
+// read from its start without the summary section. Metadata and attachments
+// are copied where they appear in the data section instead of through the
+// summary indexes. The message bounds are computed along the way, so trim
+// windows missing the messages are reported once the scan is done.
+func transformLinear(ctx context.Context, r io.Reader, out *serialWriter, opts *Options) error {
+	lexer, err := mcap.NewLexer(r, &mcap.LexerOptions{
+		ValidateChunkCRCs: true,
+		AttachmentCallback: func(ar *mcap.AttachmentReader) error {
+			attachment, err := out.c.attachment(&mcap.Attachment{
+				LogTime:    ar.LogTime,
+				CreateTime: ar.CreateTime,
+				Name:       ar.Name,
+				MediaType:  ar.MediaType,
+				DataSize:   ar.DataSize,
+				Data:       ar.Data(),
+			})
+			if err != nil || attachment == nil {
+				return err
+			}
+			if err := out.writer.WriteAttachment(attachment); err != nil {
+				return fmt.Errorf("failed to write attachment %s: %s", attachment.Name, err)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create lexer: %s", err)
+	}
+	defer lexer.Close()
+
+	schemas := map[uint16]*mcap.Schema{}
+	channels := map[uint16]*mcap.Channel{}
+	var messageCount, msgLogStart, msgLogEnd uint64
+
+	for count := 0; ; count++ {
+		if count%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		token, data, err := lexer.Next(nil)
+		if err != nil {
+			// A file cut at a record boundary, as left by a stopped
+			// streaming writer, simply ends.
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read records (the recover subcommand may salvage the file): %s", err)
+		}
+
+		switch token {
+		case mcap.TokenSchema:
+			schema, err := mcap.ParseSchema(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse schema: %s", err)
+			}
+			schemas[schema.ID] = schema
+		case mcap.TokenChannel:
+			channel, err := mcap.ParseChannel(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse channel: %s", err)
+			}
+			channels[channel.ID] = channel
+		case mcap.TokenMessage:
+			msg, err := mcap.ParseMessage(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse message: %s", err)
+			}
+			channel, ok := channels[msg.ChannelID]
+			if !ok {
+				return fmt.Errorf("message on unknown channel %d", msg.ChannelID)
+			}
+			schema, ok := schemas[channel.SchemaID]
+			if !ok && channel.SchemaID != 0 {
+				return fmt.Errorf("channel %s has unknown schema %d", channel.Topic, channel.SchemaID)
+			}
+
+			if messageCount == 0 || msg.LogTime < msgLogStart {
+				msgLogStart = msg.LogTime
+			}
+			if messageCount == 0 || msg.LogTime > msgLogEnd {
+				msgLogEnd = msg.LogTime
+			}
+			messageCount++
+
+			if err := out.message(schema, channel, msg); err != nil {
+				return err
+			}
+		case mcap.TokenMetadata:
+			metadata, err := mcap.ParseMetadata(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse metadata: %s", err)
+			}
+			metadata, err = out.c.metadata(metadata)
+			if err != nil {
+				return err
+			}
+			if metadata == nil {
+				continue
+			}
+			if err := out.writer.WriteMetadata(metadata); err != nil {
+				return fmt.Errorf("failed to write metadata %s: %s", metadata.Name, err)
+			}
+		}
+		if token == mcap.TokenDataEnd || token == mcap.TokenFooter {
+			break
+		}
+	}
+
+	opts.logger().Debug(fmt.Sprintf("scanned %d messages logged between [%d] and [%d]", messageCount, msgLogStart, msgLogEnd))
+	if messageCount > 0 {
+		if err := checkTrimBounds(opts, msgLogStart, msgLogEnd); err != nil {
+			opts.logger().Warn(err.Error())
+		}
+	}
+	return nil
+}
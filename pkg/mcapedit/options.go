@@ -0,0 +1,229 @@
+package mcapedit
+
+import (
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Names of the built-in operations, used to order them with Options.Order.
+const (
+	OpTrim        = "trim"
+	OpDelete      = "delete"
+	OpShift       = "shift"
+	OpPubTime     = "pub-time"
+	OpRename      = "rename"
+	OpAttachments = "attachments"
+	OpMetadata    = "metadata"
+)
+
+// DefaultOrder is the order built-in operations are applied in when they are
+// not listed in Options.Order. Shifts and header stamps are applied before
+// renames, so Options.Topics refers to the original topic names.
+var DefaultOrder = []string{OpTrim, OpDelete, OpShift, OpPubTime, OpRename, OpAttachments, OpMetadata}
+
+// Options describes the edits Transform applies to an MCAP stream. The zero
+// value copies the input unchanged.
+type Options struct {
+	// Rename maps old topic names to new ones.
+	Rename map[string]string
+	// Delete lists topics removed from the output.
+	Delete []string
+
+	// TrimStart and TrimEnd bound the kept messages by log time in
+	// nanoseconds, both inclusive. Zero leaves that side unbounded.
+	TrimStart uint64
+	TrimEnd   uint64
+
+	// ShiftLog and ShiftPublish are added to message log and publish times.
+	ShiftLog     time.Duration
+	ShiftPublish time.Duration
+	// Topics limits the shifts to these topics. Empty means every topic.
+	Topics []string
+
+	// UsePublishTime overwrites the std_msgs/Header stamp of ROS 1 and ROS 2
+	// messages with their publish time.
+	UsePublishTime bool
+
+	// DropAttachments and DropMetadata skip copying those records.
+	DropAttachments bool
+	DropMetadata    bool
+	// AttachmentNames and MetadataNames are glob patterns of record names to
+	// keep. Empty means every record.
+	AttachmentNames []string
+	MetadataNames   []string
+	// TrimAttachments drops attachments whose log time is outside of the trim window.
+	TrimAttachments bool
+
+	// Order lists built-in operation names (OpTrim, OpRename, ...) in the
+	// order they are to be applied. Unlisted operations follow in DefaultOrder.
+	Order []string
+	// Job operations are applied after the built-in operations set by the
+	// fields above, in the order of the job.
+	Job *Job
+	// Transformers are applied after the built-in and job operations. Transform
+	// processes the chunks of a file concurrently unless Workers is 1, and may
+	// run concurrently on several files, so they must be safe for concurrent use.
+	Transformers []Transformer
+
+	// WriterOptions configures the output writer. Nil uses the zstd defaults.
+	WriterOptions *mcap.WriterOptions
+	// Recompress decodes and compresses every chunk again with WriterOptions.
+	// Otherwise, when the edits only drop messages or rewrite channel records,
+	// compressed chunks are copied as they are and only the chunks holding
+	// changed records are rebuilt.
+	Recompress bool
+	// Workers is the number of goroutines decompressing, transforming and
+	// compressing the chunks of an indexed input. Zero uses runtime.NumCPU(),
+	// one processes the messages of the file in a single pass.
+	Workers int
+
+	// Logger receives warnings about skipped records. Nil uses slog.Default().
+	Logger *slog.Logger
+	// Progress, if set, is updated with the bytes and messages processed. A
+	// Progress is meant for a single Transform call.
+	Progress *Progress
+}
+
+// IsNoop reports whether the options leave the content of the input unchanged,
+// apart from re-compressing it.
+func (o *Options) IsNoop() bool {
+	return len(o.Rename) == 0 && len(o.Delete) == 0 && o.TrimStart == 0 &&
+		o.TrimEnd == 0 && o.ShiftLog == 0 && o.ShiftPublish == 0 &&
+		!o.UsePublishTime && !o.DropAttachments && !o.DropMetadata &&
+		len(o.AttachmentNames) == 0 && len(o.MetadataNames) == 0 &&
+		len(o.Transformers) == 0 && (o.Job == nil || !o.Job.hasTransforms())
+}
+
+// Validate checks the options for mistakes that do not depend on the input.
+func (o *Options) Validate() error {
+	for oldTopic, newTopic := range o.Rename {
+		if !strings.HasPrefix(newTopic, "/") {
+			return fmt.Errorf("invalid new topic name for %s: %s", oldTopic, newTopic)
+		}
+	}
+
+	if o.TrimStart != 0 && o.TrimEnd != 0 && o.TrimEnd < o.TrimStart {
+		return fmt.Errorf("trim end time [%d] is before trim start time [%d]", o.TrimEnd, o.TrimStart)
+	}
+
+	if o.Workers < 0 {
+		return fmt.Errorf("invalid number of workers: %d", o.Workers)
+	}
+
+	if o.Job != nil {
+		if err := o.Job.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, op := range o.Order {
+		if !slices.Contains(DefaultOrder, op) {
+			return fmt.Errorf("unknown operation %s, expected one of %s", op, strings.Join(DefaultOrder, ", "))
+		}
+	}
+
+	for _, pattern := range o.AttachmentNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid attachment name pattern: %s", pattern)
+		}
+	}
+
+	for _, pattern := range o.MetadataNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid metadata name pattern: %s", pattern)
+		}
+	}
+
+	return nil
+}
+
+// Pipeline returns the transformers described by the options, built-in
+// operations first in the requested order, then the job operations and
+// finally Options.Transformers.
+// Built-in transformers hold per-file state, call Pipeline for every file.
+func (o *Options) Pipeline() ([]Transformer, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	order := slices.Clone(o.Order)
+	for _, op := range DefaultOrder {
+		if !slices.Contains(order, op) {
+			order = append(order, op)
+		}
+	}
+
+	transformers := make([]Transformer, 0, len(order)+len(o.Transformers))
+	for _, op := range order {
+		switch op {
+		case OpTrim:
+			if o.TrimStart != 0 || o.TrimEnd != 0 {
+				transformers = append(transformers, &TrimTime{
+					Start:       o.TrimStart,
+					End:         o.TrimEnd,
+					Attachments: o.TrimAttachments,
+				})
+			}
+		case OpDelete:
+			if len(o.Delete) > 0 {
+				transformers = append(transformers, &DeleteTopics{Topics: o.Delete})
+			}
+		case OpShift:
+			if o.ShiftLog != 0 || o.ShiftPublish != 0 {
+				transformers = append(transformers, &ShiftTime{
+					Log:     o.ShiftLog,
+					Publish: o.ShiftPublish,
+					Topics:  o.Topics,
+				})
+			}
+		case OpPubTime:
+			if o.UsePublishTime {
+				transformers = append(transformers, &PublishTimeStamp{Logger: o.logger()})
+			}
+		case OpRename:
+			if len(o.Rename) > 0 {
+				transformers = append(transformers, &RenameTopics{Mapping: o.Rename})
+			}
+		case OpAttachments:
+			if o.DropAttachments || len(o.AttachmentNames) > 0 {
+				transformers = append(transformers, &FilterAttachments{
+					Drop:  o.DropAttachments,
+					Names: o.AttachmentNames,
+				})
+			}
+		case OpMetadata:
+			if o.DropMetadata || len(o.MetadataNames) > 0 {
+				transformers = append(transformers, &FilterMetadata{
+					Drop:  o.DropMetadata,
+					Names: o.MetadataNames,
+				})
+			}
+		}
+	}
+
+	if o.Job != nil {
+		for _, op := range o.Job.Operations {
+			t, err := op.Transformer(o)
+			if err != nil {
+				return nil, err
+			}
+			if t != nil {
+				transformers = append(transformers, t)
+			}
+		}
+	}
+
+	return append(transformers, o.Transformers...), nil
+}
+
+func (o *Options) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,421 @@
+package mcapedit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"maps"
+	"mcap-utility/internal/utils"
+	"slices"
+	"strings"
+)
+
+// Plan describes what Transform would do to a file, without writing anything.
+type Plan struct {
+	// Topics lists the channels of the input, sorted by topic.
+	Topics []TopicPlan
+	// Messages and KeptMessages are the number of messages of the input and
+	// of the output.
+	Messages     uint64
+	KeptMessages uint64
+	// MessageStartTime and MessageEndTime bound the log times of the input
+	// messages, OutputStartTime and OutputEndTime those of the output
+	// messages, shifts applied.
+	MessageStartTime uint64
+	MessageEndTime   uint64
+	OutputStartTime  uint64
+	OutputEndTime    uint64
+	// Attachments and Metadata are the number of records of the input, the
+	// Kept ones the number written to the output.
+	Attachments         int
+	KeptAttachments     int
+	Metadata            int
+	KeptMetadata        int
+	InputSize           int64
+	EstimatedOutputSize int64
+	// Scanned is set when the input has no message indexes and its
+	// messages were read to build the plan.
+	Scanned bool
+	// Err is the error Transform would fail with before writing messages,
+	// such as a trim window outside of the file.
+	Err error
+}
+
+// TopicPlan describes what Transform would do to the messages of a channel.
+type TopicPlan struct {
+	Topic string
+	// NewTopic is the topic of the output channel, or empty if the channel
+	// is removed.
+	NewTopic string
+	Messages uint64
+	// KeptMessages is the number of messages written to the output, those
+	// of a kept channel outside of a trim window excluded.
+	KeptMessages uint64
+}
+
+// Removed reports whether the channel is removed from the output.
+func (t *TopicPlan) Removed() bool {
+	return t.NewTopic == ""
+}
+
+// Renamed reports whether the channel is written with another topic.
+func (t *TopicPlan) Renamed() bool {
+	return t.NewTopic != "" && t.NewTopic != t.Topic
+}
+
+// TrimmedMessages returns the number of messages of the kept channels that
+// are dropped, outside of a trim window.
+func (p *Plan) TrimmedMessages() uint64 {
+	var trimmed uint64
+	for _, topic := range p.Topics {
+		if !topic.Removed() {
+			trimmed += topic.Messages - topic.KeptMessages
+		}
+	}
+	return trimmed
+}
+
+// logTimeOnly applies the message edits of a transformer that only depend on
+// the channel and log time of messages, the part of a message known from the
+// message indexes. Other message edits are assumed to keep every message.
+type logTimeOnly struct {
+	Transformer
+}
+
+func (t logTimeOnly) Message(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	switch t.Transformer.(type) {
+	case *TrimTime, *ShiftTime:
+		return t.Transformer.Message(schema, channel, msg)
+	}
+	return msg, nil
+}
+
+// planner accumulates the messages of a plan.
+type planner struct {
+	plan     *Plan
+	c        *chain
+	channels map[uint16]*TopicPlan
+	// timed is set when messages are trimmed or shifted depending on their
+	// log time.
+	timed bool
+}
+
+// topic returns the plan of the channel, run through the chain on first use.
+func (p *planner) topic(schema *mcap.Schema, channel *mcap.Channel) (*TopicPlan, error) {
+	if topic, ok := p.channels[channel.ID]; ok {
+		return topic, nil
+	}
+	stages, err := p.c.channel(schema, channel)
+	if err != nil {
+		return nil, err
+	}
+	topic := &TopicPlan{Topic: channel.Topic}
+	if out := stages[len(stages)-1].channel; out != nil {
+		topic.NewTopic = out.Topic
+	}
+	p.channels[channel.ID] = topic
+	return topic, nil
+}
+
+// message counts a message of the input, run through the chain with the
+// given log time, and reports whether it is kept.
+func (p *planner) message(schema *mcap.Schema, channel *mcap.Channel, logTime uint64) (bool, error) {
+	topic, err := p.topic(schema, channel)
+	if err != nil {
+		return false, err
+	}
+	topic.Messages++
+	p.plan.Messages++
+
+	if topic.Removed() {
+		return false, nil
+	}
+	msg, err := p.c.message(p.c.channels[channel.ID], &mcap.Message{ChannelID: channel.ID, LogTime: logTime, PublishTime: logTime})
+	if err != nil || msg == nil {
+		return false, err
+	}
+	topic.KeptMessages++
+	if p.plan.KeptMessages == 0 || msg.LogTime < p.plan.OutputStartTime {
+		p.plan.OutputStartTime = msg.LogTime
+	}
+	p.plan.OutputEndTime = max(p.plan.OutputEndTime, msg.LogTime)
+	p.plan.KeptMessages++
+	return true, nil
+}
+
+// PlanTransform computes what Transform would do to the MCAP stream r with
+// opts, from its summary and message indexes: chunks are not decompressed.
+// Files without a summary are read linearly instead.
+//
+// Messages are run through the transformers with their channel and log time
+// only, so transformers dropping messages depending on their content, which
+// none of the built-in ones do, are not accounted for. The output size is
+// estimated from the share of messages kept in each chunk, at the same
+// compression ratio.
+func PlanTransform(ctx context.Context, r io.ReadSeeker, opts Options) (*Plan, error) {
+	transformers, err := opts.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	timed := false
+	planTransformers := make([]Transformer, 0, len(transformers))
+	for _, t := range transformers {
+		switch t.(type) {
+		case *TrimTime, *ShiftTime:
+			timed = true
+		}
+		planTransformers = append(planTransformers, logTimeOnly{t})
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader, err := mcap.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new reader: %s", err)
+	}
+	defer reader.Close()
+
+	p := &planner{
+		plan:     &Plan{InputSize: size},
+		c:        newChain(planTransformers),
+		channels: map[uint16]*TopicPlan{},
+		timed:    timed,
+	}
+
+	mcapInfo, err := reader.Info()
+	if err != nil || len(mcapInfo.ChunkIndexes) == 0 {
+		if err := p.scan(ctx, r); err != nil {
+			return nil, err
+		}
+	} else if err := p.indexed(ctx, r, mcapInfo); err != nil {
+		return nil, err
+	}
+
+	plan := p.plan
+	for _, topic := range p.channels {
+		plan.Topics = append(plan.Topics, *topic)
+	}
+	slices.SortStableFunc(plan.Topics, func(a, b TopicPlan) int {
+		return strings.Compare(a.Topic, b.Topic)
+	})
+	if plan.Messages > 0 {
+		err := checkTrimBounds(&opts, plan.MessageStartTime, plan.MessageEndTime)
+		if err != nil {
+			plan.Err = err
+		}
+	}
+	return plan, nil
+}
+
+// indexed fills the plan from the summary of the file, and from its message
+// indexes unless every message is kept unchanged.
+func (p *planner) indexed(ctx context.Context, r io.ReadSeeker, mcapInfo *mcap.Info) error {
+	plan := p.plan
+	removed := false
+	for _, channel := range mcapInfo.Channels {
+		topic, err := p.topic(mcapInfo.Schemas[channel.SchemaID], channel)
+		if err != nil {
+			return err
+		}
+		removed = removed || topic.Removed()
+	}
+
+	var chunkBytes, keptChunkBytes float64
+	for _, idx := range mcapInfo.ChunkIndexes {
+		chunkBytes += float64(idx.ChunkLength + idx.MessageIndexLength)
+	}
+	stats := mcapInfo.Statistics
+	if !p.timed && !removed && stats != nil && len(stats.ChannelMessageCounts) > 0 {
+		// The statistics are enough when all messages are kept as they are.
+		for channelID, count := range stats.ChannelMessageCounts {
+			if topic, ok := p.channels[channelID]; ok {
+				topic.Messages, topic.KeptMessages = count, count
+			}
+		}
+		plan.Messages, plan.KeptMessages = stats.MessageCount, stats.MessageCount
+		if stats.MessageCount > 0 {
+			plan.MessageStartTime, plan.MessageEndTime = stats.MessageStartTime, stats.MessageEndTime
+			plan.OutputStartTime, plan.OutputEndTime = stats.MessageStartTime, stats.MessageEndTime
+		}
+		keptChunkBytes = chunkBytes
+	} else {
+		for count, idx := range mcapInfo.ChunkIndexes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			kept, err := p.chunk(r, mcapInfo, idx)
+			if err != nil {
+				return fmt.Errorf("failed to read message indexes of chunk %d: %s", count, err)
+			}
+			keptChunkBytes += float64(idx.ChunkLength+idx.MessageIndexLength) * kept
+		}
+	}
+
+	var recordBytes, keptRecordBytes float64
+	for _, idx := range mcapInfo.AttachmentIndexes {
+		plan.Attachments++
+		recordBytes += float64(idx.Length)
+		attachment, err := p.c.attachment(&mcap.Attachment{
+			LogTime:    idx.LogTime,
+			CreateTime: idx.CreateTime,
+			Name:       idx.Name,
+			MediaType:  idx.MediaType,
+			DataSize:   idx.DataSize,
+		})
+		if err != nil {
+			return err
+		}
+		if attachment != nil {
+			plan.KeptAttachments++
+			keptRecordBytes += float64(idx.Length)
+		}
+	}
+	for _, idx := range mcapInfo.MetadataIndexes {
+		plan.Metadata++
+		recordBytes += float64(idx.Length)
+		metadata, err := p.c.metadata(&mcap.Metadata{Name: idx.Name})
+		if err != nil {
+			return err
+		}
+		if metadata != nil {
+			plan.KeptMetadata++
+			keptRecordBytes += float64(idx.Length)
+		}
+	}
+
+	other := max(float64(plan.InputSize)-chunkBytes-recordBytes, 0)
+	plan.EstimatedOutputSize = int64(other + keptChunkBytes + keptRecordBytes)
+	return nil
+}
+
+// chunk counts the messages of the chunk of idx from its message indexes,
+// and returns the share of them that is kept.
+func (p *planner) chunk(r io.ReadSeeker, mcapInfo *mcap.Info, idx *mcap.ChunkIndex) (float64, error) {
+	messageIndexes, err := readMessageIndexes(r, idx)
+	if err != nil {
+		return 0, err
+	}
+
+	plan := p.plan
+	var total, kept int
+	for _, messageIndex := range messageIndexes {
+		channel, ok := mcapInfo.Channels[messageIndex.ChannelID]
+		if !ok {
+			return 0, fmt.Errorf("message index of unknown channel %d", messageIndex.ChannelID)
+		}
+		schema := mcapInfo.Schemas[channel.SchemaID]
+		for _, entry := range messageIndex.Records {
+			if plan.Messages == 0 || entry.Timestamp < plan.MessageStartTime {
+				plan.MessageStartTime = entry.Timestamp
+			}
+			plan.MessageEndTime = max(plan.MessageEndTime, entry.Timestamp)
+			isKept, err := p.message(schema, channel, entry.Timestamp)
+			if err != nil {
+				return 0, err
+			}
+			total++
+			if isKept {
+				kept++
+			}
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(kept) / float64(total), nil
+}
+
+// scan fills the plan by reading every message of a file without indexes.
+func (p *planner) scan(ctx context.Context, r io.ReadSeeker) error {
+	plan := p.plan
+	plan.Scanned = true
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader, msgs, _, err := utils.ReadMessages(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var dataBytes, keptDataBytes int64
+	msg := &mcap.Message{}
+	for count := 0; ; count++ {
+		if count%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		schema, channel, _, err := msgs.NextInto(msg)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		if plan.Messages == 0 || msg.LogTime < plan.MessageStartTime {
+			plan.MessageStartTime = msg.LogTime
+		}
+		plan.MessageEndTime = max(plan.MessageEndTime, msg.LogTime)
+		kept, err := p.message(schema, channel, msg.LogTime)
+		if err != nil {
+			return err
+		}
+		dataBytes += int64(len(msg.Data))
+		if kept {
+			keptDataBytes += int64(len(msg.Data))
+		}
+	}
+
+	plan.EstimatedOutputSize = plan.InputSize
+	if dataBytes > 0 {
+		plan.EstimatedOutputSize = int64(float64(plan.InputSize) * float64(keptDataBytes) / float64(dataBytes))
+	}
+	return nil
+}
+
+// readMessageIndexes reads the message index records following the chunk of
+// idx.
+func readMessageIndexes(r io.ReadSeeker, idx *mcap.ChunkIndex) ([]*mcap.MessageIndex, error) {
+	if len(idx.MessageIndexOffsets) == 0 {
+		return nil, nil
+	}
+	start := slices.Min(slices.Collect(maps.Values(idx.MessageIndexOffsets)))
+	if _, err := r.Seek(int64(start), io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, idx.MessageIndexLength)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	var messageIndexes []*mcap.MessageIndex
+	for len(data) > 0 {
+		if len(data) < 9 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		length := binary.LittleEndian.Uint64(data[1:9])
+		if uint64(len(data)-9) < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if mcap.OpCode(data[0]) == mcap.OpMessageIndex {
+			messageIndex, err := mcap.ParseMessageIndex(data[9 : 9+length])
+			if err != nil {
+				return nil, err
+			}
+			messageIndexes = append(messageIndexes, messageIndex)
+		}
+		data = data[9+length:]
+	}
+	return messageIndexes, nil
+}
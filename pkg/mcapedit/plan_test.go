@@ -0,0 +1,78 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPlanTransform(t *testing.T) {
+	data := writeTestFile(t)
+	plan, err := PlanTransform(context.Background(), bytes.NewReader(data), Options{
+		Rename:       map[string]string{"/a": "/renamed"},
+		Delete:       []string{"/b"},
+		TrimStart:    120,
+		TrimEnd:      150,
+		ShiftLog:     time.Second,
+		DropMetadata: true,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, plan.Err)
+	assert.False(t, plan.Scanned)
+	assert.Equal(t, []TopicPlan{
+		{Topic: "/a", NewTopic: "/renamed", Messages: 10, KeptMessages: 4},
+		{Topic: "/b", Messages: 10},
+	}, plan.Topics)
+	assert.Equal(t, uint64(20), plan.Messages)
+	assert.Equal(t, uint64(4), plan.KeptMessages)
+	assert.Equal(t, uint64(6), plan.TrimmedMessages())
+	assert.Equal(t, [2]uint64{100, 190}, [2]uint64{plan.MessageStartTime, plan.MessageEndTime})
+	assert.Equal(t, [2]uint64{1e9 + 120, 1e9 + 150}, [2]uint64{plan.OutputStartTime, plan.OutputEndTime})
+	assert.Equal(t, 1, plan.Metadata)
+	assert.Equal(t, 0, plan.KeptMetadata)
+	assert.Equal(t, int64(len(data)), plan.InputSize)
+	assert.Less(t, plan.EstimatedOutputSize, plan.InputSize)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, Transform(context.Background(), bytes.NewReader(data), out, Options{
+		Rename:    map[string]string{"/a": "/renamed"},
+		Delete:    []string{"/b"},
+		TrimStart: 120,
+		TrimEnd:   150,
+	}))
+	msgs, _ := readTestFile(t, out.Bytes())
+	assert.Len(t, msgs, int(plan.KeptMessages))
+}
+
+func TestPlanTransformStatistics(t *testing.T) {
+	data := writeTestFile(t)
+	plan, err := PlanTransform(context.Background(), bytes.NewReader(data), Options{
+		Rename: map[string]string{"/b": "/renamed"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []TopicPlan{
+		{Topic: "/a", NewTopic: "/a", Messages: 10, KeptMessages: 10},
+		{Topic: "/b", NewTopic: "/renamed", Messages: 10, KeptMessages: 10},
+	}, plan.Topics)
+	assert.Equal(t, [2]uint64{100, 190}, [2]uint64{plan.OutputStartTime, plan.OutputEndTime})
+	assert.Equal(t, plan.InputSize, plan.EstimatedOutputSize)
+}
+
+func TestPlanTransformWithoutSummary(t *testing.T) {
+	data := writeStreamingTestFile(t, &mcap.WriterOptions{}, true)
+	plan, err := PlanTransform(context.Background(), bytes.NewReader(data), Options{
+		Delete:    []string{"/b"},
+		TrimStart: 120,
+	})
+	assert.NoError(t, err)
+	assert.True(t, plan.Scanned)
+	assert.Equal(t, uint64(8), plan.KeptMessages)
+	assert.Equal(t, uint64(2), plan.TrimmedMessages())
+
+	plan, err = PlanTransform(context.Background(), bytes.NewReader(data), Options{TrimStart: 500})
+	assert.NoError(t, err)
+	assert.ErrorContains(t, plan.Err, "is after message end time")
+}
@@ -0,0 +1,44 @@
+package mcapedit
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Progress counts the work done by Transform. It is updated while Transform
+// runs and may be read concurrently.
+type Progress struct {
+	bytes    atomic.Int64
+	messages atomic.Uint64
+}
+
+// Bytes returns the number of input bytes read so far. Chunks skipped by a
+// trim are never read, and the summary is read on top of the data, so it
+// only approximates the position in the input.
+func (p *Progress) Bytes() int64 {
+	return p.bytes.Load()
+}
+
+// Messages returns the number of input messages processed so far, messages
+// of the chunks skipped by a trim excluded.
+func (p *Progress) Messages() uint64 {
+	return p.messages.Load()
+}
+
+func (p *Progress) addMessages(count uint64) {
+	if p != nil {
+		p.messages.Add(count)
+	}
+}
+
+// progressReader counts the bytes read from r into progress.
+type progressReader struct {
+	io.ReadSeeker
+	progress *Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(b)
+	r.progress.bytes.Add(int64(n))
+	return n, err
+}
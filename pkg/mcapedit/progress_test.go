@@ -0,0 +1,34 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestTransformProgress(t *testing.T) {
+	data := writeTestFile(t)
+	for name, opts := range map[string]Options{
+		"copy":    {Rename: map[string]string{"/a": "/renamed"}},
+		"rebuild": {Delete: []string{"/b"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Progress = &Progress{}
+			assert.NoError(t, Transform(context.Background(), bytes.NewReader(data), io.Discard, opts))
+			assert.Equal(t, uint64(20), opts.Progress.Messages())
+			assert.GreaterOrEqual(t, opts.Progress.Bytes(), int64(len(data)))
+		})
+	}
+
+	data = writeStreamingTestFile(t, &mcap.WriterOptions{}, true)
+	progress := &Progress{}
+	assert.NoError(t, Transform(context.Background(), bytes.NewReader(data), io.Discard, Options{
+		Delete:   []string{"/b"},
+		Progress: progress,
+	}))
+	assert.Equal(t, uint64(20), progress.Messages())
+	assert.GreaterOrEqual(t, progress.Bytes(), int64(len(data)))
+}
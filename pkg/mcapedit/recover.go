@@ -0,0 +1,326 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"mcap-utility/internal/utils"
+)
+
+// RecoverReport describes what Recover kept from a damaged stream.
+type RecoverReport struct {
+	// Recovered is the number of input bytes up to the end of the last
+	// record kept. Everything after it is lost.
+	Recovered int64
+	// Complete is set when the whole data section could be read.
+	Complete bool
+	// Reason tells why the scan stopped early. It is empty when Complete.
+	Reason string
+
+	Schemas     int
+	Channels    int
+	Chunks      int
+	Attachments int
+	Metadata    int
+	Messages    uint64
+	// MessageStartTime and MessageEndTime bound the log times of the kept
+	// messages.
+	MessageStartTime uint64
+	MessageEndTime   uint64
+	// SkippedMessages counts the messages left out because their channel
+	// record was not found.
+	SkippedMessages uint64
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// recoverer writes the records of a damaged stream kept by Recover.
+type recoverer struct {
+	writer   *mcap.Writer
+	report   *RecoverReport
+	schemas  map[uint16]bool
+	channels map[uint16]bool
+	header   bool
+	// writeErr is set when writing fails, which ends the recovery with an
+	// error rather than a report.
+	writeErr error
+}
+
+// Recover scans the MCAP stream r linearly from its start, without using
+// its summary section, and writes every complete record it can decode to w
+// as a new, fully indexed file. Chunks are kept whole or not at all: the
+// scan stops at the first truncated or corrupt record, or chunk failing its
+// CRC. Records of the summary section are rebuilt rather than copied.
+//
+// An error is only returned when nothing could be recovered, e.g. the magic
+// bytes or header are invalid, or writing to w fails.
+func Recover(ctx context.Context, r io.Reader, w io.Writer, writerOpt *mcap.WriterOptions) (*RecoverReport, error) {
+	if writerOpt == nil {
+		var err error
+		writerOpt, err = utils.NewWriterOptions("", 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	counter := &countingReader{r: r}
+	writer, err := mcap.NewWriter(w, writerOpt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new writer: %s", err)
+	}
+
+	rec := &recoverer{
+		writer:   writer,
+		report:   &RecoverReport{},
+		schemas:  map[uint16]bool{},
+		channels: map[uint16]bool{},
+	}
+
+	lexer, err := mcap.NewLexer(counter, &mcap.LexerOptions{
+		EmitChunks:            true,
+		ComputeAttachmentCRCs: true,
+		AttachmentCallback: func(ar *mcap.AttachmentReader) error {
+			if err := rec.attachment(ar); err != nil {
+				return err
+			}
+			rec.report.Recovered = counter.n
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read magic: %s", err)
+	}
+	defer lexer.Close()
+	rec.report.Recovered = counter.n
+
+	for n := 0; ; n++ {
+		if n%contextCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		offset := rec.report.Recovered
+		token, data, err := lexer.Next(nil)
+		if rec.writeErr != nil {
+			return nil, rec.writeErr
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				rec.report.Reason = "file ends without a data end record"
+			} else {
+				rec.report.Reason = fmt.Sprintf("record at offset %d: %s", offset, err)
+			}
+			break
+		}
+
+		done, err := rec.record(token, data)
+		if rec.writeErr != nil {
+			return nil, rec.writeErr
+		}
+		if err != nil {
+			rec.report.Reason = fmt.Sprintf("%s at offset %d: %s", tokenName(token), offset, err)
+			break
+		}
+		rec.report.Recovered = counter.n
+		if done {
+			rec.report.Complete = true
+			break
+		}
+	}
+
+	if !rec.header {
+		return nil, fmt.Errorf("no header record: %s", rec.report.Reason)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close writer: %s", err)
+	}
+	return rec.report, nil
+}
+
+// fail records a write error.
+func (rec *recoverer) fail(format string, args ...any) error {
+	rec.writeErr = fmt.Errorf(format, args...)
+	return rec.writeErr
+}
+
+// record handles a top level record. It returns done at the end of the data
+// section and an error when the record is corrupt or writing fails.
+func (rec *recoverer) record(token mcap.TokenType, data []byte) (bool, error) {
+	if !rec.header && token != mcap.TokenHeader {
+		return false, errors.New("first record is not a header")
+	}
+
+	var err error
+
+	switch token {
+	case mcap.TokenHeader:
+		var header *mcap.Header
+		header, err = mcap.ParseHeader(data)
+		if err != nil {
+			return false, err
+		}
+		rec.header = true
+		if err := rec.writer.WriteHeader(header); err != nil {
+			return false, rec.fail("failed to write header: %s", err)
+		}
+	case mcap.TokenSchema, mcap.TokenChannel, mcap.TokenMessage:
+		record := chunkRecord{}
+		switch token {
+		case mcap.TokenSchema:
+			record.schema, err = mcap.ParseSchema(data)
+		case mcap.TokenChannel:
+			record.channel, err = mcap.ParseChannel(data)
+		case mcap.TokenMessage:
+			record.message, err = mcap.ParseMessage(data)
+		}
+		if err != nil {
+			return false, err
+		}
+		return false, rec.write(record)
+	case mcap.TokenChunk:
+		// readChunkRecords lexes the whole record and checks the CRC.
+		chunk := make([]byte, 9, 9+len(data))
+		chunk[0] = byte(mcap.OpChunk)
+		binary.LittleEndian.PutUint64(chunk[1:], uint64(len(data)))
+		var records []chunkRecord
+		records, err = readChunkRecords(append(chunk, data...), nil)
+		if err != nil {
+			return false, err
+		}
+		for _, record := range records {
+			if err := rec.write(record); err != nil {
+				return false, err
+			}
+		}
+		rec.report.Chunks++
+	case mcap.TokenMetadata:
+		var metadata *mcap.Metadata
+		metadata, err = mcap.ParseMetadata(data)
+		if err != nil {
+			return false, err
+		}
+		if err := rec.writer.WriteMetadata(metadata); err != nil {
+			return false, rec.fail("failed to write metadata %s: %s", metadata.Name, err)
+		}
+		rec.report.Metadata++
+	case mcap.TokenDataEnd, mcap.TokenFooter:
+		return true, nil
+	default:
+		// Message indexes and summary records are rebuilt by the writer.
+	}
+	return false, nil
+}
+
+// write writes a schema, channel or message record, skipping repeated
+// schemas and channels and messages on unknown channels.
+func (rec *recoverer) write(record chunkRecord) error {
+	switch {
+	case record.schema != nil:
+		if rec.schemas[record.schema.ID] {
+			return nil
+		}
+		rec.schemas[record.schema.ID] = true
+		rec.report.Schemas++
+		if err := rec.writer.WriteSchema(record.schema); err != nil {
+			return rec.fail("failed to write schema %s: %s", record.schema.Name, err)
+		}
+	case record.channel != nil:
+		if rec.channels[record.channel.ID] {
+			return nil
+		}
+		rec.channels[record.channel.ID] = true
+		rec.report.Channels++
+		if err := rec.writer.WriteChannel(record.channel); err != nil {
+			return rec.fail("failed to write channel %s: %s", record.channel.Topic, err)
+		}
+	case record.message != nil:
+		msg := record.message
+		if !rec.channels[msg.ChannelID] {
+			rec.report.SkippedMessages++
+			return nil
+		}
+		if rec.report.Messages == 0 || msg.LogTime < rec.report.MessageStartTime {
+			rec.report.MessageStartTime = msg.LogTime
+		}
+		if rec.report.Messages == 0 || msg.LogTime > rec.report.MessageEndTime {
+			rec.report.MessageEndTime = msg.LogTime
+		}
+		rec.report.Messages++
+		if err := rec.writer.WriteMessage(msg); err != nil {
+			return rec.fail("failed to write message: %s", err)
+		}
+	}
+	return nil
+}
+
+// attachment copies an attachment once its data and CRC have been read in
+// full, so a truncated attachment is not written.
+func (rec *recoverer) attachment(ar *mcap.AttachmentReader) error {
+	if !rec.header {
+		return errors.New("first record is not a header")
+	}
+	data, err := io.ReadAll(ar.Data())
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) != ar.DataSize {
+		return io.ErrUnexpectedEOF
+	}
+	parsed, err := ar.ParsedCRC()
+	if err != nil {
+		return err
+	}
+	computed, err := ar.ComputedCRC()
+	if err != nil {
+		return err
+	}
+	if parsed != 0 && parsed != computed {
+		return fmt.Errorf("attachment %s: CRC %d does not match computed %d", ar.Name, parsed, computed)
+	}
+
+	rec.report.Attachments++
+	err = rec.writer.WriteAttachment(&mcap.Attachment{
+		LogTime:    ar.LogTime,
+		CreateTime: ar.CreateTime,
+		Name:       ar.Name,
+		MediaType:  ar.MediaType,
+		DataSize:   ar.DataSize,
+		Data:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return rec.fail("failed to write attachment %s: %s", ar.Name, err)
+	}
+	return nil
+}
+
+func tokenName(token mcap.TokenType) string {
+	switch token {
+	case mcap.TokenHeader:
+		return mcap.OpHeader.String()
+	case mcap.TokenSchema:
+		return mcap.OpSchema.String()
+	case mcap.TokenChannel:
+		return mcap.OpChannel.String()
+	case mcap.TokenMessage:
+		return mcap.OpMessage.String()
+	case mcap.TokenChunk:
+		return mcap.OpChunk.String()
+	case mcap.TokenMetadata:
+		return mcap.OpMetadata.String()
+	default:
+		return "record"
+	}
+}
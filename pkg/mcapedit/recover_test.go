@@ -0,0 +1,58 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRecoverComplete(t *testing.T) {
+	data := writeTestFile(t)
+
+	out := &bytes.Buffer{}
+	report, err := Recover(context.Background(), bytes.NewReader(data), out, nil)
+	assert.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Empty(t, report.Reason)
+	assert.Equal(t, uint64(20), report.Messages)
+	assert.Equal(t, 1, report.Metadata)
+
+	expected, _ := readTestFile(t, data)
+	actual, mcapInfo := readTestFile(t, out.Bytes())
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, uint64(20), mcapInfo.Statistics.MessageCount)
+}
+
+func TestRecoverTruncated(t *testing.T) {
+	data := writeTestFile(t)
+	reader, err := mcap.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	mcapInfo, err := reader.Info()
+	assert.NoError(t, err)
+	reader.Close()
+
+	// Cut the file in the middle of its second chunk.
+	second := mcapInfo.ChunkIndexes[1]
+	cut := second.ChunkStartOffset + second.ChunkLength/2
+
+	out := &bytes.Buffer{}
+	report, err := Recover(context.Background(), bytes.NewReader(data[:cut]), out, nil)
+	assert.NoError(t, err)
+	assert.False(t, report.Complete)
+	assert.Contains(t, report.Reason, "truncated")
+	assert.Equal(t, int64(second.ChunkStartOffset), report.Recovered)
+	assert.Equal(t, 1, report.Chunks)
+
+	msgs, recovered := readTestFile(t, out.Bytes())
+	assert.Equal(t, recovered.Statistics.MessageCount, report.Messages)
+	assert.Len(t, msgs, int(report.Messages))
+	assert.Positive(t, len(msgs))
+	assert.Equal(t, uint32(1), recovered.Statistics.MetadataCount)
+}
+
+func TestRecoverInvalidMagic(t *testing.T) {
+	_, err := Recover(context.Background(), bytes.NewReader([]byte("not an mcap file")), &bytes.Buffer{}, nil)
+	assert.Error(t, err)
+}
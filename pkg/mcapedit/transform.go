@@ -0,0 +1,213 @@
+// Package mcapedit applies the edits of the edit subcommand (rename, delete,
+// trim, shift, header stamp rewrite, attachment and metadata filtering and
+// re-compression) to MCAP streams.
+package mcapedit
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"mcap-utility/internal/utils"
+)
+
+// contextCheckInterval is the number of messages processed between two
+// cancellation checks.
+const contextCheckInterval = 1024
+
+// Transform reads the MCAP stream r, applies the edits described by opts and
+// writes the result to w.
+func Transform(ctx context.Context, r io.ReadSeeker, w io.Writer, opts Options) error {
+	transformers, err := opts.Pipeline()
+	if err != nil {
+		return err
+	}
+
+	if opts.Progress != nil {
+		r = &progressReader{ReadSeeker: r, progress: opts.Progress}
+	}
+
+	reader, err := mcap.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create new reader: %s", err)
+	}
+	defer reader.Close()
+
+	// Files without a summary, or whose summary does not index their
+	// chunks, are scanned linearly.
+	mcapInfo, err := reader.Info()
+	if err != nil {
+		opts.logger().Warn(fmt.Sprintf("no summary section, scanning the file linearly: %s", err))
+		mcapInfo = nil
+	}
+	linear := mcapInfo == nil || len(mcapInfo.ChunkIndexes) == 0
+
+	if mcapInfo != nil && mcapInfo.Statistics != nil {
+		err := checkTrimBounds(&opts, mcapInfo.Statistics.MessageStartTime, mcapInfo.Statistics.MessageEndTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := newChain(transformers)
+
+	if !linear {
+		copier, err := newChunkCopier(r, reader, mcapInfo, c, transformers, &opts)
+		if err != nil {
+			return err
+		}
+		if copier != nil {
+			if copier.writerOpt == nil {
+				opts.logger().Debug("copying chunks without re-compression")
+			} else {
+				opts.logger().Debug(fmt.Sprintf("processing chunks on %d workers", copier.workers))
+			}
+			return copier.run(ctx, w)
+		}
+	}
+
+	writerOpt := opts.WriterOptions
+	if writerOpt == nil {
+		writerOpt, err = utils.NewWriterOptions("", 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := mcap.NewWriter(w, writerOpt)
+	if err != nil {
+		return fmt.Errorf("failed to create new writer: %s", err)
+	}
+
+	err = writer.WriteHeader(reader.Header())
+	if err != nil {
+		return fmt.Errorf("failed to write header: %s", err)
+	}
+
+	out := newSerialWriter(writer, c)
+	out.progress = opts.Progress
+
+	if linear {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to start: %s", err)
+		}
+		if err := transformLinear(ctx, r, out, &opts); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+
+	err = utils.CopyMetadata(reader, writer, mcapInfo, c.metadata)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CopyAttachments(reader, writer, mcapInfo, c.attachment)
+	if err != nil {
+		return err
+	}
+
+	// A leading trim only drops messages, so the reader can skip whole chunks for it.
+	readOpts := make([]mcap.ReadOpt, 0, 2)
+	if len(transformers) > 0 {
+		if trim, ok := transformers[0].(*TrimTime); ok {
+			if trim.Start != 0 {
+				readOpts = append(readOpts, mcap.AfterNanos(trim.Start))
+			}
+			if trim.End != 0 {
+				readOpts = append(readOpts, mcap.BeforeNanos(trim.End+1))
+			}
+		}
+	}
+
+	msgs, err := reader.Messages(readOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to read messages: %s", err)
+	}
+
+	for count := 0; ; count++ {
+		if count%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		schema, channel, msg, err := msgs.NextInto(&mcap.Message{})
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		if err := out.message(schema, channel, msg); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// serialWriter writes the messages of the serial paths through the chain,
+// writing each output schema and channel before its first message.
+type serialWriter struct {
+	writer         *mcap.Writer
+	c              *chain
+	schemaWritten  map[uint16]bool
+	channelWritten map[uint16]bool
+	progress       *Progress
+}
+
+func newSerialWriter(writer *mcap.Writer, c *chain) *serialWriter {
+	return &serialWriter{
+		writer:         writer,
+		c:              c,
+		schemaWritten:  map[uint16]bool{},
+		channelWritten: map[uint16]bool{},
+	}
+}
+
+func (s *serialWriter) message(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) error {
+	s.progress.addMessages(1)
+	stages, err := s.c.channel(schema, channel)
+	if err != nil {
+		return err
+	}
+
+	out := stages[len(stages)-1]
+	if out.channel == nil {
+		return nil
+	}
+
+	msg, err = s.c.message(stages, msg)
+	if err != nil {
+		return err
+	}
+	if msg == nil {
+		return nil
+	}
+
+	if out.schema != nil && !s.schemaWritten[out.schema.ID] {
+		if err := s.writer.WriteSchema(out.schema); err != nil {
+			return fmt.Errorf("write schema: %w", err)
+		}
+		s.schemaWritten[out.schema.ID] = true
+	}
+
+	if !s.channelWritten[out.channel.ID] {
+		if err := s.writer.WriteChannel(out.channel); err != nil {
+			return fmt.Errorf("write channel: %w", err)
+		}
+		s.channelWritten[out.channel.ID] = true
+	}
+
+	msg.ChannelID = out.channel.ID
+	return s.writer.WriteMessage(msg)
+}
+
+// checkTrimBounds rejects trim windows that do not overlap the messages of
+// the file, logged between msgLogStart and msgLogEnd.
+func checkTrimBounds(opts *Options, msgLogStart, msgLogEnd uint64) error {
+	trim := &TrimTime{Start: opts.TrimStart, End: opts.TrimEnd}
+	return trim.CheckBounds(msgLogStart, msgLogEnd, opts.logger())
+}
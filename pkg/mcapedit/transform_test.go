@@ -0,0 +1,576 @@
+package mcapedit
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+type readMessage struct {
+	topic       string
+	logTime     uint64
+	publishTime uint64
+}
+
+func writeTestFile(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true, ChunkSize: 256, Compression: mcap.CompressionZSTD})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 1, Topic: "/b", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"k": "v"}}))
+
+	for i := uint64(0); i < 10; i++ {
+		for _, channelID := range []uint16{1, 2} {
+			assert.NoError(t, writer.WriteMessage(&mcap.Message{
+				ChannelID:   channelID,
+				LogTime:     100 + i*10,
+				PublishTime: 100 + i*10,
+				Data:        []byte{1, 0, 0, 0, 'x'},
+			}))
+		}
+	}
+	assert.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
+
+func readTestFile(t *testing.T, data []byte) ([]readMessage, *mcap.Info) {
+	t.Helper()
+
+	reader, err := mcap.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	mcapInfo, err := reader.Info()
+	assert.NoError(t, err)
+
+	msgs, err := reader.Messages()
+	assert.NoError(t, err)
+
+	result := make([]readMessage, 0)
+	for {
+		_, channel, msg, err := msgs.NextInto(nil)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		result = append(result, readMessage{channel.Topic, msg.LogTime, msg.PublishTime})
+	}
+	return result, mcapInfo
+}
+
+func TestTransformRenameDeleteTrim(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(writeTestFile(t)), out, Options{
+		Rename:    map[string]string{"/a": "/renamed"},
+		Delete:    []string{"/b"},
+		TrimStart: 120,
+		TrimEnd:   150,
+	})
+	assert.NoError(t, err)
+
+	msgs, mcapInfo := readTestFile(t, out.Bytes())
+	assert.Equal(t, []readMessage{
+		{"/renamed", 120, 120},
+		{"/renamed", 130, 130},
+		{"/renamed", 140, 140},
+		{"/renamed", 150, 150},
+	}, msgs)
+	assert.Len(t, mcapInfo.MetadataIndexes, 1)
+}
+
+func TestTransformShiftTopics(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(writeTestFile(t)), out, Options{
+		ShiftLog:     5 * time.Nanosecond,
+		ShiftPublish: -5 * time.Nanosecond,
+		Topics:       []string{"/b"},
+		DropMetadata: true,
+	})
+	assert.NoError(t, err)
+
+	msgs, mcapInfo := readTestFile(t, out.Bytes())
+	assert.Len(t, msgs, 20)
+	assert.Equal(t, readMessage{"/a", 100, 100}, msgs[0])
+	assert.Equal(t, readMessage{"/b", 105, 95}, msgs[1])
+	assert.Empty(t, mcapInfo.MetadataIndexes)
+}
+
+// writeStreamingTestFile writes the messages of writeTestFile along with an
+// attachment the way a streaming writer does. With truncate, the file ends
+// before its data end record, as if the writer never closed.
+func writeStreamingTestFile(t *testing.T, opts *mcap.WriterOptions, truncate bool) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, opts)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 1, Topic: "/b", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"k": "v"}}))
+	assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{Name: "calib", MediaType: "text/plain", DataSize: 3, Data: bytes.NewReader([]byte("abc"))}))
+
+	for i := uint64(0); i < 10; i++ {
+		for _, channelID := range []uint16{1, 2} {
+			assert.NoError(t, writer.WriteMessage(&mcap.Message{
+				ChannelID:   channelID,
+				LogTime:     100 + i*10,
+				PublishTime: 100 + i*10,
+				Data:        []byte{1, 0, 0, 0, 'x'},
+			}))
+		}
+	}
+
+	if truncate {
+		return bytes.Clone(buf.Bytes())
+	}
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestTransformWithoutSummary(t *testing.T) {
+	data := writeStreamingTestFile(t, &mcap.WriterOptions{}, true)
+
+	reader, err := mcap.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	_, err = reader.Info()
+	assert.Error(t, err)
+
+	out := &bytes.Buffer{}
+	err = Transform(context.Background(), bytes.NewReader(data), out, Options{
+		Rename:    map[string]string{"/a": "/renamed"},
+		Delete:    []string{"/b"},
+		TrimStart: 120,
+		TrimEnd:   150,
+	})
+	assert.NoError(t, err)
+
+	msgs, mcapInfo := readTestFile(t, out.Bytes())
+	assert.Equal(t, []readMessage{
+		{"/renamed", 120, 120},
+		{"/renamed", 130, 130},
+		{"/renamed", 140, 140},
+		{"/renamed", 150, 150},
+	}, msgs)
+	assert.Len(t, mcapInfo.MetadataIndexes, 1)
+	assert.Len(t, mcapInfo.AttachmentIndexes, 1)
+}
+
+func TestTransformWithoutSummaryTrimOutOfBounds(t *testing.T) {
+	data := writeStreamingTestFile(t, &mcap.WriterOptions{}, true)
+
+	// Without a summary the bounds are only known after the scan, so the
+	// trim window is not rejected.
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(data), out, Options{TrimStart: 500})
+	assert.NoError(t, err)
+
+	msgs, _ := readTestFile(t, out.Bytes())
+	assert.Empty(t, msgs)
+}
+
+func TestTransformUnindexed(t *testing.T) {
+	data := writeStreamingTestFile(t, &mcap.WriterOptions{
+		Chunked:             true,
+		ChunkSize:           256,
+		Compression:         mcap.CompressionLZ4,
+		SkipChunkIndex:      true,
+		SkipStatistics:      true,
+		SkipMetadataIndex:   true,
+		SkipAttachmentIndex: true,
+		SkipMessageIndexing: true,
+	}, false)
+
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(data), out, Options{
+		Delete: []string{"/a"},
+	})
+	assert.NoError(t, err)
+
+	msgs, mcapInfo := readTestFile(t, out.Bytes())
+	assert.Len(t, msgs, 10)
+	assert.Equal(t, readMessage{"/b", 100, 100}, msgs[0])
+	assert.Len(t, mcapInfo.MetadataIndexes, 1)
+	assert.Len(t, mcapInfo.AttachmentIndexes, 1)
+	assert.NotEmpty(t, mcapInfo.ChunkIndexes)
+}
+
+func TestTransformInvalidOptions(t *testing.T) {
+	err := Transform(context.Background(), bytes.NewReader(writeTestFile(t)), io.Discard, Options{
+		Rename: map[string]string{"/a": "no_slash"},
+	})
+	assert.Error(t, err)
+
+	err = Transform(context.Background(), bytes.NewReader(writeTestFile(t)), io.Discard, Options{
+		TrimStart: 50,
+	})
+	assert.Error(t, err)
+}
+
+func TestTransformCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Transform(ctx, bytes.NewReader(writeTestFile(t)), io.Discard, Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTransformOrder(t *testing.T) {
+	// Renaming first makes the shift apply to the new topic name.
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(writeTestFile(t)), out, Options{
+		Rename:   map[string]string{"/a": "/renamed"},
+		ShiftLog: 1,
+		Topics:   []string{"/renamed"},
+		Order:    []string{OpRename},
+	})
+	assert.NoError(t, err)
+
+	msgs, _ := readTestFile(t, out.Bytes())
+	assert.Equal(t, readMessage{"/renamed", 101, 100}, msgs[0])
+	assert.Equal(t, readMessage{"/b", 100, 100}, msgs[1])
+
+	err = Transform(context.Background(), bytes.NewReader(writeTestFile(t)), io.Discard, Options{
+		Order: []string{"compress"},
+	})
+	assert.Error(t, err)
+}
+
+type dropOddTens struct {
+	Passthrough
+}
+
+func (dropOddTens) Message(_ *mcap.Schema, _ *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	if msg.LogTime%20 != 0 {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func TestTransformCustomTransformer(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := Transform(context.Background(), bytes.NewReader(writeTestFile(t)), out, Options{
+		Delete:       []string{"/a"},
+		Transformers: []Transformer{dropOddTens{}},
+	})
+	assert.NoError(t, err)
+
+	msgs, _ := readTestFile(t, out.Bytes())
+	assert.Len(t, msgs, 5)
+	for _, msg := range msgs {
+		assert.Equal(t, "/b", msg.topic)
+		assert.Zero(t, msg.logTime%20)
+	}
+}
+
+func TestTransformCopiesChunks(t *testing.T) {
+	in := writeTestFile(t)
+	_, inInfo := readTestFile(t, in)
+
+	for _, opts := range []Options{
+		{Rename: map[string]string{"/a": "/renamed"}},
+		{Delete: []string{"/b"}, TrimStart: 125, TrimEnd: 170},
+		{TrimEnd: 140, DropMetadata: true},
+	} {
+		copied := &bytes.Buffer{}
+		assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), copied, opts))
+
+		opts.Recompress = true
+		opts.Workers = 1
+		decoded := &bytes.Buffer{}
+		assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), decoded, opts))
+
+		copiedMsgs, copiedInfo := readTestFile(t, copied.Bytes())
+		decodedMsgs, decodedInfo := readTestFile(t, decoded.Bytes())
+		assert.Equal(t, decodedMsgs, copiedMsgs)
+		assert.Equal(t, decodedInfo.Statistics.MessageCount, copiedInfo.Statistics.MessageCount)
+		assert.Equal(t, decodedInfo.Statistics.ChannelMessageCounts, copiedInfo.Statistics.ChannelMessageCounts)
+		assert.Equal(t, decodedInfo.Statistics.MessageStartTime, copiedInfo.Statistics.MessageStartTime)
+		assert.Equal(t, decodedInfo.Statistics.MessageEndTime, copiedInfo.Statistics.MessageEndTime)
+		assert.Len(t, copiedInfo.MetadataIndexes, len(decodedInfo.MetadataIndexes))
+	}
+
+	// Only the first chunk holds the channel records and is rebuilt on rename.
+	out := &bytes.Buffer{}
+	assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), out, Options{
+		Rename: map[string]string{"/a": "/renamed"},
+	}))
+	_, outInfo := readTestFile(t, out.Bytes())
+	assert.Len(t, outInfo.ChunkIndexes, len(inInfo.ChunkIndexes))
+	for idx := 1; idx < len(inInfo.ChunkIndexes); idx++ {
+		assert.Equal(t, inInfo.ChunkIndexes[idx].CompressedSize, outInfo.ChunkIndexes[idx].CompressedSize)
+	}
+}
+
+func TestTransformParallelChunks(t *testing.T) {
+	in := writeTestFile(t)
+
+	for _, opts := range []Options{
+		{ShiftLog: time.Second, Topics: []string{"/a"}},
+		{Rename: map[string]string{"/b": "/renamed"}, TrimStart: 125, Recompress: true},
+		{Delete: []string{"/a"}, Transformers: []Transformer{dropOddTens{}}},
+	} {
+		opts.Workers = 4
+		parallel := &bytes.Buffer{}
+		assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), parallel, opts))
+
+		opts.Workers = 1
+		serial := &bytes.Buffer{}
+		assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), serial, opts))
+
+		parallelMsgs, parallelInfo := readTestFile(t, parallel.Bytes())
+		serialMsgs, serialInfo := readTestFile(t, serial.Bytes())
+		assert.Equal(t, serialMsgs, parallelMsgs)
+		assert.Equal(t, serialInfo.Statistics.MessageCount, parallelInfo.Statistics.MessageCount)
+		assert.Equal(t, serialInfo.Statistics.MessageStartTime, parallelInfo.Statistics.MessageStartTime)
+		assert.Equal(t, serialInfo.Statistics.MessageEndTime, parallelInfo.Statistics.MessageEndTime)
+	}
+}
+
+// summaryTokens returns the records of the summary section of data, up to
+// the footer.
+func summaryTokens(t *testing.T, data []byte) []mcap.TokenType {
+	t.Helper()
+
+	lexer, err := mcap.NewLexer(bytes.NewReader(data), &mcap.LexerOptions{})
+	assert.NoError(t, err)
+	defer lexer.Close()
+
+	var tokens []mcap.TokenType
+	summary := false
+	for {
+		token, _, err := lexer.Next(nil)
+		assert.NoError(t, err)
+		switch {
+		case token == mcap.TokenFooter:
+			return tokens
+		case token == mcap.TokenDataEnd:
+			summary = true
+		case summary:
+			tokens = append(tokens, token)
+		}
+	}
+}
+
+func TestTransformSkippedSummaryRecords(t *testing.T) {
+	in := writeTestFile(t)
+
+	tests := []struct {
+		name   string
+		skip   func(opts *mcap.WriterOptions)
+		tokens []mcap.TokenType
+	}{
+		{
+			name: "chunk index and statistics",
+			skip: func(opts *mcap.WriterOptions) {
+				opts.SkipChunkIndex = true
+				opts.SkipStatistics = true
+			},
+			tokens: []mcap.TokenType{
+				mcap.TokenSchema, mcap.TokenChannel, mcap.TokenMetadataIndex,
+				mcap.TokenSummaryOffset, mcap.TokenSummaryOffset, mcap.TokenSummaryOffset,
+			},
+		},
+		{
+			name: "repeated records and summary offsets",
+			skip: func(opts *mcap.WriterOptions) {
+				opts.SkipRepeatedSchemas = true
+				opts.SkipRepeatedChannelInfos = true
+				opts.SkipMetadataIndex = true
+				opts.SkipSummaryOffsets = true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Copied chunks, rebuilt chunks and the single pass through
+			// mcap.Writer give the same summary section.
+			var outputs [][]byte
+			for _, workers := range []int{4, 1} {
+				for _, recompress := range []bool{false, true} {
+					writerOpt := &mcap.WriterOptions{Chunked: true, ChunkSize: 1024, Compression: mcap.CompressionZSTD}
+					tt.skip(writerOpt)
+					out := &bytes.Buffer{}
+					assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), out, Options{
+						Delete:        []string{"/b"},
+						Workers:       workers,
+						WriterOptions: writerOpt,
+						Recompress:    recompress,
+					}))
+					outputs = append(outputs, out.Bytes())
+				}
+			}
+
+			for _, out := range outputs {
+				tokens := summaryTokens(t, out)
+				if tt.tokens != nil {
+					assert.Equal(t, tt.tokens, tokens)
+				} else {
+					// Only the statistics and chunk indexes are left.
+					assert.Equal(t, mcap.TokenStatistics, tokens[0])
+					for _, token := range tokens[1:] {
+						assert.Equal(t, mcap.TokenChunkIndex, token)
+					}
+				}
+
+				if tt.tokens != nil {
+					_, mcapInfo := readTestFile(t, out)
+					assert.Nil(t, mcapInfo.Statistics)
+					assert.Empty(t, mcapInfo.ChunkIndexes)
+				}
+				// Outputs without chunk indexes are read linearly.
+				reader, err := mcap.NewReader(bytes.NewReader(out))
+				assert.NoError(t, err)
+				msgs, err := reader.Messages(mcap.UsingIndex(false))
+				assert.NoError(t, err)
+				count := 0
+				for {
+					_, _, _, err := msgs.NextInto(nil)
+					if err == io.EOF {
+						break
+					}
+					assert.NoError(t, err)
+					count++
+				}
+				assert.Equal(t, 10, count)
+				reader.Close()
+			}
+		})
+	}
+}
+
+// writeRecordsTestFile writes the messages of writeTestFile along with two
+// attachments and two metadata records.
+func writeRecordsTestFile(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true, ChunkSize: 256, Compression: mcap.CompressionZSTD})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	for i := uint64(0); i < 10; i++ {
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{
+			ChannelID:   1,
+			LogTime:     100 + i*10,
+			PublishTime: 100 + i*10,
+			Data:        []byte{1, 0, 0, 0, 'x'},
+		}))
+	}
+	for _, attachment := range []struct {
+		name    string
+		logTime uint64
+	}{{"calib.yaml", 130}, {"notes.txt", 200}} {
+		assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{
+			Name:      attachment.name,
+			LogTime:   attachment.logTime,
+			MediaType: "text/plain",
+			DataSize:  3,
+			Data:      bytes.NewReader([]byte("abc")),
+		}))
+	}
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"id": "1"}}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "device", Metadata: map[string]string{"id": "2"}}))
+	assert.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
+
+func TestTransformAttachmentsAndMetadata(t *testing.T) {
+	in := writeRecordsTestFile(t)
+
+	tests := []struct {
+		name        string
+		opts        Options
+		attachments []string
+		metadata    []string
+	}{
+		{
+			name:        "kept by default",
+			attachments: []string{"calib.yaml", "notes.txt"},
+			metadata:    []string{"run", "device"},
+		},
+		{
+			name:        "selected by name pattern",
+			opts:        Options{AttachmentNames: []string{"*.yaml"}, MetadataNames: []string{"dev*", "other"}},
+			attachments: []string{"calib.yaml"},
+			metadata:    []string{"device"},
+		},
+		{
+			name:        "no name matching",
+			opts:        Options{AttachmentNames: []string{"*.bin"}},
+			attachments: []string{},
+			metadata:    []string{"run", "device"},
+		},
+		{
+			name:        "dropped",
+			opts:        Options{DropAttachments: true, DropMetadata: true},
+			attachments: []string{},
+			metadata:    []string{},
+		},
+		{
+			name:        "drop wins over name patterns",
+			opts:        Options{DropAttachments: true, AttachmentNames: []string{"*"}, MetadataNames: []string{"run"}},
+			attachments: []string{},
+			metadata:    []string{"run"},
+		},
+		{
+			name:        "trimmed by log time",
+			opts:        Options{TrimStart: 120, TrimEnd: 150, TrimAttachments: true},
+			attachments: []string{"calib.yaml"},
+			metadata:    []string{"run", "device"},
+		},
+		{
+			name:        "trim window only",
+			opts:        Options{TrimStart: 120, TrimEnd: 150},
+			attachments: []string{"calib.yaml", "notes.txt"},
+			metadata:    []string{"run", "device"},
+		},
+		{
+			name:        "trimmed after the attachment",
+			opts:        Options{TrimStart: 140, TrimAttachments: true},
+			attachments: []string{"notes.txt"},
+			metadata:    []string{"run", "device"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Chunks copied by workers and a single pass give the same records.
+			for _, workers := range []int{4, 1} {
+				opts := tt.opts
+				opts.Workers = workers
+				out := &bytes.Buffer{}
+				assert.NoError(t, Transform(context.Background(), bytes.NewReader(in), out, opts))
+
+				_, mcapInfo := readTestFile(t, out.Bytes())
+				attachments := []string{}
+				for _, idx := range mcapInfo.AttachmentIndexes {
+					attachments = append(attachments, idx.Name)
+				}
+				metadata := []string{}
+				for _, idx := range mcapInfo.MetadataIndexes {
+					metadata = append(metadata, idx.Name)
+				}
+				assert.Equal(t, tt.attachments, attachments, "workers %d", workers)
+				assert.Equal(t, tt.metadata, metadata, "workers %d", workers)
+			}
+		})
+	}
+}
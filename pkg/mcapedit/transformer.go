@@ -0,0 +1,191 @@
+package mcapedit
+
+import (
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+)
+
+// Transformer inspects and rewrites the records of an MCAP stream. Each method
+// returns the record to pass on to the next transformer, which may be the
+// modified input or a new record, or nil to drop it.
+//
+// Records given to Schema, Channel and Attachment are shared with the reader
+// and must be copied before being modified. Messages may be modified in place.
+// Dropping a schema drops the channels using it, and dropping a channel drops
+// its messages.
+type Transformer interface {
+	Schema(schema *mcap.Schema) (*mcap.Schema, error)
+	Channel(channel *mcap.Channel) (*mcap.Channel, error)
+	Message(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (*mcap.Message, error)
+	Attachment(attachment *mcap.Attachment) (*mcap.Attachment, error)
+	Metadata(metadata *mcap.Metadata) (*mcap.Metadata, error)
+}
+
+// Passthrough implements Transformer by passing every record on unchanged. It
+// is meant to be embedded by transformers only interested in some records.
+type Passthrough struct{}
+
+func (Passthrough) Schema(schema *mcap.Schema) (*mcap.Schema, error) {
+	return schema, nil
+}
+
+func (Passthrough) Channel(channel *mcap.Channel) (*mcap.Channel, error) {
+	return channel, nil
+}
+
+func (Passthrough) Message(_ *mcap.Schema, _ *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	return msg, nil
+}
+
+func (Passthrough) Attachment(attachment *mcap.Attachment) (*mcap.Attachment, error) {
+	return attachment, nil
+}
+
+func (Passthrough) Metadata(metadata *mcap.Metadata) (*mcap.Metadata, error) {
+	return metadata, nil
+}
+
+// stage is the schema and channel a single transformer sees for a source channel.
+type stage struct {
+	schema  *mcap.Schema
+	channel *mcap.Channel
+}
+
+// chain applies transformers in order, remembering the schema and channel
+// every transformer produced so their messages can be routed through the
+// same chain.
+type chain struct {
+	transformers []Transformer
+	schemas      map[uint16][]*mcap.Schema
+	channels     map[uint16][]stage
+}
+
+func newChain(transformers []Transformer) *chain {
+	return &chain{
+		transformers: transformers,
+		schemas:      map[uint16][]*mcap.Schema{},
+		channels:     map[uint16][]stage{},
+	}
+}
+
+// schema returns the schema seen by each transformer followed by the output
+// schema. The output is nil if the schema has been dropped.
+func (c *chain) schema(schema *mcap.Schema) ([]*mcap.Schema, error) {
+	if stages, ok := c.schemas[schema.ID]; ok {
+		return stages, nil
+	}
+
+	stages := make([]*mcap.Schema, 0, len(c.transformers)+1)
+	current := schema
+	for _, t := range c.transformers {
+		stages = append(stages, current)
+		if current == nil {
+			continue
+		}
+		var err error
+		current, err = t.Schema(current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform schema %s: %w", schema.Name, err)
+		}
+	}
+	stages = append(stages, current)
+
+	c.schemas[schema.ID] = stages
+	return stages, nil
+}
+
+// channel returns the schema and channel seen by each transformer followed by
+// the output ones. The output channel is nil if it has been dropped.
+func (c *chain) channel(schema *mcap.Schema, channel *mcap.Channel) ([]stage, error) {
+	if stages, ok := c.channels[channel.ID]; ok {
+		return stages, nil
+	}
+
+	var schemaStages []*mcap.Schema
+	if schema != nil {
+		var err error
+		schemaStages, err = c.schema(schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stages := make([]stage, 0, len(c.transformers)+1)
+	current := channel
+	for idx, t := range c.transformers {
+		var currentSchema *mcap.Schema
+		if schemaStages != nil {
+			currentSchema = schemaStages[idx]
+			if currentSchema == nil {
+				current = nil
+			}
+		}
+		stages = append(stages, stage{currentSchema, current})
+		if current == nil {
+			continue
+		}
+		var err error
+		current, err = t.Channel(current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform channel %s: %w", channel.Topic, err)
+		}
+	}
+
+	var outSchema *mcap.Schema
+	if schemaStages != nil {
+		outSchema = schemaStages[len(schemaStages)-1]
+		if outSchema == nil {
+			current = nil
+		}
+	}
+	stages = append(stages, stage{outSchema, current})
+
+	c.channels[channel.ID] = stages
+	return stages, nil
+}
+
+// message runs msg through the chain, stopping as soon as it is dropped.
+func (c *chain) message(stages []stage, msg *mcap.Message) (*mcap.Message, error) {
+	for idx, t := range c.transformers {
+		var err error
+		topic := stages[idx].channel.Topic
+		msg, err = t.Message(stages[idx].schema, stages[idx].channel, msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform message on %s: %w", topic, err)
+		}
+		if msg == nil {
+			return nil, nil
+		}
+	}
+	return msg, nil
+}
+
+func (c *chain) attachment(attachment *mcap.Attachment) (*mcap.Attachment, error) {
+	name := attachment.Name
+	for _, t := range c.transformers {
+		var err error
+		attachment, err = t.Attachment(attachment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform attachment %s: %w", name, err)
+		}
+		if attachment == nil {
+			return nil, nil
+		}
+	}
+	return attachment, nil
+}
+
+func (c *chain) metadata(metadata *mcap.Metadata) (*mcap.Metadata, error) {
+	name := metadata.Name
+	for _, t := range c.transformers {
+		var err error
+		metadata, err = t.Metadata(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform metadata %s: %w", name, err)
+		}
+		if metadata == nil {
+			return nil, nil
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,214 @@
+package mcapedit
+
+import (
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"log/slog"
+	"mcap-utility/internal/ros"
+	"mcap-utility/internal/utils"
+	"slices"
+	"sync"
+	"time"
+)
+
+// TrimTime drops messages whose log time is outside of [Start, End]. Zero
+// leaves that side unbounded. With Attachments set, attachments are trimmed
+// by their log time as well.
+type TrimTime struct {
+	Passthrough
+	Start       uint64
+	End         uint64
+	Attachments bool
+}
+
+func (t *TrimTime) contains(logTime uint64) bool {
+	if t.Start != 0 && logTime < t.Start {
+		return false
+	}
+	return t.End == 0 || logTime <= t.End
+}
+
+// CheckBounds rejects trim windows that do not overlap the messages of a
+// file, logged between msgLogStart and msgLogEnd, and warns through logger
+// about an end after the last message.
+func (t *TrimTime) CheckBounds(msgLogStart, msgLogEnd uint64, logger *slog.Logger) error {
+	if t.Start != 0 {
+		if t.Start < msgLogStart {
+			return fmt.Errorf("trim start time [%d] is before message start time [%d]", t.Start, msgLogStart)
+		}
+
+		if t.Start >= msgLogEnd {
+			return fmt.Errorf("trim start time [%d] is after message end time [%d]", t.Start, msgLogEnd)
+		}
+	}
+
+	if t.End != 0 && t.End > msgLogEnd {
+		logger.Warn(fmt.Sprintf("trim end time [%d] is after message end time [%d]", t.End, msgLogEnd))
+	}
+
+	return nil
+}
+
+func (t *TrimTime) Message(_ *mcap.Schema, _ *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	if !t.contains(msg.LogTime) {
+		return nil, nil
+	}
+	return msg, nil
+}
+
+func (t *TrimTime) Attachment(attachment *mcap.Attachment) (*mcap.Attachment, error) {
+	if t.Attachments && !t.contains(attachment.LogTime) {
+		return nil, nil
+	}
+	return attachment, nil
+}
+
+// DeleteTopics drops the channels of the given topics along with their messages.
+type DeleteTopics struct {
+	Passthrough
+	Topics []string
+}
+
+func (t *DeleteTopics) Channel(channel *mcap.Channel) (*mcap.Channel, error) {
+	if slices.Contains(t.Topics, channel.Topic) {
+		return nil, nil
+	}
+	return channel, nil
+}
+
+// KeepTopics drops the channels of every topic but the given ones along with
+// their messages.
+type KeepTopics struct {
+	Passthrough
+	Topics []string
+}
+
+func (t *KeepTopics) Channel(channel *mcap.Channel) (*mcap.Channel, error) {
+	if !slices.Contains(t.Topics, channel.Topic) {
+		return nil, nil
+	}
+	return channel, nil
+}
+
+// ShiftTime adds Log and Publish to the log and publish times of the messages
+// of Topics, or of every message if Topics is empty.
+type ShiftTime struct {
+	Passthrough
+	Log     time.Duration
+	Publish time.Duration
+	Topics  []string
+}
+
+func (t *ShiftTime) Message(_ *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	if len(t.Topics) > 0 && !slices.Contains(t.Topics, channel.Topic) {
+		return msg, nil
+	}
+	msg.LogTime = uint64(int64(msg.LogTime) + int64(t.Log))
+	msg.PublishTime = uint64(int64(msg.PublishTime) + int64(t.Publish))
+	return msg, nil
+}
+
+// PublishTimeStamp overwrites the std_msgs/Header stamp of ROS 1 and ROS 2
+// messages with their publish time. Messages without a header are passed
+// through unchanged.
+type PublishTimeStamp struct {
+	Passthrough
+	Logger   *slog.Logger
+	mu       sync.Mutex
+	decoders map[*mcap.Channel]*ros.Decoder
+}
+
+func (t *PublishTimeStamp) Message(schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (*mcap.Message, error) {
+	t.mu.Lock()
+	if t.decoders == nil {
+		t.decoders = map[*mcap.Channel]*ros.Decoder{}
+	}
+
+	decoder, cached := t.decoders[channel]
+	if !cached {
+		decoder = t.newDecoder(schema, channel)
+		t.decoders[channel] = decoder
+	}
+	t.mu.Unlock()
+
+	if decoder != nil {
+		if _, err := decoder.SetHeaderStamp(msg.Data, msg.PublishTime); err != nil {
+			return nil, fmt.Errorf("failed to set header stamp: %s", err)
+		}
+	}
+	return msg, nil
+}
+
+// newDecoder returns a decoder able to rewrite the header stamp of the
+// channel's messages, or nil if its messages are to be passed through unchanged.
+func (t *PublishTimeStamp) newDecoder(schema *mcap.Schema, channel *mcap.Channel) *ros.Decoder {
+	if schema == nil || (schema.Encoding != ros.SchemaEncodingROS1 && schema.Encoding != ros.SchemaEncodingROS2) {
+		return nil
+	}
+
+	decoder, err := ros.NewDecoder(schema, channel.MessageEncoding)
+	if err != nil {
+		logger := t.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Warn(fmt.Sprintf("Skipping header stamp rewrite on topic %s: %s", channel.Topic, err))
+		return nil
+	}
+
+	if !decoder.HasHeader() {
+		return nil
+	}
+
+	return decoder
+}
+
+// RenameTopics renames channels according to Mapping (old topic to new topic).
+type RenameTopics struct {
+	Passthrough
+	Mapping map[string]string
+}
+
+func (t *RenameTopics) Channel(channel *mcap.Channel) (*mcap.Channel, error) {
+	newTopic, ok := t.Mapping[channel.Topic]
+	if !ok {
+		return channel, nil
+	}
+	return &mcap.Channel{
+		ID:              channel.ID,
+		SchemaID:        channel.SchemaID,
+		Topic:           newTopic,
+		MessageEncoding: channel.MessageEncoding,
+		Metadata:        channel.Metadata,
+	}, nil
+}
+
+// FilterAttachments drops every attachment if Drop is set, otherwise keeps the
+// attachments whose name matches one of the Names glob patterns.
+type FilterAttachments struct {
+	Passthrough
+	Drop  bool
+	Names []string
+}
+
+func (t *FilterAttachments) Attachment(attachment *mcap.Attachment) (*mcap.Attachment, error) {
+	if t.Drop || !utils.MatchesAnyPattern(attachment.Name, t.Names) {
+		return nil, nil
+	}
+	return attachment, nil
+}
+
+// FilterMetadata drops every metadata record if Drop is set, otherwise keeps
+// the records whose name matches one of the Names glob patterns.
+type FilterMetadata struct {
+	Passthrough
+	Drop  bool
+	Names []string
+}
+
+func (t *FilterMetadata) Metadata(metadata *mcap.Metadata) (*mcap.Metadata, error) {
+	if t.Drop || !utils.MatchesAnyPattern(metadata.Name, t.Names) {
+		return nil, nil
+	}
+	return metadata, nil
+}
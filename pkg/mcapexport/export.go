@@ -0,0 +1,299 @@
+// Package mcapexport exports the decoded messages of MCAP streams to one CSV
+// or Parquet table per topic, with a column per field path, e.g.
+// pose.position.x.
+package mcapexport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"io"
+	"log/slog"
+	"mcap-utility/internal/utils"
+	"mcap-utility/pkg/mcapdecode"
+	"mcap-utility/pkg/mcapedit"
+	"slices"
+)
+
+// contextCheckInterval is the number of messages exported between two
+// cancellation checks.
+const contextCheckInterval = 1024
+
+// baseColumns are the columns of every table, ahead of the message fields.
+var baseColumns = []column{
+	{name: "log_time", kind: kindUint64},
+	{name: "publish_time", kind: kindUint64},
+	{name: "sequence", kind: kindInt64},
+}
+
+// Options selects the messages to export and the format of the tables.
+type Options struct {
+	// Topics to export, every topic when empty.
+	Topics []string
+	// Start and End bound the log times of the exported messages, both
+	// included. Zero leaves that side unbounded. As with the trim of edit,
+	// Start must fall within the messages of an indexed stream.
+	Start uint64
+	End   uint64
+	// Format defaults to FormatCSV and Arrays to ArraysJSON.
+	Format Format
+	Arrays ArrayMode
+
+	// Logger receives warnings about the time bounds. Nil uses
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// Validate checks the options for mistakes that do not depend on the input.
+func (o *Options) Validate() error {
+	if o.Format != "" && !slices.Contains(Formats, o.Format) {
+		return fmt.Errorf("invalid format: %s", o.Format)
+	}
+	if o.Arrays != "" && !slices.Contains(ArrayModes, o.Arrays) {
+		return fmt.Errorf("invalid array mode: %s", o.Arrays)
+	}
+	if o.Start != 0 && o.End != 0 && o.End < o.Start {
+		return fmt.Errorf("end time [%d] is before start time [%d]", o.End, o.Start)
+	}
+	return nil
+}
+
+// TopicReport describes the table of a topic.
+type TopicReport struct {
+	Topic   string
+	Rows    uint64
+	Columns int
+	// DroppedValues counts the values of fields that were not in the first
+	// message of the topic, which set the columns, whose name is taken by a
+	// base column or another field, or that do not fit the type of their
+	// Parquet column.
+	DroppedValues uint64
+}
+
+// Report describes the tables written by Export, in the order of the first
+// message of their topic.
+type Report struct {
+	Topics []TopicReport
+	// Ordered is false when the stream had no summary section and was
+	// exported in file order instead of log time order.
+	Ordered bool
+}
+
+type table struct {
+	out     io.WriteCloser
+	writer  tableWriter
+	columns map[string]int
+	row     []any
+	report  *TopicReport
+}
+
+// Export writes the messages of the MCAP stream r selected by opts to one
+// table per topic. create is called on the first message of each topic and
+// returns where its table is written; it is closed by Export.
+func Export(ctx context.Context, r io.ReadSeeker, create func(topic string) (io.WriteCloser, error), opts Options) (report *Report, err error) {
+	if opts.Format == "" {
+		opts.Format = FormatCSV
+	}
+	if opts.Arrays == "" {
+		opts.Arrays = ArraysJSON
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	reader, msgs, ordered, err := utils.ReadMessages(r)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Messages are selected by the transformers of edit, so the time bounds
+	// are checked against the file the same way.
+	trim := &mcapedit.TrimTime{Start: opts.Start, End: opts.End}
+	filters := []mcapedit.Transformer{trim}
+	if len(opts.Topics) > 0 {
+		filters = append(filters, &mcapedit.KeepTopics{Topics: opts.Topics})
+	}
+	if ordered && (opts.Start != 0 || opts.End != 0) {
+		mcapInfo, err := reader.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read summary: %s", err)
+		}
+		if mcapInfo.Statistics != nil {
+			err := trim.CheckBounds(mcapInfo.Statistics.MessageStartTime, mcapInfo.Statistics.MessageEndTime, logger)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	selected := map[uint16]bool{}
+
+	tables := map[uint16]*table{}
+	topicReports := []*TopicReport{}
+	defer func() {
+		for _, t := range tables {
+			if closeErr := t.close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			report = nil
+		}
+	}()
+
+	decoder := mcapdecode.NewDecoder()
+	msg := &mcap.Message{}
+	for count := 0; ; count++ {
+		if count%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		schema, channel, _, err := msgs.NextInto(msg)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to iterate messages: %s", err)
+		}
+
+		keep, ok := selected[channel.ID]
+		if !ok {
+			keep, err = selectChannel(filters, channel)
+			if err != nil {
+				return nil, err
+			}
+			selected[channel.ID] = keep
+		}
+		if keep {
+			keep, err = selectMessage(filters, schema, channel, msg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !keep {
+			continue
+		}
+
+		value, err := decoder.Decode(schema, channel, msg.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode message on %s at log time %d: %s", channel.Topic, msg.LogTime, err)
+		}
+
+		t, ok := tables[channel.ID]
+		if !ok {
+			t, err = newTable(channel.Topic, value, create, opts)
+			if err != nil {
+				return nil, err
+			}
+			tables[channel.ID] = t
+			topicReports = append(topicReports, t.report)
+		}
+		if err := t.write(msg, value, opts.Arrays); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %s", channel.Topic, err)
+		}
+	}
+
+	report = &Report{Ordered: ordered}
+	for _, topicReport := range topicReports {
+		report.Topics = append(report.Topics, *topicReport)
+	}
+	return report, nil
+}
+
+// selectChannel reports whether the filters keep channel.
+func selectChannel(filters []mcapedit.Transformer, channel *mcap.Channel) (bool, error) {
+	for _, filter := range filters {
+		kept, err := filter.Channel(channel)
+		if err != nil || kept == nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// selectMessage reports whether the filters keep msg.
+func selectMessage(filters []mcapedit.Transformer, schema *mcap.Schema, channel *mcap.Channel, msg *mcap.Message) (bool, error) {
+	for _, filter := range filters {
+		kept, err := filter.Message(schema, channel, msg)
+		if err != nil || kept == nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// newTable creates the table of topic, with the columns of the decoded
+// value of its first message.
+func newTable(topic string, value any, create func(topic string) (io.WriteCloser, error), opts Options) (*table, error) {
+	columns := slices.Clone(baseColumns)
+	indexes := make(map[string]int, len(columns))
+	for i, c := range columns {
+		indexes[c.name] = i
+	}
+	// Fields named like a base column, or like another field once JSON keys
+	// are cleaned up, are left out.
+	err := flatten(value, opts.Arrays, func(name string, value any) {
+		if _, ok := indexes[name]; !ok {
+			indexes[name] = len(columns)
+			columns = append(columns, column{name: name, kind: kindOf(value)})
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to flatten message on %s: %s", topic, err)
+	}
+
+	t := &table{
+		columns: indexes,
+		row:     make([]any, len(columns)),
+		report:  &TopicReport{Topic: topic, Columns: len(columns)},
+	}
+
+	t.out, err = create(topic)
+	if err != nil {
+		return nil, err
+	}
+	t.writer, err = newTableWriter(opts.Format, t.out, columns)
+	if err != nil {
+		t.out.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *table) write(msg *mcap.Message, value any, arrays ArrayMode) error {
+	clear(t.row)
+	t.row[0] = msg.LogTime
+	t.row[1] = msg.PublishTime
+	t.row[2] = msg.Sequence
+	err := flatten(value, arrays, func(name string, value any) {
+		idx, ok := t.columns[name]
+		if !ok || idx < len(baseColumns) || t.row[idx] != nil {
+			t.report.DroppedValues++
+			return
+		}
+		t.row[idx] = value
+	})
+	if err != nil {
+		return err
+	}
+	t.report.Rows++
+	dropped, err := t.writer.write(t.row)
+	t.report.DroppedValues += uint64(dropped)
+	return err
+}
+
+func (t *table) close() error {
+	err := t.writer.close()
+	if closeErr := t.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package mcapexport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"io"
+	"math"
+	"testing"
+)
+
+const poseDefinition = `Point position
+float64[] covariance
+string frame
+================================================================================
+MSG: my_pkg/Point
+float64 x
+float64 y
+`
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// outputs collects the tables written by Export.
+type outputs map[string]*bytes.Buffer
+
+func (o outputs) create(topic string) (io.WriteCloser, error) {
+	o[topic] = &bytes.Buffer{}
+	return nopCloser{o[topic]}, nil
+}
+
+func poseMessage(x, y float64, covariance []float64, frame string) []byte {
+	le := binary.LittleEndian
+	data := le.AppendUint64(nil, math.Float64bits(x))
+	data = le.AppendUint64(data, math.Float64bits(y))
+	data = le.AppendUint32(data, uint32(len(covariance)))
+	for _, c := range covariance {
+		data = le.AppendUint64(data, math.Float64bits(c))
+	}
+	data = le.AppendUint32(data, uint32(len(frame)))
+	return append(data, frame...)
+}
+
+func writeTestFile(t *testing.T) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true, ChunkSize: 256, Compression: mcap.CompressionZSTD})
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "my_pkg/Pose", Encoding: "ros1msg", Data: []byte(poseDefinition)}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/pose", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, Topic: "/status", MessageEncoding: "json"}))
+
+	messages := []struct {
+		channelID uint16
+		logTime   uint64
+		data      []byte
+	}{
+		{1, 100, poseMessage(1, 2, []float64{0.5, 0.25}, "map")},
+		{2, 105, []byte(`{"ok":true,"detail":{"code":3}}`)},
+		{1, 110, poseMessage(3, math.Inf(1), []float64{0.5, 0.25, 0.125}, "odom")},
+		{2, 115, []byte(`{"ok":false,"extra":"x"}`)},
+		{1, 120, poseMessage(5, 6, nil, "map")},
+	}
+	for i, m := range messages {
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{
+			ChannelID:   m.channelID,
+			Sequence:    uint32(i),
+			LogTime:     m.logTime,
+			PublishTime: m.logTime + 1,
+			Data:        m.data,
+		}))
+	}
+	assert.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
+
+func TestExportCSV(t *testing.T) {
+	out := outputs{}
+	report, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), out.create, Options{})
+	assert.NoError(t, err)
+
+	assert.True(t, report.Ordered)
+	assert.Equal(t, []TopicReport{
+		{Topic: "/pose", Rows: 3, Columns: 7},
+		{Topic: "/status", Rows: 2, Columns: 5, DroppedValues: 1},
+	}, report.Topics)
+
+	assert.Equal(t, `log_time,publish_time,sequence,position.x,position.y,covariance,frame
+100,101,0,1,2,"[0.5,0.25]",map
+110,111,2,3,Infinity,"[0.5,0.25,0.125]",odom
+120,121,4,5,6,[],map
+`, out["/pose"].String())
+	assert.Equal(t, `log_time,publish_time,sequence,detail.code,ok
+105,106,1,3,true
+115,116,3,,false
+`, out["/status"].String())
+}
+
+func TestExportFilterIndexArrays(t *testing.T) {
+	out := outputs{}
+	report, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), out.create, Options{
+		Topics: []string{"/pose"},
+		Start:  110,
+		End:    120,
+		Arrays: ArraysIndex,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []TopicReport{{Topic: "/pose", Rows: 2, Columns: 9}}, report.Topics)
+	assert.Equal(t, `log_time,publish_time,sequence,position.x,position.y,covariance.0,covariance.1,covariance.2,frame
+110,111,2,3,Infinity,0.5,0.25,0.125,odom
+120,121,4,5,6,,,,map
+`, out["/pose"].String())
+	assert.NotContains(t, out, "/status")
+}
+
+func TestExportParquet(t *testing.T) {
+	out := outputs{}
+	_, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), out.create, Options{
+		Format: FormatParquet,
+		Arrays: ArraysDrop,
+	})
+	assert.NoError(t, err)
+
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(out["/pose"].Bytes()), nil, 1)
+	assert.NoError(t, err)
+	defer pr.ReadStop()
+
+	assert.Equal(t, int64(3), pr.GetNumRows())
+	names := []string{}
+	for i := 1; i < len(pr.SchemaHandler.Infos); i++ {
+		names = append(names, pr.SchemaHandler.GetExName(i))
+	}
+	assert.Equal(t, []string{"log_time", "publish_time", "sequence", "position.x", "position.y", "frame"}, names)
+}
+
+func TestExportInvalidOptions(t *testing.T) {
+	_, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), outputs{}.create, Options{Format: "xlsx"})
+	assert.Error(t, err)
+
+	_, err = Export(context.Background(), bytes.NewReader(writeTestFile(t)), outputs{}.create, Options{Arrays: "explode"})
+	assert.Error(t, err)
+}
+
+func TestExportTrimBounds(t *testing.T) {
+	// Start times outside of the messages of the file are rejected like the
+	// trim of edit.
+	for _, start := range []uint64{90, 120, 130} {
+		_, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), outputs{}.create, Options{Start: start})
+		assert.ErrorContains(t, err, "trim start time", start)
+	}
+
+	// An end after the last message is only a warning.
+	out := outputs{}
+	report, err := Export(context.Background(), bytes.NewReader(writeTestFile(t)), out.create, Options{
+		Topics: []string{"/status"},
+		Start:  100,
+		End:    1000,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []TopicReport{{Topic: "/status", Rows: 2, Columns: 5, DroppedValues: 1}}, report.Topics)
+}
+
+func TestExportChangingType(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, Topic: "/level", MessageEncoding: "json"}))
+	for i, data := range []string{`{"level":1}`, `{"level":"high"}`, `{"level":2}`} {
+		assert.NoError(t, writer.WriteMessage(&mcap.Message{ChannelID: 1, LogTime: uint64(i), Data: []byte(data)}))
+	}
+	assert.NoError(t, writer.Close())
+
+	// CSV writes the string, Parquet drops it from the number column, and
+	// both report what they lost.
+	tests := []struct {
+		format  Format
+		dropped uint64
+	}{
+		{FormatCSV, 0},
+		{FormatParquet, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			out := outputs{}
+			report, err := Export(context.Background(), bytes.NewReader(buf.Bytes()), out.create, Options{Format: tt.format})
+			assert.NoError(t, err)
+			assert.Equal(t, []TopicReport{{Topic: "/level", Rows: 3, Columns: 4, DroppedValues: tt.dropped}}, report.Topics)
+			if tt.format == FormatCSV {
+				assert.Equal(t, "log_time,publish_time,sequence,level\n0,0,0,1\n1,0,0,high\n2,0,0,2\n", out["/level"].String())
+			}
+		})
+	}
+}
+
+func TestParquetValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  kind
+		value any
+		want  any
+		ok    bool
+	}{
+		{"missing", kindFloat64, nil, nil, true},
+		{"float", kindFloat64, float64(1.5), float64(1.5), true},
+		{"integer as float", kindFloat64, int32(2), float64(2), true},
+		{"non-finite string as float", kindFloat32, "Infinity", float32(math.Inf(1)), true},
+		{"string as float", kindFloat64, "high", nil, false},
+		{"int32 overflow", kindInt32, int64(math.MaxInt32 + 1), nil, false},
+		{"negative uint64", kindUint64, int8(-1), nil, false},
+		{"number as bool", kindBool, float64(1), nil, false},
+		{"string as bytes", kindBytes, "abc", nil, false},
+		{"anything as string", kindString, float64(1.5), "1.5", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parquetValue(tt.kind, tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
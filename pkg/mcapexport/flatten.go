@@ -0,0 +1,120 @@
+package mcapexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"mcap-utility/pkg/mcapdecode"
+	"slices"
+	"strings"
+)
+
+// ArrayMode tells how arrays, other than byte arrays, are exported.
+type ArrayMode string
+
+const (
+	// ArraysJSON exports each array to a single column holding it as JSON.
+	ArraysJSON ArrayMode = "json"
+	// ArraysIndex exports each element to its own column, named by its index,
+	// e.g. position.0. The first message of a topic sets the columns, so the
+	// elements of longer arrays in later messages are dropped.
+	ArraysIndex ArrayMode = "index"
+	// ArraysDrop leaves arrays out.
+	ArraysDrop ArrayMode = "drop"
+)
+
+// ArrayModes lists the supported array modes.
+var ArrayModes = []ArrayMode{ArraysJSON, ArraysIndex, ArraysDrop}
+
+// rootColumn names the column of messages that do not decode to an object,
+// e.g. a JSON number.
+const rootColumn = "value"
+
+// flatten calls emit for every leaf of the decoded message value, with its
+// field path joined by dots.
+func flatten(value any, arrays ArrayMode, emit func(name string, value any)) error {
+	if raw, ok := value.(json.RawMessage); ok {
+		var err error
+		value, err = parseJSON(raw)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := value.(mcapdecode.Message); !ok {
+		return flattenValue(rootColumn, value, arrays, emit)
+	}
+	return flattenValue("", value, arrays, emit)
+}
+
+func flattenValue(name string, value any, arrays ArrayMode, emit func(name string, value any)) error {
+	switch v := value.(type) {
+	case mcapdecode.Message:
+		for _, field := range v {
+			if err := flattenValue(join(name, field.Name), field.Value, arrays, emit); err != nil {
+				return err
+			}
+		}
+	case []any:
+		switch arrays {
+		case ArraysJSON:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			emit(name, string(data))
+		case ArraysIndex:
+			for i, element := range v {
+				if err := flattenValue(join(name, fmt.Sprint(i)), element, arrays, emit); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		emit(name, value)
+	}
+	return nil
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// keyReplacer replaces the characters of JSON keys that would be mistaken for
+// nested fields, or that parquet column definitions cannot hold.
+var keyReplacer = strings.NewReplacer(".", "_", ",", "_", "=", "_")
+
+// parseJSON parses the payload of a json message to the values of a decoded
+// message. Object keys are sorted, as JSON objects have no order, and numbers
+// are float64.
+func parseJSON(raw json.RawMessage) (any, error) {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON payload: %s", err)
+	}
+	return fromJSON(value), nil
+}
+
+func fromJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		msg := make(mcapdecode.Message, 0, len(keys))
+		for _, key := range keys {
+			msg = append(msg, mcapdecode.Field{Name: keyReplacer.Replace(key), Value: fromJSON(v[key])})
+		}
+		return msg
+	case []any:
+		for i, element := range v {
+			v[i] = fromJSON(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
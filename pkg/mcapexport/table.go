@@ -0,0 +1,289 @@
+package mcapexport
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Format is the file format of the exported tables.
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatParquet Format = "parquet"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{FormatCSV, FormatParquet}
+
+// Extension returns the file name extension of the format.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// parquetRowGroupSize bounds the rows buffered in memory for each topic.
+const parquetRowGroupSize = 32 << 20
+
+// kind is the type of a column, taken from its value in the first message of
+// the topic.
+type kind int
+
+const (
+	kindString kind = iota
+	kindBool
+	kindInt32
+	kindInt64
+	kindUint64
+	kindFloat32
+	kindFloat64
+	kindBytes
+)
+
+type column struct {
+	name string
+	kind kind
+}
+
+func kindOf(value any) kind {
+	switch value.(type) {
+	case bool:
+		return kindBool
+	case int8, int16, int32, uint8, uint16:
+		return kindInt32
+	case int64, uint32:
+		return kindInt64
+	case uint64:
+		return kindUint64
+	case float32:
+		return kindFloat32
+	case float64:
+		return kindFloat64
+	case []byte:
+		return kindBytes
+	default:
+		return kindString
+	}
+}
+
+// tableWriter writes the rows of a topic, one value per column, with nil for
+// missing values. write returns the number of values of the row that did not
+// fit their column and were written as missing.
+type tableWriter interface {
+	write(row []any) (int, error)
+	close() error
+}
+
+func newTableWriter(format Format, w io.Writer, columns []column) (tableWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("invalid format: %s", format)
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []column) (*csvWriter, error) {
+	header := make([]string, 0, len(columns))
+	for _, c := range columns {
+		header = append(header, c.name)
+	}
+	cw := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) write(row []any) (int, error) {
+	for i, value := range row {
+		cw.record[i] = formatValue(value)
+	}
+	return 0, cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// formatValue formats a value for CSV. Byte arrays are base64 encoded, like
+// in JSON, and missing values are empty.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []column
+	record  []any
+}
+
+func newParquetWriter(w io.Writer, columns []column) (*parquetWriter, error) {
+	md := make([]string, 0, len(columns))
+	for _, c := range columns {
+		md = append(md, fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", c.name, parquetType(c.kind)))
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parquet writer: %s", err)
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_ZSTD
+	return &parquetWriter{w: pw, columns: columns, record: make([]any, len(columns))}, nil
+}
+
+func parquetType(k kind) string {
+	switch k {
+	case kindBool:
+		return "type=BOOLEAN"
+	case kindInt32:
+		return "type=INT32"
+	case kindInt64:
+		return "type=INT64"
+	case kindUint64:
+		return "type=INT64, convertedtype=UINT_64"
+	case kindFloat32:
+		return "type=FLOAT"
+	case kindFloat64:
+		return "type=DOUBLE"
+	case kindBytes:
+		return "type=BYTE_ARRAY"
+	default:
+		return "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+}
+
+func (pw *parquetWriter) write(row []any) (int, error) {
+	var dropped int
+	for i, value := range row {
+		converted, ok := parquetValue(pw.columns[i].kind, value)
+		if !ok {
+			dropped++
+		}
+		pw.record[i] = converted
+	}
+	return dropped, pw.w.Write(pw.record)
+}
+
+func (pw *parquetWriter) close() error {
+	return pw.w.WriteStop()
+}
+
+// parquetValue converts value to the Go type of the parquet column kind. It
+// returns false when the value does not fit, e.g. a JSON field changing type,
+// and is written as missing.
+func parquetValue(k kind, value any) (any, bool) {
+	if value == nil {
+		return nil, true
+	}
+	switch k {
+	case kindString:
+		return formatValue(value), true
+	case kindBytes:
+		if b, ok := value.([]byte); ok {
+			return string(b), true
+		}
+	case kindBool:
+		if b, ok := value.(bool); ok {
+			return b, true
+		}
+	case kindInt32:
+		if i, ok := toInt64(value); ok && i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int32(i), true
+		}
+	case kindInt64:
+		if i, ok := toInt64(value); ok {
+			return i, true
+		}
+	case kindUint64:
+		if u, ok := value.(uint64); ok {
+			return int64(u), true
+		}
+		if i, ok := toInt64(value); ok && i >= 0 {
+			return i, true
+		}
+	case kindFloat32:
+		if f, ok := toFloat64(value); ok {
+			return float32(f), true
+		}
+	case kindFloat64:
+		if f, ok := toFloat64(value); ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// toFloat64 converts numbers, and the strings decoded messages hold for
+// non-finite floats, to float64.
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		switch v {
+		case "NaN":
+			return math.NaN(), true
+		case "Infinity":
+			return math.Inf(1), true
+		case "-Infinity":
+			return math.Inf(-1), true
+		}
+	}
+	if i, ok := toInt64(value); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
@@ -0,0 +1,150 @@
+package mcapverify
+
+import (
+	"maps"
+	"slices"
+)
+
+// checkIndexes compares the summary records with the records read.
+func (v *verifier) checkIndexes() {
+	v.finishChunk()
+	v.checkChunkIndexes()
+	v.checkAttachmentIndexes()
+	v.checkMetadataIndexes()
+	v.checkStatistics()
+	v.checkSummaryOffsets()
+}
+
+func (v *verifier) checkChunkIndexes() {
+	const kind = "chunk index"
+	indexed := map[uint64]bool{}
+	for _, idx := range v.chunkIndexes {
+		chunk, ok := v.chunks[idx.record.ChunkStartOffset]
+		if !ok {
+			v.problem(idx.offset, kind, "no chunk at offset %d", idx.record.ChunkStartOffset)
+			continue
+		}
+		indexed[idx.record.ChunkStartOffset] = true
+
+		expected, actual := chunk.index, idx.record
+		switch {
+		case actual.ChunkLength != expected.ChunkLength:
+			v.problem(idx.offset, kind, "chunk length %d does not match actual %d", actual.ChunkLength, expected.ChunkLength)
+		case actual.MessageStartTime != expected.MessageStartTime || actual.MessageEndTime != expected.MessageEndTime:
+			v.problem(idx.offset, kind, "message time range [%d, %d] does not match the chunk [%d, %d]",
+				actual.MessageStartTime, actual.MessageEndTime, expected.MessageStartTime, expected.MessageEndTime)
+		case actual.Compression != expected.Compression ||
+			actual.CompressedSize != expected.CompressedSize ||
+			actual.UncompressedSize != expected.UncompressedSize:
+			v.problem(idx.offset, kind, "compression %q (%d/%d bytes) does not match the chunk %q (%d/%d bytes)",
+				actual.Compression, actual.CompressedSize, actual.UncompressedSize,
+				expected.Compression, expected.CompressedSize, expected.UncompressedSize)
+		case !maps.Equal(actual.MessageIndexOffsets, expected.MessageIndexOffsets):
+			v.problem(idx.offset, kind, "message index offsets %v do not match actual %v",
+				actual.MessageIndexOffsets, expected.MessageIndexOffsets)
+		case actual.MessageIndexLength != expected.MessageIndexLength:
+			v.problem(idx.offset, kind, "message index length %d does not match actual %d",
+				actual.MessageIndexLength, expected.MessageIndexLength)
+		}
+	}
+
+	if len(v.chunkIndexes) == 0 {
+		return
+	}
+	for _, offset := range slices.Sorted(maps.Keys(v.chunks)) {
+		if !indexed[offset] {
+			v.problem(int64(offset), "chunk", "not listed in the chunk indexes")
+		}
+	}
+}
+
+func (v *verifier) checkAttachmentIndexes() {
+	const kind = "attachment index"
+	for _, idx := range v.attachmentIndexes {
+		attachment, ok := v.attachments[idx.record.Offset]
+		if !ok {
+			v.problem(idx.offset, kind, "%s: no attachment at offset %d", idx.record.Name, idx.record.Offset)
+			continue
+		}
+		if *attachment != *idx.record {
+			v.problem(idx.offset, kind, "%s: %+v does not match the attachment %+v", idx.record.Name, *idx.record, *attachment)
+		}
+	}
+}
+
+func (v *verifier) checkMetadataIndexes() {
+	const kind = "metadata index"
+	for _, idx := range v.metadataIndexes {
+		metadata, ok := v.metadata[idx.record.Offset]
+		if !ok {
+			v.problem(idx.offset, kind, "%s: no metadata at offset %d", idx.record.Name, idx.record.Offset)
+			continue
+		}
+		if *metadata != *idx.record {
+			v.problem(idx.offset, kind, "%s: %+v does not match the metadata %+v", idx.record.Name, *idx.record, *metadata)
+		}
+	}
+}
+
+func (v *verifier) checkStatistics() {
+	const kind = "statistics"
+	if v.statistics == nil {
+		return
+	}
+	offset, stats := v.statistics.offset, v.statistics.record
+
+	check := func(name string, recorded, actual uint64) {
+		if recorded != actual {
+			v.problem(offset, kind, "%s %d does not match actual %d", name, recorded, actual)
+		}
+	}
+	check("attachment count", uint64(stats.AttachmentCount), uint64(len(v.attachments)))
+	check("metadata count", uint64(stats.MetadataCount), uint64(len(v.metadata)))
+	check("chunk count", uint64(stats.ChunkCount), uint64(len(v.chunks)))
+	// The records of undecoded chunks are already reported and would only
+	// add count mismatches.
+	if v.undecoded {
+		return
+	}
+	check("message count", stats.MessageCount, v.messageCount)
+	check("schema count", uint64(stats.SchemaCount), uint64(len(v.schemaIDs)))
+	check("channel count", uint64(stats.ChannelCount), uint64(len(v.channelIDs)))
+	if v.messageCount > 0 {
+		check("message start time", stats.MessageStartTime, v.messageStartTime)
+		check("message end time", stats.MessageEndTime, v.messageEndTime)
+	}
+
+	channelIDs := slices.Sorted(maps.Keys(v.counts))
+	for id := range stats.ChannelMessageCounts {
+		if _, ok := v.counts[id]; !ok {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	slices.Sort(channelIDs)
+	for _, id := range channelIDs {
+		if recorded, actual := stats.ChannelMessageCounts[id], v.counts[id]; recorded != actual {
+			v.problem(offset, kind, "message count of channel %d is %d, actual %d", id, recorded, actual)
+		}
+	}
+}
+
+func (v *verifier) checkSummaryOffsets() {
+	const kind = "summary offset"
+	for _, so := range v.summaryOffsets {
+		start, end := so.record.GroupStart, so.record.GroupStart+so.record.GroupLength
+		if op, ok := v.records[start]; !ok || op != so.record.GroupOpcode {
+			v.problem(so.offset, kind, "%s group does not start at a %s record", so.record.GroupOpcode, so.record.GroupOpcode)
+			continue
+		}
+		if !v.recordEnds[end] {
+			v.problem(so.offset, kind, "%s group does not end at a record boundary", so.record.GroupOpcode)
+			continue
+		}
+		for offset, op := range v.records {
+			if offset >= start && offset < end && op != so.record.GroupOpcode {
+				v.problem(so.offset, kind, "%s group contains a %s record at offset %d", so.record.GroupOpcode, op, offset)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package mcapverify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"hash/crc32"
+	"io"
+)
+
+// maxPrealloc bounds the buffers allocated from sizes read in the file.
+const maxPrealloc = 64 << 20
+
+// located is a summary record and its offset.
+type located[T any] struct {
+	offset int64
+	record T
+}
+
+func (v *verifier) addSchema(offset int64, kind string, schema *mcap.Schema) {
+	if schema.ID == 0 {
+		v.problem(offset, kind, "schema ID 0 is reserved")
+		return
+	}
+	if known, ok := v.schemas[schema.ID]; ok {
+		if known.Name != schema.Name || known.Encoding != schema.Encoding || !bytes.Equal(known.Data, schema.Data) {
+			v.problem(offset, kind, "schema %d differs from an earlier schema with the same ID", schema.ID)
+		}
+		return
+	}
+	v.schemas[schema.ID] = schema
+	v.schemaIDs = append(v.schemaIDs, schema.ID)
+}
+
+func (v *verifier) addChannel(offset int64, kind string, channel *mcap.Channel) {
+	if channel.SchemaID != 0 && v.schemas[channel.SchemaID] == nil {
+		v.problem(offset, kind, "channel %d (%s) refers to unknown schema %d", channel.ID, channel.Topic, channel.SchemaID)
+	}
+	if known, ok := v.channels[channel.ID]; ok {
+		if known.Topic != channel.Topic || known.SchemaID != channel.SchemaID ||
+			known.MessageEncoding != channel.MessageEncoding {
+			v.problem(offset, kind, "channel %d differs from an earlier channel with the same ID", channel.ID)
+		}
+		return
+	}
+	v.channels[channel.ID] = channel
+	v.channelIDs = append(v.channelIDs, channel.ID)
+}
+
+func (v *verifier) addMessage(offset int64, kind string, msg *mcap.Message) {
+	if v.channels[msg.ChannelID] == nil {
+		v.problem(offset, kind, "message at log time %d refers to unknown channel %d", msg.LogTime, msg.ChannelID)
+	}
+	if v.messageCount == 0 || msg.LogTime < v.messageStartTime {
+		v.messageStartTime = msg.LogTime
+	}
+	if v.messageCount == 0 || msg.LogTime > v.messageEndTime {
+		v.messageEndTime = msg.LogTime
+	}
+	v.messageCount++
+	v.counts[msg.ChannelID]++
+}
+
+// readChunk decompresses a chunk, checks its CRC and reads its records.
+func (v *verifier) readChunk(offset int64, body []byte) {
+	const kind = "chunk"
+	chunk, err := mcap.ParseChunk(body)
+	if err != nil {
+		v.problem(offset, kind, "%s", err)
+		return
+	}
+
+	info := &chunkInfo{
+		index: mcap.ChunkIndex{
+			MessageStartTime:    chunk.MessageStartTime,
+			MessageEndTime:      chunk.MessageEndTime,
+			ChunkStartOffset:    uint64(offset),
+			ChunkLength:         uint64(len(body)) + recordHeaderSize,
+			MessageIndexOffsets: map[uint16]uint64{},
+			Compression:         mcap.CompressionFormat(chunk.Compression),
+			CompressedSize:      uint64(len(chunk.Records)),
+			UncompressedSize:    chunk.UncompressedSize,
+		},
+		messages: map[uint16]map[uint64]uint64{},
+		indexed:  map[uint16]int{},
+	}
+	v.chunks[uint64(offset)] = info
+	v.current = info
+
+	records, err := v.decompress(chunk)
+	if err != nil {
+		v.problem(offset, kind, "failed to decompress %s records: %s", chunk.Compression, err)
+		info.undecoded = true
+		v.undecoded = true
+		return
+	}
+	if uint64(len(records)) != chunk.UncompressedSize {
+		v.problem(offset, kind, "uncompressed size %d does not match actual %d", chunk.UncompressedSize, len(records))
+	}
+	if chunk.UncompressedCRC != 0 {
+		if computed := crc32.ChecksumIEEE(records); computed != chunk.UncompressedCRC {
+			v.problem(offset, kind, "uncompressed CRC %d does not match computed %d", chunk.UncompressedCRC, computed)
+		}
+	}
+
+	var start, end uint64
+	count := 0
+	for pos := 0; pos < len(records); {
+		if len(records)-pos < recordHeaderSize {
+			v.problem(offset, kind, "record at chunk offset %d is truncated", pos)
+			break
+		}
+		op := mcap.OpCode(records[pos])
+		length := binary.LittleEndian.Uint64(records[pos+1:])
+		if length > uint64(len(records)-pos-recordHeaderSize) {
+			v.problem(offset, kind, "%s at chunk offset %d overruns the chunk", op, pos)
+			break
+		}
+		recordBody := records[pos+recordHeaderSize : pos+recordHeaderSize+int(length)]
+		inner := fmt.Sprintf("%s in chunk", op)
+
+		switch op {
+		case mcap.OpSchema:
+			schema, err := mcap.ParseSchema(recordBody)
+			if err != nil {
+				v.problem(offset, inner, "%s", err)
+				break
+			}
+			v.addSchema(offset, inner, schema)
+		case mcap.OpChannel:
+			channel, err := mcap.ParseChannel(recordBody)
+			if err != nil {
+				v.problem(offset, inner, "%s", err)
+				break
+			}
+			v.addChannel(offset, inner, channel)
+		case mcap.OpMessage:
+			msg, err := mcap.ParseMessage(recordBody)
+			if err != nil {
+				v.problem(offset, inner, "%s", err)
+				break
+			}
+			v.addMessage(offset, inner, msg)
+			if info.messages[msg.ChannelID] == nil {
+				info.messages[msg.ChannelID] = map[uint64]uint64{}
+			}
+			info.messages[msg.ChannelID][uint64(pos)] = msg.LogTime
+			if count == 0 || msg.LogTime < start {
+				start = msg.LogTime
+			}
+			if count == 0 || msg.LogTime > end {
+				end = msg.LogTime
+			}
+			count++
+		default:
+			v.problem(offset, inner, "record not allowed in a chunk")
+		}
+		pos += recordHeaderSize + int(length)
+	}
+
+	if count > 0 && (start != chunk.MessageStartTime || end != chunk.MessageEndTime) {
+		v.problem(offset, kind, "message time range [%d, %d] does not match actual [%d, %d]",
+			chunk.MessageStartTime, chunk.MessageEndTime, start, end)
+	}
+}
+
+func (v *verifier) decompress(chunk *mcap.Chunk) ([]byte, error) {
+	switch mcap.CompressionFormat(chunk.Compression) {
+	case mcap.CompressionNone:
+		return chunk.Records, nil
+	case mcap.CompressionZSTD:
+		if v.zstd == nil {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			v.zstd = decoder
+		}
+		return v.zstd.DecodeAll(chunk.Records, make([]byte, 0, min(chunk.UncompressedSize, maxPrealloc)))
+	case mcap.CompressionLZ4:
+		if v.lz4 == nil {
+			v.lz4 = lz4.NewReader(nil)
+		}
+		v.lz4.Reset(bytes.NewReader(chunk.Records))
+		buf := bytes.NewBuffer(make([]byte, 0, min(chunk.UncompressedSize, maxPrealloc)))
+		_, err := io.Copy(buf, v.lz4)
+		return buf.Bytes(), err
+	default:
+		return nil, errors.New("unsupported compression")
+	}
+}
+
+// readMessageIndex checks that every entry of a message index points to a
+// message of its channel and log time in the preceding chunk.
+func (v *verifier) readMessageIndex(offset int64, body []byte) {
+	const kind = "message index"
+	if v.current == nil {
+		v.problem(offset, kind, "does not follow a chunk")
+		return
+	}
+	idx, err := mcap.ParseMessageIndex(body)
+	if err != nil {
+		v.problem(offset, kind, "%s", err)
+		return
+	}
+	chunk := v.current
+	if _, ok := chunk.indexed[idx.ChannelID]; ok {
+		v.problem(offset, kind, "second message index for channel %d", idx.ChannelID)
+	}
+	chunk.indexed[idx.ChannelID] += len(idx.Records)
+	chunk.index.MessageIndexOffsets[idx.ChannelID] = uint64(offset)
+	chunk.index.MessageIndexLength += uint64(len(body)) + recordHeaderSize
+	if chunk.undecoded {
+		return
+	}
+
+	messages := chunk.messages[idx.ChannelID]
+	for _, entry := range idx.Records {
+		logTime, ok := messages[entry.Offset]
+		if !ok {
+			v.problem(offset, kind, "entry at chunk offset %d is not a message of channel %d", entry.Offset, idx.ChannelID)
+			continue
+		}
+		if logTime != entry.Timestamp {
+			v.problem(offset, kind, "entry at chunk offset %d has log time %d, the message has %d",
+				entry.Offset, entry.Timestamp, logTime)
+		}
+	}
+}
+
+// finishChunk checks that the message indexes after the current chunk, if
+// any, cover every message of the chunk.
+func (v *verifier) finishChunk() {
+	chunk := v.current
+	v.current = nil
+	if chunk == nil || chunk.undecoded || len(chunk.indexed) == 0 {
+		return
+	}
+	for channelID, messages := range chunk.messages {
+		if chunk.indexed[channelID] != len(messages) {
+			v.problem(int64(chunk.index.ChunkStartOffset), "chunk",
+				"message indexes of channel %d have %d entries for %d messages",
+				channelID, chunk.indexed[channelID], len(messages))
+		}
+	}
+}
+
+func (v *verifier) readSummaryRecord(offset int64, op mcap.OpCode, body []byte) {
+	kind := op.String()
+	var err error
+	switch op {
+	case mcap.OpChunkIndex:
+		var idx *mcap.ChunkIndex
+		if idx, err = mcap.ParseChunkIndex(body); err == nil {
+			v.chunkIndexes = append(v.chunkIndexes, located[*mcap.ChunkIndex]{offset, idx})
+		}
+	case mcap.OpAttachmentIndex:
+		var idx *mcap.AttachmentIndex
+		if idx, err = mcap.ParseAttachmentIndex(body); err == nil {
+			v.attachmentIndexes = append(v.attachmentIndexes, located[*mcap.AttachmentIndex]{offset, idx})
+		}
+	case mcap.OpMetadataIndex:
+		var idx *mcap.MetadataIndex
+		if idx, err = mcap.ParseMetadataIndex(body); err == nil {
+			v.metadataIndexes = append(v.metadataIndexes, located[*mcap.MetadataIndex]{offset, idx})
+		}
+	case mcap.OpStatistics:
+		if v.statistics != nil {
+			v.problem(offset, kind, "more than one statistics record")
+			return
+		}
+		var stats *mcap.Statistics
+		if stats, err = mcap.ParseStatistics(body); err == nil {
+			v.statistics = &located[*mcap.Statistics]{offset, stats}
+		}
+	case mcap.OpSummaryOffset:
+		var summaryOffset *mcap.SummaryOffset
+		if summaryOffset, err = mcap.ParseSummaryOffset(body); err == nil {
+			if len(v.summaryOffsets) == 0 {
+				v.summaryOffsetStart = uint64(offset)
+			}
+			v.summaryOffsets = append(v.summaryOffsets, located[*mcap.SummaryOffset]{offset, summaryOffset})
+		}
+	}
+	if err != nil {
+		v.problem(offset, kind, "%s", err)
+	}
+}
+
+// readEnd checks the footer and the closing magic bytes. computed is the CRC
+// of the summary section and the footer fields before the CRC.
+func (v *verifier) readEnd(offset int64, body []byte, computed uint32) error {
+	const kind = "footer"
+	v.recordEnds[uint64(offset)] = true
+
+	footer, err := mcap.ParseFooter(body)
+	if err != nil {
+		v.problem(offset, kind, "%s", err)
+		return nil
+	}
+	if v.dataEnd < 0 {
+		v.problem(offset, kind, "no data end record before the footer")
+	} else {
+		if footer.SummaryCRC != 0 && footer.SummaryCRC != computed {
+			v.problem(offset, kind, "summary CRC %d does not match computed %d", footer.SummaryCRC, computed)
+		}
+		hasSummary := v.dataEnd < offset
+		if footer.SummaryStart != 0 && footer.SummaryStart != uint64(v.dataEnd) {
+			v.problem(offset, kind, "summary start %d does not match actual %d", footer.SummaryStart, v.dataEnd)
+		}
+		if footer.SummaryStart == 0 && hasSummary {
+			v.problem(offset, kind, "summary start is 0 but the file has a summary section at %d", v.dataEnd)
+		}
+	}
+	if footer.SummaryOffsetStart != 0 && footer.SummaryOffsetStart != v.summaryOffsetStart {
+		v.problem(offset, kind, "summary offset start %d does not match actual %d",
+			footer.SummaryOffsetStart, v.summaryOffsetStart)
+	}
+
+	magicOffset := v.r.offset
+	magic := make([]byte, len(mcap.Magic))
+	if err := v.read(magic); err != nil {
+		return v.stop(magicOffset, "magic", err)
+	}
+	if !bytes.Equal(magic, mcap.Magic) {
+		v.problem(magicOffset, "magic", "invalid magic bytes %x at end of file", magic)
+	}
+	if n, err := io.Copy(io.Discard, v.r); err != nil {
+		return err
+	} else if n > 0 {
+		v.problem(magicOffset, "magic", "%d bytes after the closing magic bytes", n)
+	}
+
+	v.checkIndexes()
+	return nil
+}
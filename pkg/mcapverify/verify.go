@@ -0,0 +1,460 @@
+// Package mcapverify checks the integrity of MCAP streams: magic bytes,
+// data, chunk, attachment and summary CRCs, message and summary indexes and
+// statistics.
+package mcapverify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// maxProblems is the number of problems kept in a Result, the others are
+	// only counted.
+	maxProblems = 1000
+	// recordHeaderSize is the size of the opcode and length prefix of every
+	// record.
+	recordHeaderSize = 1 + 8
+	// contextCheckInterval is the number of records read between two
+	// cancellation checks.
+	contextCheckInterval = 1024
+)
+
+// Problem is an integrity error of a record.
+type Problem struct {
+	// Offset is the position of the record in the file. Records inside a
+	// chunk are reported at the offset of their chunk.
+	Offset int64
+	// Record is the kind of the record, e.g. "chunk" or "footer".
+	Record string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s at offset %d: %s", p.Record, p.Offset, p.Reason)
+}
+
+// Result lists the problems found by Verify.
+type Result struct {
+	// Size is the number of bytes read.
+	Size     int64
+	Problems []Problem
+	// Omitted is the number of problems found after the first maxProblems.
+	Omitted int
+}
+
+// OK reports whether no problem was found.
+func (r *Result) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify reads the MCAP stream r to its end and checks:
+//   - the magic bytes at its start and end,
+//   - the data section, chunk, attachment and summary CRCs, when the file
+//     has them,
+//   - that every record can be parsed, chunks can be decompressed and
+//     messages refer to known channels,
+//   - that message indexes point to the messages of their chunk,
+//   - that chunk, attachment, metadata and summary offset indexes point to
+//     matching records,
+//   - that the statistics match the records of the file.
+//
+// Integrity problems are listed in the result. An error is only returned
+// when r fails for another reason than ending too early, or ctx is done.
+func Verify(ctx context.Context, r io.Reader) (*Result, error) {
+	v := &verifier{
+		r:           &hashingReader{r: bufio.NewReaderSize(r, 1<<20), crc: crc32.NewIEEE()},
+		result:      &Result{},
+		schemas:     map[uint16]*mcap.Schema{},
+		channels:    map[uint16]*mcap.Channel{},
+		chunks:      map[uint64]*chunkInfo{},
+		attachments: map[uint64]*mcap.AttachmentIndex{},
+		metadata:    map[uint64]*mcap.MetadataIndex{},
+		records:     map[uint64]mcap.OpCode{},
+		recordEnds:  map[uint64]bool{},
+		counts:      map[uint16]uint64{},
+	}
+	defer v.close()
+
+	err := v.run(ctx)
+	v.result.Size = v.r.offset
+	if err != nil {
+		return nil, err
+	}
+	return v.result, nil
+}
+
+// errTruncated stops the scan when the stream ends inside a record.
+var errTruncated = errors.New("truncated")
+
+// hashingReader counts the bytes read and computes their CRC.
+type hashingReader struct {
+	r      io.Reader
+	offset int64
+	crc    hash.Hash32
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.offset += int64(n)
+	_, _ = h.crc.Write(p[:n])
+	return n, err
+}
+
+// chunkInfo is what a chunk index must match.
+type chunkInfo struct {
+	index mcap.ChunkIndex
+	// messages holds the log time of the messages of each channel, by their
+	// offset in the uncompressed records.
+	messages map[uint16]map[uint64]uint64
+	// indexed holds the number of message index entries of each channel.
+	indexed map[uint16]int
+	// undecoded is set when the records of the chunk could not be read.
+	undecoded bool
+}
+
+type verifier struct {
+	r      *hashingReader
+	result *Result
+
+	schemas  map[uint16]*mcap.Schema
+	channels map[uint16]*mcap.Channel
+	chunks   map[uint64]*chunkInfo
+	// attachments and metadata hold the index records matching the
+	// attachments and metadata records read, by offset.
+	attachments map[uint64]*mcap.AttachmentIndex
+	metadata    map[uint64]*mcap.MetadataIndex
+
+	// current is the chunk whose message indexes are being read.
+	current *chunkInfo
+
+	// crcBefore is the CRC of the bytes before the current record.
+	crcBefore uint32
+	// dataEnd is the offset after the DataEnd record, or -1 before it.
+	dataEnd int64
+	// records holds the opcode of the summary records by offset, and
+	// recordEnds the offsets where they end.
+	records    map[uint64]mcap.OpCode
+	recordEnds map[uint64]bool
+
+	chunkIndexes       []located[*mcap.ChunkIndex]
+	attachmentIndexes  []located[*mcap.AttachmentIndex]
+	metadataIndexes    []located[*mcap.MetadataIndex]
+	summaryOffsets     []located[*mcap.SummaryOffset]
+	statistics         *located[*mcap.Statistics]
+	summaryOffsetStart uint64
+
+	// Actual record counts.
+	messageCount     uint64
+	counts           map[uint16]uint64
+	schemaIDs        []uint16
+	channelIDs       []uint16
+	messageStartTime uint64
+	messageEndTime   uint64
+	// undecoded is set when the messages of a chunk could not be counted.
+	undecoded bool
+
+	zstd *zstd.Decoder
+	lz4  *lz4.Reader
+}
+
+func (v *verifier) close() {
+	if v.zstd != nil {
+		v.zstd.Close()
+	}
+}
+
+func (v *verifier) problem(offset int64, record string, format string, args ...any) {
+	if len(v.result.Problems) >= maxProblems {
+		v.result.Omitted++
+		return
+	}
+	v.result.Problems = append(v.result.Problems, Problem{
+		Offset: offset,
+		Record: record,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// read reads exactly len(p) bytes and turns an early end of the stream into
+// errTruncated.
+func (v *verifier) read(p []byte) error {
+	_, err := io.ReadFull(v.r, p)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errTruncated
+	}
+	return err
+}
+
+func (v *verifier) run(ctx context.Context) error {
+	v.dataEnd = -1
+
+	magic := make([]byte, len(mcap.Magic))
+	if err := v.read(magic); err != nil {
+		return v.stop(0, "magic", err)
+	}
+	if !bytes.Equal(magic, mcap.Magic) {
+		v.problem(0, "magic", "invalid magic bytes %x at start of file", magic)
+		return nil
+	}
+
+	for n := 0; ; n++ {
+		if n%contextCheckInterval == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		offset := v.r.offset
+		v.crcBefore = v.r.crc.Sum32()
+		var prefix [recordHeaderSize]byte
+		if err := v.read(prefix[:]); err != nil {
+			if errors.Is(err, errTruncated) && v.r.offset == offset {
+				v.problem(offset, "file", "ends without a footer")
+				v.checkIndexes()
+				return nil
+			}
+			return v.stop(offset, "record", err)
+		}
+		op := mcap.OpCode(prefix[0])
+		length := binary.LittleEndian.Uint64(prefix[1:])
+
+		if n == 0 && op != mcap.OpHeader {
+			v.problem(offset, op.String(), "first record is not a header")
+		}
+		if op != mcap.OpMessageIndex {
+			v.finishChunk()
+		}
+		if v.dataEnd >= 0 {
+			v.records[uint64(offset)] = op
+		}
+
+		if op == mcap.OpAttachment {
+			if err := v.readAttachment(offset, length); err != nil {
+				return v.stop(offset, op.String(), err)
+			}
+			continue
+		}
+
+		if op == mcap.OpFooter {
+			// The summary CRC stops before the CRC field of the footer.
+			if length != 20 {
+				v.problem(offset, op.String(), "invalid length %d", length)
+				return nil
+			}
+			body := make([]byte, 16)
+			if err := v.read(body); err != nil {
+				return v.stop(offset, op.String(), err)
+			}
+			computed := v.r.crc.Sum32()
+			crc := make([]byte, 4)
+			if err := v.read(crc); err != nil {
+				return v.stop(offset, op.String(), err)
+			}
+			return v.readEnd(offset, append(body, crc...), computed)
+		}
+
+		body, err := v.readBody(length)
+		if err != nil {
+			return v.stop(offset, op.String(), err)
+		}
+		v.record(offset, op, body)
+		if v.dataEnd >= 0 {
+			v.recordEnds[uint64(v.r.offset)] = true
+		}
+	}
+}
+
+// stop reports a stream ending inside a record and ends the scan.
+func (v *verifier) stop(offset int64, record string, err error) error {
+	if errors.Is(err, errTruncated) {
+		v.problem(offset, record, "file is truncated at offset %d", v.r.offset)
+		v.checkIndexes()
+		return nil
+	}
+	return err
+}
+
+// readBody reads a record of the given length without allocating it all
+// upfront, since a corrupt length could be huge.
+func (v *verifier) readBody(length uint64) ([]byte, error) {
+	if length <= 64<<20 {
+		body := make([]byte, length)
+		return body, v.read(body)
+	}
+	body, err := io.ReadAll(io.LimitReader(v.r, int64(min(length, 1<<62))))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(body)) < length {
+		return nil, errTruncated
+	}
+	return body, nil
+}
+
+// readAttachment streams an attachment and checks its CRC.
+func (v *verifier) readAttachment(offset int64, length uint64) error {
+	crc := crc32.NewIEEE()
+	r := io.TeeReader(io.LimitReader(v.r, int64(min(length, 1<<62))), crc)
+	readFull := func(p []byte) error {
+		_, err := io.ReadFull(r, p)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errTruncated
+		}
+		return err
+	}
+	readString := func() (string, error) {
+		var size [4]byte
+		if err := readFull(size[:]); err != nil {
+			return "", err
+		}
+		s := make([]byte, min(uint64(binary.LittleEndian.Uint32(size[:])), length))
+		return string(s), readFull(s)
+	}
+
+	var times [16]byte
+	if err := readFull(times[:]); err != nil {
+		return err
+	}
+	name, err := readString()
+	if err != nil {
+		return err
+	}
+	mediaType, err := readString()
+	if err != nil {
+		return err
+	}
+	var size [8]byte
+	if err := readFull(size[:]); err != nil {
+		return err
+	}
+	dataSize := binary.LittleEndian.Uint64(size[:])
+	n, err := io.CopyN(io.Discard, r, int64(min(dataSize, 1<<62)))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if uint64(n) < dataSize {
+		return errTruncated
+	}
+	computed := crc.Sum32()
+	var stored [4]byte
+	if _, err := io.ReadFull(v.r, stored[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errTruncated
+		}
+		return err
+	}
+	if parsed := binary.LittleEndian.Uint32(stored[:]); parsed != 0 && parsed != computed {
+		v.problem(offset, "attachment", "%s: CRC %d does not match computed %d", name, parsed, computed)
+	}
+	if end := uint64(offset) + recordHeaderSize + length; uint64(v.r.offset) != end {
+		v.problem(offset, "attachment", "%s: length %d does not match its fields", name, length)
+		return nil
+	}
+	v.attachments[uint64(offset)] = &mcap.AttachmentIndex{
+		Offset:     uint64(offset),
+		Length:     length + recordHeaderSize,
+		LogTime:    binary.LittleEndian.Uint64(times[:8]),
+		CreateTime: binary.LittleEndian.Uint64(times[8:]),
+		Name:       name,
+		MediaType:  mediaType,
+		DataSize:   dataSize,
+	}
+	return nil
+}
+
+// record checks a record read in full.
+func (v *verifier) record(offset int64, op mcap.OpCode, body []byte) {
+	kind := op.String()
+	inSummary := v.dataEnd >= 0
+
+	switch op {
+	case mcap.OpHeader:
+		if offset != int64(len(mcap.Magic)) {
+			v.problem(offset, kind, "header is not the first record")
+		}
+		if _, err := mcap.ParseHeader(body); err != nil {
+			v.problem(offset, kind, "%s", err)
+		}
+	case mcap.OpSchema:
+		schema, err := mcap.ParseSchema(body)
+		if err != nil {
+			v.problem(offset, kind, "%s", err)
+			return
+		}
+		v.addSchema(offset, kind, schema)
+	case mcap.OpChannel:
+		channel, err := mcap.ParseChannel(body)
+		if err != nil {
+			v.problem(offset, kind, "%s", err)
+			return
+		}
+		v.addChannel(offset, kind, channel)
+	case mcap.OpMessage:
+		if inSummary {
+			v.problem(offset, kind, "message in the summary section")
+			return
+		}
+		msg, err := mcap.ParseMessage(body)
+		if err != nil {
+			v.problem(offset, kind, "%s", err)
+			return
+		}
+		v.addMessage(offset, kind, msg)
+	case mcap.OpChunk:
+		if inSummary {
+			v.problem(offset, kind, "chunk in the summary section")
+			return
+		}
+		v.readChunk(offset, body)
+	case mcap.OpMessageIndex:
+		v.readMessageIndex(offset, body)
+	case mcap.OpMetadata:
+		if inSummary {
+			v.problem(offset, kind, "metadata in the summary section")
+			return
+		}
+		metadata, err := mcap.ParseMetadata(body)
+		if err != nil {
+			v.problem(offset, kind, "%s", err)
+			return
+		}
+		v.metadata[uint64(offset)] = &mcap.MetadataIndex{
+			Offset: uint64(offset),
+			Length: uint64(len(body)) + recordHeaderSize,
+			Name:   metadata.Name,
+		}
+	case mcap.OpDataEnd:
+		if inSummary {
+			v.problem(offset, kind, "more than one data end record")
+			return
+		}
+		dataEnd, err := mcap.ParseDataEnd(body)
+		if err != nil {
+			v.problem(offset, kind, "%s", err)
+		}
+		if dataEnd != nil && dataEnd.DataSectionCRC != 0 {
+			if computed := v.crcBefore; computed != dataEnd.DataSectionCRC {
+				v.problem(offset, kind, "data section CRC %d does not match computed %d", dataEnd.DataSectionCRC, computed)
+			}
+		}
+		// The summary CRC starts after the DataEnd record.
+		v.r.crc.Reset()
+		v.dataEnd = v.r.offset
+	case mcap.OpChunkIndex, mcap.OpAttachmentIndex, mcap.OpMetadataIndex, mcap.OpStatistics, mcap.OpSummaryOffset:
+		if !inSummary {
+			v.problem(offset, kind, "summary record in the data section")
+		}
+		v.readSummaryRecord(offset, op, body)
+	default:
+		// Unknown records are allowed by the specification.
+	}
+}
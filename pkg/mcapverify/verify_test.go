@@ -0,0 +1,113 @@
+package mcapverify
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, opts *mcap.WriterOptions) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, opts)
+	assert.NoError(t, err)
+
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg", Data: []byte("string data")}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 2, SchemaID: 1, Topic: "/b", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMetadata(&mcap.Metadata{Name: "run", Metadata: map[string]string{"k": "v"}}))
+	assert.NoError(t, writer.WriteAttachment(&mcap.Attachment{
+		Name:      "calib.yaml",
+		MediaType: "text/yaml",
+		LogTime:   100,
+		DataSize:  4,
+		Data:      bytes.NewReader([]byte("a: 1")),
+	}))
+
+	for i := uint64(0); i < 10; i++ {
+		for _, channelID := range []uint16{1, 2} {
+			assert.NoError(t, writer.WriteMessage(&mcap.Message{
+				ChannelID:   channelID,
+				LogTime:     100 + i*10,
+				PublishTime: 100 + i*10,
+				Data:        []byte{1, 0, 0, 0, 'x'},
+			}))
+		}
+	}
+	assert.NoError(t, writer.Close())
+
+	return buf.Bytes()
+}
+
+func verify(t *testing.T, data []byte) *Result {
+	t.Helper()
+	result, err := Verify(context.Background(), bytes.NewReader(data))
+	assert.NoError(t, err)
+	return result
+}
+
+func TestVerifyValidFiles(t *testing.T) {
+	for name, opts := range map[string]*mcap.WriterOptions{
+		"zstd":      {Chunked: true, ChunkSize: 256, Compression: mcap.CompressionZSTD, IncludeCRC: true},
+		"lz4":       {Chunked: true, ChunkSize: 256, Compression: mcap.CompressionLZ4, IncludeCRC: true},
+		"none":      {Chunked: true, ChunkSize: 256, Compression: mcap.CompressionNone},
+		"unchunked": {IncludeCRC: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := writeTestFile(t, opts)
+			result := verify(t, data)
+			assert.Empty(t, result.Problems)
+			assert.Equal(t, int64(len(data)), result.Size)
+		})
+	}
+}
+
+func TestVerifyCorruptChunk(t *testing.T) {
+	data := writeTestFile(t, &mcap.WriterOptions{Chunked: true, ChunkSize: 256, Compression: mcap.CompressionNone, IncludeCRC: true})
+
+	// Flip the last byte of the data of the first message.
+	i := bytes.Index(data, []byte{1, 0, 0, 0, 'x'})
+	assert.Positive(t, i)
+	data[i+4] = 'y'
+
+	result := verify(t, data)
+	assert.False(t, result.OK())
+	assert.Equal(t, "chunk", result.Problems[0].Record)
+	assert.Contains(t, result.Problems[0].Reason, "uncompressed CRC")
+	assert.Equal(t, "data end", result.Problems[1].Record)
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	data := writeTestFile(t, &mcap.WriterOptions{Chunked: true, ChunkSize: 256, Compression: mcap.CompressionZSTD, IncludeCRC: true})
+
+	result := verify(t, data[:len(data)/2])
+	assert.Len(t, result.Problems, 1)
+	assert.Contains(t, result.Problems[0].Reason, "truncated")
+
+	result = verify(t, append(data[:len(data)-1:len(data)-1], 'x'))
+	assert.Len(t, result.Problems, 1)
+	assert.Equal(t, "magic", result.Problems[0].Record)
+}
+
+func TestVerifyStatistics(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true, Compression: mcap.CompressionZSTD, IncludeCRC: true})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteHeader(&mcap.Header{Profile: "ros1"}))
+	assert.NoError(t, writer.WriteSchema(&mcap.Schema{ID: 1, Name: "std_msgs/String", Encoding: "ros1msg"}))
+	assert.NoError(t, writer.WriteChannel(&mcap.Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.NoError(t, writer.WriteMessage(&mcap.Message{ChannelID: 1, LogTime: 100, Data: []byte{0, 0, 0, 0}}))
+	writer.Statistics.MessageCount++
+	writer.Statistics.ChannelMessageCounts[1]++
+	assert.NoError(t, writer.Close())
+
+	result := verify(t, buf.Bytes())
+	assert.Len(t, result.Problems, 2)
+	assert.Equal(t, "statistics", result.Problems[0].Record)
+	assert.Equal(t, "message count 2 does not match actual 1", result.Problems[0].Reason)
+	assert.Equal(t, "message count of channel 1 is 2, actual 1", result.Problems[1].Reason)
+}